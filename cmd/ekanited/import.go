@@ -0,0 +1,140 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ekanite/ekanite"
+	"github.com/ekanite/ekanite/input"
+	"github.com/ekanite/ekanite/parser"
+)
+
+// runImport indexes the log lines in the files named by the given arguments,
+// or read from stdin if none are named, directly into the engine. The files
+// may be plain, gzip, or bzip2 compressed. Ekanite must not be running with
+// the same data directory.
+func runImport(args []string) {
+	ifs := flag.NewFlagSet("import", flag.ExitOnError)
+	var (
+		datadir         = ifs.String("datadir", DefaultDataDir, "Set data directory")
+		batchSize       = ifs.Int("batchsize", ekanite.DefaultImportBatchSize, "Indexing batch size")
+		numShards       = ifs.Int("numshards", DefaultNumShards, "Set number of shards per index")
+		retentionPeriod = ifs.String("retention", DefaultRetentionPeriod, "Data retention period. Log lines older than this are skipped")
+		inputFormat     = ifs.String("input", DefaultInputFormat, "Message format of input (syslog, bsd, M200, json, gelf, or auto to detect the format of each message)")
+		rfc3164TZ       = ifs.String("bsdtz", "Local", "Time zone of bsd input timestamps, such as UTC or America/New_York")
+		rfc3164Year     = ifs.Int("bsdyear", 0, "Year of bsd input timestamps. If not set, the year is inferred from the current time")
+		jsonTimestamp   = ifs.String("jsonts", parser.DefaultJSONTimestampKey, "Key of the RFC3339 timestamp of json input")
+		rfc5424JSON     = ifs.Bool("syslogjson", false, "Also parse the fields of syslog input whose message is a JSON object")
+		unparsed        = ifs.String("unparsed", DefaultUnparsed, "Handling of messages which cannot be parsed: drop, index, or deadletter to write them to a file in the data directory")
+	)
+	ifs.Usage = func() {
+		fmt.Println("ekanited import [options] [file ...]")
+		ifs.PrintDefaults()
+	}
+	ifs.Parse(args)
+
+	log.SetFlags(log.LstdFlags)
+	log.SetPrefix("[import] ")
+
+	absDataDir, err := filepath.Abs(*datadir)
+	if err != nil {
+		log.Fatalf("failed to get absolute data path for '%s': %s", *datadir, err.Error())
+	}
+	retention, err := time.ParseDuration(*retentionPeriod)
+	if err != nil {
+		log.Fatalf("failed to parse retention period '%s'", *retentionPeriod)
+	}
+	parserConfig, err := configureParsing(*rfc3164TZ, *rfc3164Year, *jsonTimestamp, *rfc5424JSON)
+	if err != nil {
+		log.Fatalf("failed to configure input parsing: %s", err.Error())
+	}
+	if !input.ValidFormat(*inputFormat) {
+		log.Fatalf("failed to create parser: %s is not a valid parser format", *inputFormat)
+	}
+
+	engine := ekanite.NewEngine(absDataDir)
+	engine.NumShards = *numShards
+	engine.RetentionPeriod = retention
+	if err := engine.Open(); err != nil {
+		log.Fatalf("failed to open engine: %s", err.Error())
+	}
+
+	deadLetter, err := configureUnparsed(*unparsed, absDataDir, &parserConfig)
+	if err != nil {
+		engine.Close()
+		log.Fatalf("failed to configure handling of unparsed messages: %s", err.Error())
+	}
+
+	// The format was validated above, so the parser can be created.
+	p, err := input.NewParser(*inputFormat, parserConfig)
+	if err != nil {
+		panic(fmt.Sprintf("failed to create parser: %s", err.Error()))
+	}
+
+	// Log lines which would be deleted by retention enforcement are not indexed.
+	importer := ekanite.NewImporter(engine)
+	importer.BatchSize = *batchSize
+	importer.Since = time.Now().Add(-retention)
+
+	paths := ifs.Args()
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	var total ekanite.ImportStats
+	var importErr error
+	for _, path := range paths {
+		st, err := importFile(importer, path, p)
+		log.Printf("imported %d of %d log lines from %s, %d unparsed and dropped, %d older than retention period",
+			st.Imported, st.Lines, path, st.Dropped, st.Skipped)
+		total.Lines += st.Lines
+		total.Imported += st.Imported
+		total.Dropped += st.Dropped
+		total.Skipped += st.Skipped
+		if err != nil {
+			importErr = fmt.Errorf("failed to import %s: %s", path, err.Error())
+			break
+		}
+	}
+	if len(paths) > 1 {
+		log.Printf("imported %d of %d log lines in total", total.Imported, total.Lines)
+	}
+
+	// Close the engine and dead-letter file before exiting on any error, so
+	// that the log lines already imported are kept.
+	failed := importErr != nil
+	if err := engine.Close(); err != nil {
+		log.Printf("failed to close engine: %s", err.Error())
+		failed = true
+	}
+	if deadLetter != nil {
+		if err := deadLetter.Close(); err != nil {
+			log.Printf("failed to close dead-letter file: %s", err.Error())
+			failed = true
+		}
+	}
+	if importErr != nil {
+		log.Print(importErr.Error())
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// importFile imports the file at the given path, or stdin if the path is "-".
+func importFile(importer *ekanite.Importer, path string, p *input.LogHandler) (ekanite.ImportStats, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return ekanite.ImportStats{}, err
+		}
+		defer f.Close()
+		r = f
+	}
+	return importer.Import(r, p)
+}
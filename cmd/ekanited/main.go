@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
@@ -15,11 +16,14 @@ import (
 	"path/filepath"
 	"runtime"
 	"runtime/pprof"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/ekanite/ekanite"
 	"github.com/ekanite/ekanite/input"
+	"github.com/ekanite/ekanite/parser"
 	"github.com/ekanite/ekanite/status"
 )
 
@@ -60,27 +64,63 @@ const (
 	DefaultDiagsIface      = "localhost:9951"
 	DefaultTCPServer       = "localhost:5514"
 	DefaultInputFormat     = "syslog"
+	DefaultUnparsed        = "drop"
+	DeadLetterFileName     = "deadletter.log"
+	FileCheckpointName     = "file_offsets.json"
+	DefaultShutdownTimeout = 30 * time.Second
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		runImport(os.Args[2:])
+		return
+	}
+
 	fs = flag.NewFlagSet("", flag.ExitOnError)
 	var (
-		datadir         = fs.String("datadir", DefaultDataDir, "Set data directory")
-		batchSize       = fs.Int("batchsize", DefaultBatchSize, "Indexing batch size")
-		batchTimeout    = fs.Int("batchtime", DefaultBatchTimeout, "Indexing batch timeout, in milliseconds")
-		indexMaxPending = fs.Int("maxpending", DefaultIndexMaxPending, "Maximum pending index events")
-		tcpIface        = fs.String("tcp", DefaultTCPServer, "Syslog server TCP bind address in the form host:port. To disable set to empty string")
-		udpIface        = fs.String("udp", "", "Syslog server UDP bind address in the form host:port. If not set, not started")
-		diagIface       = fs.String("diag", DefaultDiagsIface, "expvar and pprof bind address in the form host:port. If not set, not started")
-		caPemPath       = fs.String("tlspem", "", "path to CA PEM file for TLS-enabled TCP server. If not set, TLS not activated")
-		caKeyPath       = fs.String("tlskey", "", "path to CA key file for TLS-enabled TCP server. If not set, TLS not activated")
-		queryIface      = fs.String("query", DefaultQueryAddr, "TCP Bind address for query server in the form host:port. To disable set to empty string")
-		queryIfaceHttp  = fs.String("queryhttp", DefaultHTTPQueryAddr, "TCP Bind address for http query server in the form host:port. To disable set to empty string")
-		numShards       = fs.Int("numshards", DefaultNumShards, "Set number of shards per index")
-		retentionPeriod = fs.String("retention", DefaultRetentionPeriod, "Data retention period. Minimum is 24 hours")
-		cpuProfile      = fs.String("cpuprof", "", "Where to write CPU profiling data. Not written if not set")
-		memProfile      = fs.String("memprof", "", "Where to write memory profiling data. Not written if not set")
-		inputFormat     = fs.String("input", DefaultInputFormat, "Message format of input (only syslog supported)")
+		datadir          = fs.String("datadir", DefaultDataDir, "Set data directory")
+		batchSize        = fs.Int("batchsize", DefaultBatchSize, "Indexing batch size")
+		batchTimeout     = fs.Int("batchtime", DefaultBatchTimeout, "Indexing batch timeout, in milliseconds")
+		indexMaxPending  = fs.Int("maxpending", DefaultIndexMaxPending, "Maximum pending index events")
+		indexRetries     = fs.Int("indexretries", ekanite.DefaultBatchMaxRetries, "Number of times log messages which fail to be indexed are retried before being dropped")
+		queueEnabled     = fs.Bool("queue", false, "Write received events to a queue in the data directory before indexing, so that events not yet indexed are not lost if Ekanite stops")
+		tcpIface         = fs.String("tcp", DefaultTCPServer, "Syslog server TCP bind address in the form host:port. To disable set to empty string")
+		udpIface         = fs.String("udp", "", "Syslog server UDP bind address in the form host:port. If not set, not started")
+		httpIface        = fs.String("http", "", "HTTP ingestion bind address in the form host:port. If not set, not started")
+		relpIface        = fs.String("relp", "", "RELP server bind address in the form host:port. Messages are acknowledged once indexed, or queued if the queue is enabled. If not set, not started")
+		tcpMaxSize       = fs.Int("tcpmaxsize", input.DefaultMaxMessageSize, "Maximum size of messages received over TCP, in bytes. Longer messages are truncated")
+		udpMaxSize       = fs.Int("udpmaxsize", input.DefaultMaxMessageSize, fmt.Sprintf("Maximum size of messages received over UDP, in bytes, at most %d. Longer messages are truncated", input.MaxUDPMessageSize))
+		relpMaxSize      = fs.Int("relpmaxsize", input.DefaultMaxMessageSize, "Maximum size of messages received over RELP, in bytes. Longer messages are truncated")
+		httpMaxSize      = fs.Int("httpmaxsize", input.DefaultMaxMessageSize, "Maximum size of log lines received over HTTP, in bytes. Longer log lines are rejected")
+		markTruncated    = fs.Bool("marktruncated", false, "Set the field truncated on messages which were truncated")
+		gelfUDPIface     = fs.String("gelfudp", "", "GELF UDP bind address in the form host:port. If not set, not started")
+		gelfTCPIface     = fs.String("gelftcp", "", "GELF TCP bind address in the form host:port. If not set, not started")
+		unixgramPath     = fs.String("unixgram", "", "Path of Unix datagram socket to receive syslog messages on, such as /dev/log. If not set, not started")
+		unixPath         = fs.String("unix", "", "Path of Unix stream socket to receive syslog messages on. If not set, not started")
+		unixMaxSize      = fs.Int("unixmaxsize", input.DefaultMaxMessageSize, "Maximum size of messages received on Unix sockets, in bytes. Longer messages are truncated")
+		unixMode         = fs.String("unixmode", fmt.Sprintf("%o", input.DefaultUnixSocketMode), "Permissions of Unix sockets, in octal")
+		filePatterns     = fs.String("files", "", "Comma-separated glob patterns of log files to follow, such as /var/log/app/*.log. If not set, not started")
+		fileFormat       = fs.String("fileinput", "", "Message format of followed log files. If not set, the format of input is used")
+		diagIface        = fs.String("diag", DefaultDiagsIface, "expvar and pprof bind address in the form host:port. If not set, not started")
+		caPemPath        = fs.String("tlspem", "", "path to CA PEM file for TLS-enabled TCP server. If not set, TLS not activated")
+		caKeyPath        = fs.String("tlskey", "", "path to CA key file for TLS-enabled TCP server. If not set, TLS not activated")
+		queryIface       = fs.String("query", DefaultQueryAddr, "TCP Bind address for query server in the form host:port. To disable set to empty string")
+		queryIfaceHttp   = fs.String("queryhttp", DefaultHTTPQueryAddr, "TCP Bind address for http query server in the form host:port. To disable set to empty string")
+		numShards        = fs.Int("numshards", DefaultNumShards, "Set number of shards per index")
+		retentionPeriod  = fs.String("retention", DefaultRetentionPeriod, "Data retention period. Minimum is 24 hours")
+		retentionSize    = fs.Int64("retentionsize", 0, "Maximum size of the data directory, in megabytes. The oldest indexes are deleted once it is exceeded. If not set, not limited")
+		minFreeSpace     = fs.Int64("minfreespace", 0, "Minimum free space on the data directory's filesystem, in megabytes. The oldest indexes are deleted once there is less. If not set, not limited")
+		cpuProfile       = fs.String("cpuprof", "", "Where to write CPU profiling data. Not written if not set")
+		memProfile       = fs.String("memprof", "", "Where to write memory profiling data. Not written if not set")
+		inputFormat      = fs.String("input", DefaultInputFormat, "Message format of input (syslog, bsd, M200, json, gelf, or auto to detect the format of each message)")
+		rfc3164TZ        = fs.String("bsdtz", "Local", "Time zone of bsd input timestamps, such as UTC or America/New_York")
+		rfc3164Year      = fs.Int("bsdyear", 0, "Year of bsd input timestamps. If not set, the year is inferred from the current time")
+		jsonTimestamp    = fs.String("jsonts", parser.DefaultJSONTimestampKey, "Key of the RFC3339 timestamp of json input")
+		rfc5424JSON      = fs.Bool("syslogjson", false, "Also parse the fields of syslog input whose message is a JSON object")
+		unparsed         = fs.String("unparsed", DefaultUnparsed, "Handling of messages which cannot be parsed: drop, index, or deadletter to write them to a file in the data directory")
+		multilinePattern = fs.String("multiline", "", "Regular expression matching the messages of continuation lines, such as stack trace lines, which are joined to the previous message from the same host, app, and process. If not set, lines are not joined")
+		multilineWindow  = fs.Duration("multilinewindow", input.DefaultMultilineWindow, "Time within which continuation lines must follow the previous line to be joined")
+		shutdownTimeout  = fs.Duration("shutdowntimeout", DefaultShutdownTimeout, "Time allowed on shutdown for collectors to receive messages already sent, and for them to be indexed")
 	)
 	fs.Usage = printHelp
 	fs.Parse(os.Args[1:])
@@ -96,6 +136,11 @@ func main() {
 		log.Fatalf("failed to parse retention period '%s'", *retentionPeriod)
 	}
 
+	parserConfig, err := configureParsing(*rfc3164TZ, *rfc3164Year, *jsonTimestamp, *rfc5424JSON)
+	if err != nil {
+		log.Fatalf("failed to configure input parsing: %s", err.Error())
+	}
+
 	log.SetFlags(log.LstdFlags)
 	log.SetPrefix("[ekanite] ")
 	log.Printf("ekanite started using %s for index storage", absDataDir)
@@ -104,20 +149,50 @@ func main() {
 	log.Println("GOMAXPROCS set to", runtime.GOMAXPROCS(0))
 
 	// Start the expvar handler if requested.
+	var diagServer *status.Service
 	if *diagIface != "" {
-		startDiagServer(*diagIface)
+		diagServer = startDiagServer(*diagIface)
+	}
+
+	parserConfig.MarkTruncated = *markTruncated
+
+	// Configure handling of messages which cannot be parsed, and events which
+	// cannot be indexed. The dead letter file is held in the data directory.
+	if err := os.MkdirAll(absDataDir, 0755); err != nil {
+		log.Fatalf("failed to create data directory: %s", err.Error())
+	}
+	deadLetter, err := configureUnparsed(*unparsed, absDataDir, &parserConfig)
+	if err != nil {
+		log.Fatalf("failed to configure handling of unparsed messages: %s", err.Error())
 	}
 
 	// Create and open the Engine.
 	engine := ekanite.NewEngine(absDataDir)
 	engine.NumShards = *numShards
 	engine.RetentionPeriod = retention
+	engine.MaxSize = *retentionSize * 1024 * 1024
+	engine.MinFreeSpace = *minFreeSpace * 1024 * 1024
+
+	var queue *ekanite.Queue
+	if *queueEnabled {
+		queue = ekanite.NewQueue(filepath.Join(absDataDir, ekanite.QueueDirName))
+		if deadLetter != nil {
+			queue.DeadLetter = deadLetter
+		}
+		engine.Queue = queue
+	}
 
 	if err := engine.Open(); err != nil {
 		log.Fatalf("failed to open engine: %s", err.Error())
 	}
 	log.Printf("engine opened with shard number of %d, retention period of %s",
 		engine.NumShards, engine.RetentionPeriod)
+	if engine.MaxSize > 0 {
+		log.Printf("engine retention limited to data size of %dMB", *retentionSize)
+	}
+	if engine.MinFreeSpace > 0 {
+		log.Printf("engine retention limited to keep free space of %dMB", *minFreeSpace)
+	}
 
 	// Start the simple query server if requested.
 	if *queryIface != "" {
@@ -132,6 +207,10 @@ func main() {
 	// Create and start the batcher.
 	batcherTimeout := time.Duration(*batchTimeout) * time.Millisecond
 	batcher := ekanite.NewBatcher(engine, *batchSize, batcherTimeout, *indexMaxPending)
+	batcher.MaxRetries = *indexRetries
+	if deadLetter != nil {
+		batcher.DeadLetter = deadLetter
+	}
 
 	errChan := make(chan error)
 	if err := batcher.Start(errChan); err != nil {
@@ -140,8 +219,44 @@ func main() {
 	log.Printf("batching configured with size %d, timeout %s, max pending %d",
 		*batchSize, batcherTimeout, *indexMaxPending)
 
+	// Components are stopped on shutdown in the reverse of the order they were
+	// started, so that each has received all events before it is stopped.
+	var stoppers []stopper
+	stopping := func(name string, stop func(context.Context) error) {
+		stoppers = append([]stopper{{name, stop}}, stoppers...)
+	}
+	stopping("batcher", batcher.Stop)
+
 	// Start draining batcher errors.
 	go drainLog("error indexing batch", errChan)
+	if diagServer != nil {
+		diagServer.Register("batcher", batcher)
+	}
+
+	// Queue events durably before batching if requested.
+	events := batcher.C()
+	if queue != nil {
+		if err := queue.Start(events); err != nil {
+			log.Fatalf("failed to start queue: %s", err.Error())
+		}
+		stopping("queue", queue.Stop)
+		events = queue.C()
+		log.Printf("queueing events in %s", filepath.Join(absDataDir, ekanite.QueueDirName))
+	}
+
+	// Join continuation lines before batching if requested.
+	if *multilinePattern != "" {
+		multiline, err := input.NewMultiline(*multilinePattern, *multilineWindow)
+		if err != nil {
+			log.Fatalf("failed to create multiline joiner: %s", err.Error())
+		}
+		if err := multiline.Start(events); err != nil {
+			log.Fatalf("failed to start multiline joiner: %s", err.Error())
+		}
+		stopping("multiline joiner", multiline.Stop)
+		events = multiline.C()
+		log.Printf("joining continuation lines matching %s within %s", *multilinePattern, *multilineWindow)
+	}
 
 	// Start TCP collector if requested.
 	if *tcpIface != "" {
@@ -154,53 +269,249 @@ func main() {
 			log.Printf("TLS successfully configured")
 		}
 
-		if err := startTCPCollector(*tcpIface, *inputFormat, tlsConfig, batcher); err != nil {
+		collector, err := startTCPCollector(*tcpIface, *inputFormat, parserConfig, *tcpMaxSize, tlsConfig, events)
+		if err != nil {
 			log.Fatalf("failed to start TCP collector: %s", err.Error())
 		}
+		stopping("TCP collector", collector.Stop)
 		log.Printf("TCP collector listening to %s", *tcpIface)
 	}
 
 	// Start UDP collector if requested.
 	if *udpIface != "" {
-		if err := startUDPCollector(*udpIface, *inputFormat, batcher); err != nil {
+		collector, err := startUDPCollector(*udpIface, *inputFormat, parserConfig, *udpMaxSize, events)
+		if err != nil {
 			log.Fatalf("failed to start UDP collector: %s", err.Error())
 		}
+		stopping("UDP collector", collector.Stop)
 		log.Printf("UDP collector listening to %s", *udpIface)
 	}
 
+	// Start RELP collector if requested.
+	if *relpIface != "" {
+		var tlsConfig *tls.Config
+		if *caPemPath != "" && *caKeyPath != "" {
+			tlsConfig, err = newTLSConfig(*caPemPath, *caKeyPath)
+			if err != nil {
+				log.Fatalf("failed to configure TLS: %s", err.Error())
+			}
+		}
+
+		collector, err := startRELPCollector(*relpIface, *inputFormat, parserConfig, *relpMaxSize, tlsConfig, events)
+		if err != nil {
+			log.Fatalf("failed to start RELP collector: %s", err.Error())
+		}
+		stopping("RELP collector", collector.Stop)
+		log.Printf("RELP collector listening to %s", *relpIface)
+	}
+
+	// Start HTTP collector if requested.
+	if *httpIface != "" {
+		var tlsConfig *tls.Config
+		if *caPemPath != "" && *caKeyPath != "" {
+			tlsConfig, err = newTLSConfig(*caPemPath, *caKeyPath)
+			if err != nil {
+				log.Fatalf("failed to configure TLS: %s", err.Error())
+			}
+		}
+
+		collector, err := startHTTPCollector(*httpIface, *inputFormat, parserConfig, *httpMaxSize, tlsConfig, events)
+		if err != nil {
+			log.Fatalf("failed to start HTTP collector: %s", err.Error())
+		}
+		stopping("HTTP collector", collector.Stop)
+		log.Printf("HTTP collector listening to %s", *httpIface)
+	}
+
+	// Start GELF collectors if requested.
+	for proto, iface := range map[string]string{"udp": *gelfUDPIface, "tcp": *gelfTCPIface} {
+		if iface == "" {
+			continue
+		}
+		collector, err := startGELFCollector(proto, iface, parserConfig, events)
+		if err != nil {
+			log.Fatalf("failed to start GELF %s collector: %s", proto, err.Error())
+		}
+		stopping("GELF "+proto+" collector", collector.Stop)
+		log.Printf("GELF %s collector listening to %s", proto, iface)
+	}
+
+	// Start file collector if requested.
+	if *filePatterns != "" {
+		format := *fileFormat
+		if format == "" {
+			format = *inputFormat
+		}
+		patterns := strings.Split(*filePatterns, ",")
+		collector, err := startFileCollector(patterns, format, parserConfig, filepath.Join(absDataDir, FileCheckpointName), events)
+		if err != nil {
+			log.Fatalf("failed to start file collector: %s", err.Error())
+		}
+		stopping("file collector", collector.Stop)
+		log.Printf("file collector following %s", *filePatterns)
+	}
+
+	// Start Unix socket collectors if requested.
+	if *unixgramPath != "" || *unixPath != "" {
+		mode, err := strconv.ParseUint(*unixMode, 8, 32)
+		if err != nil {
+			log.Fatalf("failed to parse Unix socket permissions '%s'", *unixMode)
+		}
+		for proto, path := range map[string]string{"unixgram": *unixgramPath, "unix": *unixPath} {
+			if path == "" {
+				continue
+			}
+			collector, err := startUnixCollector(proto, path, *inputFormat, parserConfig, *unixMaxSize, os.FileMode(mode), events)
+			if err != nil {
+				log.Fatalf("failed to start %s collector: %s", proto, err.Error())
+			}
+			stopping(proto+" collector", collector.Stop)
+			log.Printf("%s collector listening on %s", proto, path)
+		}
+	}
+
 	// Start profiling.
 	startProfile(*cpuProfile, *memProfile)
 
 	// Wait forever for signals.
 	waitForSignals()
 
+	// Stop accepting log messages, and index those already received, before
+	// closing the engine.
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	shutdown(ctx, stoppers)
+	cancel()
+
 	engine.Close()
+	if deadLetter != nil {
+		deadLetter.Close()
+	}
 
 	stopProfile()
 }
 
-func startTCPCollector(iface, format string, tls *tls.Config, batcher *ekanite.Batcher) error {
-	collector, err := input.NewCollector("tcp", iface, format, tls)
+func startTCPCollector(iface, format string, config input.ParserConfig, maxSize int, tls *tls.Config, c chan<- *input.Event) (input.Collector, error) {
+	collector, err := input.NewCollector("tcp", iface, format, config, maxSize, tls)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create TCP collector: %s", err.Error())
+	}
+	if err := collector.Start(c); err != nil {
+		return nil, fmt.Errorf("failed to start TCP collector: %s", err.Error())
+	}
+
+	return collector, nil
+}
+
+func startRELPCollector(iface, format string, config input.ParserConfig, maxSize int, tls *tls.Config, c chan<- *input.Event) (input.Collector, error) {
+	collector, err := input.NewCollector("relp", iface, format, config, maxSize, tls)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create RELP collector: %s", err.Error())
+	}
+	if err := collector.Start(c); err != nil {
+		return nil, fmt.Errorf("failed to start RELP collector: %s", err.Error())
+	}
+
+	return collector, nil
+}
+
+func startUDPCollector(iface, format string, config input.ParserConfig, maxSize int, c chan<- *input.Event) (input.Collector, error) {
+	collector, err := input.NewCollector("udp", iface, format, config, maxSize, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create UDP collector: %s", err.Error())
+	}
+	if err := collector.Start(c); err != nil {
+		return nil, fmt.Errorf("failed to start UDP collector: %s", err.Error())
+	}
+
+	return collector, nil
+}
+
+// configureParsing returns the configuration of the parsers created for all
+// input formats.
+func configureParsing(rfc3164TZ string, rfc3164Year int, jsonTimestamp string, rfc5424JSON bool) (input.ParserConfig, error) {
+	// Configure inference of the year and time zone of RFC3164 timestamps.
+	loc, err := time.LoadLocation(rfc3164TZ)
 	if err != nil {
-		return fmt.Errorf(("failed to create TCP collector: %s"), err.Error())
+		return input.ParserConfig{}, fmt.Errorf("failed to load time zone '%s': %s", rfc3164TZ, err.Error())
 	}
-	if err := collector.Start(batcher.C()); err != nil {
-		return fmt.Errorf("failed to start TCP collector: %s", err.Error())
+	return input.ParserConfig{
+		RFC3164Location:  loc,
+		RFC3164Year:      rfc3164Year,
+		JSONTimestampKey: jsonTimestamp,
+		RFC5424JSON:      rfc5424JSON,
+	}, nil
+}
+
+// configureUnparsed sets the policy of the given parser configuration for
+// messages which cannot be parsed. If the policy writes such messages to a
+// file, the file is returned.
+func configureUnparsed(policy, dataDir string, config *input.ParserConfig) (*input.DeadLetterFile, error) {
+	switch policy {
+	case "drop":
+		config.Unparsed = input.DropUnparsed{}
+	case "index":
+		config.Unparsed = input.IndexUnparsed{}
+		log.Printf("unparsed messages will be indexed with the field %s", input.ParseErrorField)
+	case "deadletter":
+		d, err := input.NewDeadLetterFile(filepath.Join(dataDir, DeadLetterFileName))
+		if err != nil {
+			return nil, err
+		}
+		config.Unparsed = d
+		log.Printf("unparsed messages will be written to %s", d.Path())
+		return d, nil
+	default:
+		return nil, fmt.Errorf("unknown policy '%s'", policy)
 	}
+	return nil, nil
+}
 
-	return nil
+func startHTTPCollector(iface, format string, config input.ParserConfig, maxSize int, tls *tls.Config, c chan<- *input.Event) (input.Collector, error) {
+	collector, err := input.NewCollector("http", iface, format, config, maxSize, tls)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP collector: %s", err.Error())
+	}
+	if err := collector.Start(c); err != nil {
+		return nil, fmt.Errorf("failed to start HTTP collector: %s", err.Error())
+	}
+
+	return collector, nil
 }
 
-func startUDPCollector(iface, format string, batcher *ekanite.Batcher) error {
-	collector, err := input.NewCollector("udp", iface, format, nil)
+func startGELFCollector(proto, iface string, config input.ParserConfig, c chan<- *input.Event) (input.Collector, error) {
+	collector, err := input.NewGELFCollector(proto, iface, config)
 	if err != nil {
-		return fmt.Errorf("failed to create UDP collector: %s", err.Error())
+		return nil, fmt.Errorf("failed to create GELF %s collector: %s", proto, err.Error())
 	}
-	if err := collector.Start(batcher.C()); err != nil {
-		return fmt.Errorf("failed to start UDP collector: %s", err.Error())
+	if err := collector.Start(c); err != nil {
+		return nil, fmt.Errorf("failed to start GELF %s collector: %s", proto, err.Error())
 	}
 
-	return nil
+	return collector, nil
+}
+
+func startFileCollector(patterns []string, format string, config input.ParserConfig, checkpoint string, c chan<- *input.Event) (input.Collector, error) {
+	collector, err := input.NewFileCollector(patterns, format, config, checkpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file collector: %s", err.Error())
+	}
+	if err := collector.Start(c); err != nil {
+		return nil, fmt.Errorf("failed to start file collector: %s", err.Error())
+	}
+
+	return collector, nil
+}
+
+func startUnixCollector(proto, path, format string, config input.ParserConfig, maxSize int, mode os.FileMode, c chan<- *input.Event) (input.Collector, error) {
+	collector, err := input.NewUnixCollector(proto, path, format, config, maxSize, mode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s collector: %s", proto, err.Error())
+	}
+	if err := collector.Start(c); err != nil {
+		return nil, fmt.Errorf("failed to start %s collector: %s", proto, err.Error())
+	}
+
+	return collector, nil
 }
 
 func startQueryServer(iface string, engine *ekanite.Engine) {
@@ -225,12 +536,13 @@ func startHTTPQueryServer(iface string, engine *ekanite.Engine) {
 	log.Printf("HTTP query server listening on %s", iface)
 }
 
-func startDiagServer(iface string) {
+func startDiagServer(iface string) *status.Service {
 	diagServer := status.NewService(iface)
 	if err := diagServer.Start(); err != nil {
 		log.Fatalf("failed to start status server on %s: %s", iface, err.Error())
 	}
 	log.Printf("diagnostic server listening on %s", iface)
+	return diagServer
 }
 
 func newTLSConfig(caPemPath, caKeyPath string) (*tls.Config, error) {
@@ -272,6 +584,24 @@ func newTLSConfig(caPemPath, caKeyPath string) (*tls.Config, error) {
 	return config, nil
 }
 
+// stopper is a component which is stopped on shutdown.
+type stopper struct {
+	name string
+	stop func(context.Context) error
+}
+
+// shutdown stops the given components in order. Once the context is done, the
+// remaining components are told to stop without waiting for them.
+func shutdown(ctx context.Context, stoppers []stopper) {
+	for _, s := range stoppers {
+		if err := s.stop(ctx); err != nil {
+			log.Printf("failed to stop %s: %s", s.name, err.Error())
+		} else {
+			log.Printf("%s stopped", s.name)
+		}
+	}
+}
+
 // drainLog drains errors from the channel and simply logs them
 func drainLog(msg string, errChan <-chan error) {
 	for {
@@ -343,5 +673,6 @@ func stopProfile() {
 
 func printHelp() {
 	fmt.Println("ekanited [options]")
+	fmt.Println("ekanited import [options] [file ...]")
 	fs.PrintDefaults()
 }
@@ -83,6 +83,45 @@ func Test_SingleLineClose(t *testing.T) {
 	sys.e.waitForCount(1)
 }
 
+// Test_OctetCounted ensures log lines framed using octet-counting, including
+// those with embedded newlines, are detected.
+func Test_OctetCounted(t *testing.T) {
+	path := tempPath()
+	defer os.RemoveAll(path)
+	sys := NewSystem(path)
+	ingestConn := sys.IngestConn()
+
+	lines := []string{
+		"<33>5 1985-04-12T23:20:50.52Z test.com java 304 - NullPointerException\n<12>at Main.java:42",
+		"<33>5 1985-04-12T23:20:51.52Z test.com cron 304 - password rejected",
+	}
+	var frames string
+	for _, l := range lines {
+		frames += fmt.Sprintf("%d %s", len(l), l)
+	}
+	n, err := ingestConn.Write([]byte(frames))
+	if err != nil {
+		t.Fatalf("failed to write '%s' to Collector: %s", frames, err.Error())
+	}
+	if n != len(frames) {
+		t.Fatalf("insufficient bytes written to Collector, exp: %d, wrote: %d", len(frames), n)
+	}
+	sys.e.waitForCount(uint64(len(lines)))
+
+	results, err := sys.s.Search("test.com")
+	if err != nil {
+		t.Fatalf("failed to execute search query: %s", err.Error())
+	}
+	if len(results) != len(lines) {
+		t.Fatalf("wrong number of results received for query, exp %d, got %d", len(lines), len(results))
+	}
+	for i := range results {
+		if lines[i] != results[i] {
+			t.Errorf("result %d is wrong, exp: '%s', got: '%s'", i, lines[i], results[i])
+		}
+	}
+}
+
 // Test_EndToEnd ensures a complete system operates as expected.
 func Test_EndToEnd(t *testing.T) {
 	path := tempPath()
@@ -363,7 +402,7 @@ func (s *testServer) Search(query string) (resultSlice []string, err error) {
 		return
 	} else {
 		for v := range resultSet {
-			resultSlice = append(resultSlice, v)
+			resultSlice = append(resultSlice, string(v.Source))
 		}
 	}
 	return
@@ -375,7 +414,7 @@ type testCollector struct {
 
 // NewCollector returns a new test TCP collector.
 func NewCollector(addr string) *testCollector {
-	collector, err := input.NewCollector("tcp", addr, "syslog", nil)
+	collector, err := input.NewCollector("tcp", addr, "syslog", input.ParserConfig{}, 0, nil)
 	if err != nil {
 		panic(fmt.Sprintf("failed to create test collector: %s", err.Error()))
 	}
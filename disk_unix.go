@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package ekanite
+
+import "syscall"
+
+// freeSpace returns the space, in bytes, available to unprivileged users on
+// the filesystem holding the given path.
+func freeSpace(path string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
@@ -1,6 +1,7 @@
 package ekanite
 
 import (
+	"context"
 	"expvar"
 	"fmt"
 	"log"
@@ -11,6 +12,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
 	"github.com/ekanite/ekanite/input"
 )
 
@@ -21,6 +24,14 @@ const (
 	DefaultRetentionPeriod = 24 * time.Hour
 
 	RetentionCheckInterval = time.Hour
+
+	// DiskCheckInterval is how often the size of the data directory, and the
+	// free space on its filesystem, are checked, if limited.
+	DiskCheckInterval = time.Minute
+
+	DefaultBatchMaxRetries    = 5
+	DefaultBatchRetryInterval = time.Second
+	maxBatchRetryInterval     = 30 * time.Second
 )
 
 // Engine stats
@@ -33,26 +44,49 @@ type EventIndexer interface {
 	Index(events []*Event) error
 }
 
+// DeadLetterer records events which could not be indexed.
+type DeadLetterer interface {
+	DeadLetter(e *input.Event, err error) error
+}
+
 // Batcher accepts "input events", and once it has a certain number, or a certain amount
 // of time has passed, sends those as indexable Events to an Indexer. It also supports a
 // maximum number of unprocessed Events it will keep pending. Once this limit is reached,
 // it will not accept anymore until outstanding Events are processed.
+// Events which fail to be indexed are retried, with the interval between retries doubling
+// each time, before they are dropped.
 type Batcher struct {
 	indexer  EventIndexer
 	size     int
 	duration time.Duration
 
-	c chan *input.Event
+	MaxRetries    int           // Number of times events which fail to be indexed are retried.
+	RetryInterval time.Duration // Interval before the first retry.
+	DeadLetter    DeadLetterer  // If set, events which are dropped are written to it before being acknowledged.
+
+	c    chan *input.Event
+	stop chan struct{}
+	done chan struct{}
+
+	mu            sync.Mutex
+	batchesFailed int64
+	eventsFailed  int64
+	lastErr       error
+	lastErrTime   time.Time
 }
 
 // NewBatcher returns a Batcher for EventIndexer e, a batching size of sz, a maximum duration
 // of dur, and a maximum outstanding count of max.
 func NewBatcher(e EventIndexer, sz int, dur time.Duration, max int) *Batcher {
 	return &Batcher{
-		indexer:  e,
-		size:     sz,
-		duration: dur,
-		c:        make(chan *input.Event, max),
+		indexer:       e,
+		size:          sz,
+		duration:      dur,
+		MaxRetries:    DefaultBatchMaxRetries,
+		RetryInterval: DefaultBatchRetryInterval,
+		c:             make(chan *input.Event, max),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
 	}
 }
 
@@ -64,13 +98,7 @@ func (b *Batcher) Start(errChan chan<- error) error {
 		timer.Stop() // Stop any first firing.
 
 		send := func() {
-			err := b.indexer.Index(batch)
-			if err != nil {
-				stats.Add("batchIndexedError", 1)
-				return
-			}
-			stats.Add("batchIndexed", 1)
-			stats.Add("eventsIndexed", int64(len(batch)))
+			err := b.index(batch)
 			if errChan != nil {
 				errChan <- err
 			}
@@ -94,6 +122,27 @@ func (b *Batcher) Start(errChan chan<- error) error {
 			case <-timer.C:
 				stats.Add("batchTimeout", 1)
 				send()
+			case <-b.stop:
+				timer.Stop()
+				// Index all events already sent, including the final partial batch.
+			drain:
+				for {
+					select {
+					case event := <-b.c:
+						batch = append(batch, &Event{event})
+						if len(batch) == b.size {
+							send()
+						}
+					default:
+						break drain
+					}
+				}
+				if len(batch) > 0 {
+					stats.Add("batchFlushed", 1)
+					send()
+				}
+				close(b.done)
+				return
 			}
 		}
 	}()
@@ -101,6 +150,137 @@ func (b *Batcher) Start(errChan chan<- error) error {
 	return nil
 }
 
+// Stop stops the batching process, once all events already sent to the batcher
+// are indexed. If the context is done first, its error is returned. No events
+// may be sent to the batcher once it is stopped.
+func (b *Batcher) Stop(ctx context.Context) error {
+	close(b.stop)
+	select {
+	case <-b.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// index indexes the batch, retrying any events which fail to be indexed. Events
+// are acknowledged once indexed, or once dropped after all retries failed. The
+// error for the events which could not be indexed, once all retries failed, or
+// the batcher was stopped, is returned.
+func (b *Batcher) index(batch []*Event) error {
+	interval := b.RetryInterval
+	for retry := 0; ; retry++ {
+		err := b.indexer.Index(batch)
+		failed := failedEvents(batch, err)
+		stats.Add("eventsIndexed", int64(len(batch)-len(failed)))
+		for _, e := range batch {
+			if e.Ack != nil && !containsEvent(failed, e) {
+				e.Ack()
+			}
+		}
+		if err == nil {
+			stats.Add("batchIndexed", 1)
+			return nil
+		}
+
+		stats.Add("batchIndexedError", 1)
+		b.mu.Lock()
+		b.lastErr, b.lastErrTime = err, time.Now()
+		if retry == b.MaxRetries {
+			b.batchesFailed++
+			b.eventsFailed += int64(len(failed))
+		}
+		b.mu.Unlock()
+
+		if retry == b.MaxRetries {
+			stats.Add("batchFailed", 1)
+			stats.Add("eventsFailed", int64(len(failed)))
+			dropEvents(failed, err, b.DeadLetter)
+			return fmt.Errorf("dropped %d event(s) after %d retries: %s", len(failed), retry, err.Error())
+		}
+		// Stop retrying if the batcher is stopped, leaving the events unacknowledged.
+		timer := time.NewTimer(interval)
+		select {
+		case <-timer.C:
+		case <-b.stop:
+			timer.Stop()
+			stats.Add("batchAbandoned", 1)
+			return fmt.Errorf("stopped with %d event(s) not indexed: %s", len(failed), err.Error())
+		}
+		stats.Add("batchRetried", 1)
+		if interval *= 2; interval > maxBatchRetryInterval {
+			interval = maxBatchRetryInterval
+		}
+		batch = failed
+	}
+}
+
+// dropEvents acknowledges the given events, which could not be indexed, so
+// that they are not redelivered, first writing them to the dead letter output d,
+// if any.
+func dropEvents(events []*Event, err error, d DeadLetterer) {
+	for _, e := range events {
+		if d != nil {
+			eventErr := err
+			if ie, ok := err.(*IndexError); ok && ie.Failed[e.ID()] != nil {
+				eventErr = ie.Failed[e.ID()]
+			}
+			if err := d.DeadLetter(e.Event, eventErr); err != nil {
+				stats.Add("eventsDeadLetterError", 1)
+			} else {
+				stats.Add("eventsDeadLettered", 1)
+			}
+		}
+		if e.Ack != nil {
+			e.Ack()
+		}
+	}
+}
+
+// Status returns the indexing failures of the batcher.
+func (b *Batcher) Status() (map[string]interface{}, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := map[string]interface{}{
+		"batches_failed": b.batchesFailed,
+		"events_failed":  b.eventsFailed,
+	}
+	if b.lastErr != nil {
+		status["last_error"] = b.lastErr.Error()
+		status["last_error_time"] = b.lastErrTime
+	}
+	return status, nil
+}
+
+// failedEvents returns the events of the batch which were not indexed, given the
+// error returned when indexing it.
+func failedEvents(batch []*Event, err error) []*Event {
+	if err == nil {
+		return nil
+	}
+	ie, ok := err.(*IndexError)
+	if !ok {
+		return batch
+	}
+	failed := make([]*Event, 0, len(ie.Failed))
+	for _, e := range batch {
+		if _, ok := ie.Failed[e.ID()]; ok {
+			failed = append(failed, e)
+		}
+	}
+	return failed
+}
+
+// containsEvent returns whether the given event is one of events.
+func containsEvent(events []*Event, e *Event) bool {
+	for _, ev := range events {
+		if ev == e {
+			return true
+		}
+	}
+	return false
+}
+
 // C returns the channel on the batcher to which events should be sent.
 func (b *Batcher) C() chan<- *input.Event {
 	return b.c
@@ -112,10 +292,15 @@ type Engine struct {
 	NumShards       int           // Number of shards to use when creating an index.
 	IndexDuration   time.Duration // Duration of created indexes.
 	RetentionPeriod time.Duration // How long after Index end-time to hang onto data.
+	MaxSize         int64         // Maximum size, in bytes, of the data directory. If zero, not limited.
+	MinFreeSpace    int64         // Minimum free space, in bytes, on the data directory's filesystem. If zero, not limited.
+	Queue           *Queue        // If set, events queued but not indexed are indexed on Open.
 
 	mu      sync.RWMutex
 	indexes Indexes
 
+	tails tails // Standing queries, for live tailing of indexed events.
+
 	open bool
 	done chan struct{}
 	wg   sync.WaitGroup
@@ -152,7 +337,7 @@ func (e *Engine) Open() error {
 
 	// Open all indexes.
 	for _, fi := range fis {
-		if !fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+		if !fi.IsDir() || strings.HasPrefix(fi.Name(), ".") || fi.Name() == QueueDirName {
 			continue
 		}
 		indexPath := filepath.Join(e.path, fi.Name())
@@ -165,6 +350,21 @@ func (e *Engine) Open() error {
 		sort.Sort(e.indexes)
 	}
 
+	// Index any events which were queued, but not indexed, before the engine
+	// was last closed.
+	if e.Queue != nil {
+		if err := e.Queue.Open(); err != nil {
+			return fmt.Errorf("engine failed to open queue: %s", err.Error())
+		}
+		n, err := e.Queue.Replay(e)
+		if err != nil {
+			return fmt.Errorf("engine failed to replay queue: %s", err.Error())
+		}
+		if n > 0 {
+			e.Logger.Printf("engine replayed %d event(s) from queue", n)
+		}
+	}
+
 	e.wg.Add(1)
 	go e.runRetentionEnforcement()
 
@@ -178,6 +378,12 @@ func (e *Engine) Close() error {
 		return nil
 	}
 
+	if e.Queue != nil {
+		if err := e.Queue.Close(); err != nil {
+			return err
+		}
+	}
+
 	for _, i := range e.indexes {
 		if err := i.Close(); err != nil {
 			return err
@@ -210,14 +416,24 @@ func (e *Engine) Total() (uint64, error) {
 // runRetentionEnforcement periodically runs retention enforcement.
 func (e *Engine) runRetentionEnforcement() {
 	defer e.wg.Done()
+	retentionTicker := time.NewTicker(RetentionCheckInterval)
+	defer retentionTicker.Stop()
+	diskTicker := time.NewTicker(DiskCheckInterval)
+	defer diskTicker.Stop()
 	for {
 		select {
 		case <-e.done:
 			return
 
-		case <-time.After(RetentionCheckInterval):
+		case <-retentionTicker.C:
 			stats.Add("retentionEnforcementRun", 1)
 			e.enforceRetention()
+
+		case <-diskTicker.C:
+			if e.MaxSize > 0 || e.MinFreeSpace > 0 {
+				stats.Add("diskRetentionEnforcementRun", 1)
+				e.enforceDiskRetention()
+			}
 		}
 	}
 }
@@ -230,7 +446,7 @@ func (e *Engine) enforceRetention() {
 	filtered := e.indexes[:0]
 	for _, i := range e.indexes {
 		if i.Expired(time.Now().UTC(), e.RetentionPeriod) {
-			if err := DeleteIndex(i); err != nil {
+			if _, err := e.deleteIndex(i); err != nil {
 				e.Logger.Printf("retention enforcement failed to delete index %s: %s", i.path, err.Error())
 			} else {
 				e.Logger.Printf("retention enforcement deleted index %s", i.path)
@@ -244,6 +460,71 @@ func (e *Engine) enforceRetention() {
 	return
 }
 
+// enforceDiskRetention removes indexes, oldest first, while the data directory
+// is larger than MaxSize, or there is less than MinFreeSpace on its filesystem.
+// The newest index is never removed, as events are being indexed into it.
+func (e *Engine) enforceDiskRetention() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	size, err := dirSize(e.path)
+	if err != nil {
+		e.Logger.Printf("disk retention enforcement failed to get size of %s: %s", e.path, err.Error())
+		return
+	}
+	free, err := freeSpace(e.path)
+	if err != nil {
+		e.Logger.Printf("disk retention enforcement failed to get free space of %s: %s", e.path, err.Error())
+		return
+	}
+
+	// Indexes are ordered newest first.
+	for len(e.indexes) > 1 {
+		overSize := e.MaxSize > 0 && size > e.MaxSize
+		underFree := e.MinFreeSpace > 0 && free < e.MinFreeSpace
+		if !overSize && !underFree {
+			return
+		}
+
+		i := e.indexes[len(e.indexes)-1]
+		reclaimed, err := e.deleteIndex(i)
+		if err != nil {
+			e.Logger.Printf("disk retention enforcement failed to delete index %s: %s", i.path, err.Error())
+			return
+		}
+		e.indexes = e.indexes[:len(e.indexes)-1]
+		e.Logger.Printf("disk retention enforcement deleted index %s, reclaiming %d bytes (data size %d bytes, free space %d bytes)",
+			i.path, reclaimed, size, free)
+		stats.Add("diskRetentionEnforcementDeletions", 1)
+
+		size -= reclaimed
+		if free, err = freeSpace(e.path); err != nil {
+			e.Logger.Printf("disk retention enforcement failed to get free space of %s: %s", e.path, err.Error())
+			return
+		}
+	}
+
+	if (e.MaxSize > 0 && size > e.MaxSize) || (e.MinFreeSpace > 0 && free < e.MinFreeSpace) {
+		stats.Add("diskRetentionEnforcementExhausted", 1)
+		e.Logger.Printf("disk retention enforcement cannot reclaim space, as only the newest index remains (data size %d bytes, free space %d bytes)",
+			size, free)
+	}
+}
+
+// deleteIndex deletes the index, returning the number of bytes reclaimed. It
+// must be called under lock.
+func (e *Engine) deleteIndex(i *Index) (int64, error) {
+	size, err := i.Size()
+	if err != nil {
+		e.Logger.Printf("failed to get size of index %s: %s", i.path, err.Error())
+	}
+	if err := DeleteIndex(i); err != nil {
+		return 0, err
+	}
+	stats.Add("retentionBytesReclaimed", size)
+	return size, nil
+}
+
 // indexForReferenceTime returns an index suitable for indexing an event
 // for the given reference time. Must be called under RLock.
 func (e *Engine) indexForReferenceTime(t time.Time) *Index {
@@ -294,11 +575,13 @@ func (e *Engine) createIndexForReferenceTime(rt time.Time) (*Index, error) {
 }
 
 // Index indexes a batch of Events. It blocks until all processing has completed.
+// If any events could not be indexed, an *IndexError listing them is returned.
 func (e *Engine) Index(events []*Event) error {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 
 	var wg sync.WaitGroup
+	var indexErr IndexError
 
 	// De-multiplex the batch into sub-batches, one sub-batch for each Index.
 	subBatches := make(map[*Index][]Document, 0)
@@ -306,6 +589,7 @@ func (e *Engine) Index(events []*Event) error {
 	for _, ev := range events {
 		index := e.indexForReferenceTime(ev.ReferenceTime())
 		if index == nil {
+			var err error
 			func() {
 				// Take a RWLock, check again, and create a new index if necessary.
 				// Doing this in a function makes lock management foolproof.
@@ -316,13 +600,13 @@ func (e *Engine) Index(events []*Event) error {
 
 				index = e.indexForReferenceTime(ev.ReferenceTime())
 				if index == nil {
-					var err error
 					index, err = e.createIndexForReferenceTime(ev.ReferenceTime())
-					if err != nil || index == nil {
-						panic(fmt.Sprintf("failed to create index for %s: %s", ev.ReferenceTime(), err))
-					}
 				}
 			}()
+			if err != nil {
+				indexErr.add([]Document{ev}, fmt.Errorf("failed to create index for %s: %s", ev.ReferenceTime(), err.Error()))
+				continue
+			}
 		}
 
 		if _, ok := subBatches[index]; !ok {
@@ -336,48 +620,182 @@ func (e *Engine) Index(events []*Event) error {
 		wg.Add(1)
 		go func(i *Index, b []Document) {
 			defer wg.Done()
-			i.Index(b)
+			if err := i.Index(b); err != nil {
+				indexErr.add(b, err)
+				b = indexedDocuments(b, err)
+			}
+			e.tails.publish(i, b)
 		}(index, subBatch)
 	}
 	wg.Wait()
-	return nil
+	return indexErr.err()
 }
 
-// Search performs a search.
-func (e *Engine) Search(query string) (<-chan string, error) {
+// indexedDocuments returns the documents which were indexed, given the error
+// returned when indexing them.
+func indexedDocuments(documents []Document, err error) []Document {
+	ie, ok := err.(*IndexError)
+	if !ok {
+		return nil
+	}
+	indexed := make([]Document, 0, len(documents))
+	for _, d := range documents {
+		if _, ok := ie.Failed[d.ID()]; !ok {
+			indexed = append(indexed, d)
+		}
+	}
+	return indexed
+}
+
+// Tail registers a standing query, using the Ekanite query language. Events
+// matching the query are sent on the channel of the returned Tail as they are
+// indexed. The Tail must be closed when no longer required. An error is returned
+// if the query cannot be parsed.
+func (e *Engine) Tail(q string) (*Tail, error) {
+	return e.tails.add(q)
+}
+
+// Search performs a search, using the Ekanite query language, across all indexed
+// data. An error is returned if the query cannot be parsed, and any failure of the
+// search itself is sent as for SearchRange.
+func (e *Engine) Search(q string) (<-chan *Result, error) {
+	return e.SearchRange(q, TimeRange{})
+}
+
+// SearchRange performs a search, using the Ekanite query language, of the events
+// with a reference time within the given time range. Only indexes which overlap
+// the time range are searched. An error is returned if the query cannot be parsed.
+// If the search fails once results are being sent, a Result holding only the
+// error is sent last.
+func (e *Engine) SearchRange(q string, r TimeRange) (<-chan *Result, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 	stats.Add("queriesRx", 1)
 
+	parsed, err := parseQuery(q)
+	if err != nil {
+		stats.Add("queriesInvalid", 1)
+		return nil, err
+	}
+	indexes := e.indexesForSearch(r)
+
 	// Buffer channel to control how many docs are sent back.
-	c := make(chan string, 1)
+	c := make(chan *Result, 1)
 
 	go func() {
-		// Sequentially search each index, starting with the earliest in time.
-		// This could be done in parallel but more sorting would be required.
-		for i := len(e.indexes) - 1; i >= 0; i-- {
-			e.Logger.Printf("searching index %s", e.indexes[i].Path())
-			ids, err := e.indexes[i].Search(query)
-			if err != nil {
-				e.Logger.Println("error performing search:", err.Error())
-				break
-			}
-			for _, id := range ids {
-				b, err := e.indexes[i].Document(id)
+		defer close(c)
+
+		// Sequentially search each index, a page at a time. This could be done in
+		// parallel but more sorting would be required.
+		for _, idx := range indexes {
+			e.Logger.Printf("searching index %s", idx.Path())
+			idxQuery := indexQuery(idx, parsed, r)
+
+			var after DocID
+			for {
+				ids, _, err := idx.Search(idxQuery, after, searchPageSize)
 				if err != nil {
-					e.Logger.Println("error getting document:", err.Error())
+					e.Logger.Println("error performing search:", err.Error())
+					c <- &Result{Err: err}
+					return
+				}
+				for _, id := range ids {
+					res, err := newResult(idx, id)
+					if err != nil {
+						e.Logger.Println("error getting document:", err.Error())
+						c <- &Result{Err: err}
+						return
+					}
+					stats.Add("docsIDsRetrived", 1)
+					c <- res
+				}
+				if len(ids) < searchPageSize {
 					break
 				}
-				stats.Add("docsIDsRetrived", 1)
-				c <- string(b) // There is excessive byte-slice-to-strings here.
+				after = ids[len(ids)-1]
 			}
 		}
-		close(c)
 	}()
 
 	return c, nil
 }
 
+// SearchPage performs a search, using the Ekanite query language, of the events
+// with a reference time within the given time range, returning at most limit
+// results. If after is set, only events following the event with that ID are
+// returned. To retrieve the next page of results, pass the Cursor of a Page as
+// after. An error is returned if the query cannot be parsed.
+func (e *Engine) SearchPage(q string, r TimeRange, after DocID, limit int) (*Page, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	stats.Add("queriesRx", 1)
+
+	if limit < 1 {
+		return nil, fmt.Errorf("search limit must be at least 1")
+	}
+	parsed, err := parseQuery(q)
+	if err != nil {
+		stats.Add("queriesInvalid", 1)
+		return nil, err
+	}
+
+	page := &Page{Cursor: after}
+	for _, idx := range e.indexesForSearch(r) {
+		// Only count the matches in indexes which cannot contribute results to
+		// this page. Otherwise request one result more than required, to detect
+		// truncation.
+		size := limit - len(page.Results) + 1
+		if page.Truncated || (after != "" && !idx.EndTime().After(after.Time())) {
+			size = 0
+		}
+
+		ids, total, err := idx.Search(indexQuery(idx, parsed, r), after, size)
+		if err != nil {
+			return nil, err
+		}
+		page.Total += total
+
+		for _, id := range ids {
+			if len(page.Results) == limit {
+				page.Truncated = true
+				break
+			}
+			res, err := newResult(idx, id)
+			if err != nil {
+				return nil, err
+			}
+			stats.Add("docsIDsRetrived", 1)
+			page.Results = append(page.Results, res)
+			page.Cursor = id
+		}
+	}
+	return page, nil
+}
+
+// indexesForSearch returns the indexes which may contain events within the given
+// time range, in order, starting with the earliest in time. Must be called under RLock.
+func (e *Engine) indexesForSearch(r TimeRange) []*Index {
+	var indexes []*Index
+	for i := len(e.indexes) - 1; i >= 0; i-- {
+		if !e.indexes[i].Overlaps(r.Start, r.End) {
+			stats.Add("indexesSkipped", 1)
+			continue
+		}
+		indexes = append(indexes, e.indexes[i])
+	}
+	return indexes
+}
+
+// indexQuery returns the query to execute against the given index, so that only
+// events within the given time range match. The events are only filtered by
+// reference time if the index contains events outside the time range.
+func indexQuery(idx *Index, q query.Query, r TimeRange) query.Query {
+	if r.Covers(idx.StartTime(), idx.EndTime()) {
+		return q
+	}
+	return bleve.NewConjunctionQuery(q, r.query())
+}
+
 // Path returns the path to the directory of indexed data.
 func (e *Engine) Path() string {
 	return e.path
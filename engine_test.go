@@ -2,9 +2,15 @@ package ekanite
 
 import (
 	"bufio"
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -166,6 +172,167 @@ func TestBatcher_AllModes(t *testing.T) {
 	}
 }
 
+// TestBatcher_Ack tests that events are acknowledged once indexed.
+func TestBatcher_Ack(t *testing.T) {
+	acked := 0
+	e := newInputEvent("", time.Now())
+	e.Ack = func() { acked++ }
+	i := &TestIndexer{}
+	b := NewBatcher(i, 2, time.Hour, 0)
+
+	c := make(chan error)
+	err := b.Start(c)
+	if err != nil {
+		t.Fatalf("failed start batcher: %s", err.Error())
+	}
+
+	b.C() <- e
+	b.C() <- newInputEvent("", time.Now())
+	err = <-c
+	if err != nil {
+		t.Fatalf("failed to send two events: %s", err.Error())
+	}
+
+	if acked != 1 {
+		t.Fatalf("event acknowledged wrong number of times, exp 1, got %d", acked)
+	}
+}
+
+// TestBatcher_Stop tests that a partial batch is indexed when the batcher is stopped.
+func TestBatcher_Stop(t *testing.T) {
+	i := &TestIndexer{}
+	b := NewBatcher(i, 10, time.Hour, 10)
+
+	if err := b.Start(nil); err != nil {
+		t.Fatalf("failed start batcher: %s", err.Error())
+	}
+
+	b.C() <- newInputEvent("", time.Now())
+	b.C() <- newInputEvent("", time.Now())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := b.Stop(ctx); err != nil {
+		t.Fatalf("failed to stop batcher: %s", err.Error())
+	}
+
+	if i.BatchesRx != 1 || i.EventsRx != 2 {
+		t.Fatalf("indexer failed to receive correct number of events: batches: %d, events: %d", i.BatchesRx, i.EventsRx)
+	}
+}
+
+// TestBatcher_StopDuringRetry tests that the batcher stops without waiting to
+// retry events which failed to be indexed.
+func TestBatcher_StopDuringRetry(t *testing.T) {
+	acked := 0
+	i := &FailingIndexer{Failures: 100}
+	b := NewBatcher(i, 1, time.Hour, 10)
+	b.RetryInterval = time.Hour
+
+	if err := b.Start(nil); err != nil {
+		t.Fatalf("failed start batcher: %s", err.Error())
+	}
+
+	e := newInputEvent("", time.Now())
+	e.Ack = func() { acked++ }
+	b.C() <- e
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := b.Stop(ctx); err != nil {
+		t.Fatalf("failed to stop batcher: %s", err.Error())
+	}
+	if acked != 0 {
+		t.Fatalf("event acknowledged despite failing to be indexed")
+	}
+}
+
+// FailingIndexer fails to index events until a number of attempts have been made.
+type FailingIndexer struct {
+	TestIndexer
+	Failures int
+}
+
+func (f *FailingIndexer) Index(b []*Event) error {
+	if f.Failures > 0 {
+		f.Failures--
+		return errors.New("disk full")
+	}
+	return f.TestIndexer.Index(b)
+}
+
+// TestBatcher_Retry tests that events which fail to be indexed are retried, and
+// dropped once all retries fail.
+func TestBatcher_Retry(t *testing.T) {
+	acked := 0
+	i := &FailingIndexer{Failures: 2}
+	b := NewBatcher(i, 1, time.Hour, 0)
+	b.RetryInterval = time.Millisecond
+
+	c := make(chan error)
+	if err := b.Start(c); err != nil {
+		t.Fatalf("failed start batcher: %s", err.Error())
+	}
+
+	e := newInputEvent("", time.Now())
+	e.Ack = func() { acked++ }
+	b.C() <- e
+	if err := <-c; err != nil {
+		t.Fatalf("failed to index event after retries: %s", err.Error())
+	}
+	if i.EventsRx != 1 || acked != 1 {
+		t.Fatalf("wrong events indexed after retries, indexed %d, acknowledged %d", i.EventsRx, acked)
+	}
+
+	i.Failures = b.MaxRetries + 1
+	b.C() <- e
+	if err := <-c; err == nil {
+		t.Fatalf("no error once all retries failed")
+	}
+	if acked != 2 {
+		t.Fatalf("event not acknowledged once dropped")
+	}
+	st, _ := b.Status()
+	if st["batches_failed"] != int64(1) || st["events_failed"] != int64(1) || st["last_error"] != "disk full" {
+		t.Fatalf("wrong status after failure, got %v", st)
+	}
+}
+
+// testDeadLetterer records the events written to it.
+type testDeadLetterer struct {
+	events []*input.Event
+}
+
+func (d *testDeadLetterer) DeadLetter(e *input.Event, err error) error {
+	d.events = append(d.events, e)
+	return nil
+}
+
+// TestBatcher_DeadLetter tests that events dropped once all retries fail are
+// written to the dead letter output, and then acknowledged.
+func TestBatcher_DeadLetter(t *testing.T) {
+	acked := 0
+	i := &FailingIndexer{Failures: 2}
+	d := &testDeadLetterer{}
+	b := NewBatcher(i, 1, time.Hour, 0)
+	b.MaxRetries = 1
+	b.RetryInterval = time.Millisecond
+	b.DeadLetter = d
+
+	c := make(chan error)
+	if err := b.Start(c); err != nil {
+		t.Fatalf("failed start batcher: %s", err.Error())
+	}
+
+	e := newInputEvent("", time.Now())
+	e.Ack = func() { acked++ }
+	b.C() <- e
+	if err := <-c; err == nil {
+		t.Fatalf("no error once all retries failed")
+	}
+	if len(d.events) != 1 || d.events[0] != e || acked != 1 {
+		t.Fatalf("wrong dead letter handling, dead lettered %d, acknowledged %d", len(d.events), acked)
+	}
+}
+
 func TestEngine_New(t *testing.T) {
 	dataDir := tempPath()
 	defer os.RemoveAll(dataDir)
@@ -294,11 +461,11 @@ func TestEngine_IndexThenSearch(t *testing.T) {
 		t.Fatalf("failed to search for indexed event: %s", err.Error())
 	}
 
-	if s, _ := <-c; s != line1 {
-		t.Fatalf(`returned source incorrect. got: "%s", exp "%s"`, s, line1)
+	if r, _ := <-c; string(r.Source) != line1 {
+		t.Fatalf(`returned source incorrect. got: "%s", exp "%s"`, r.Source, line1)
 	}
-	if s, _ := <-c; s != line3 {
-		t.Fatalf(`returned source incorrect. got: "%s", exp "%s"`, s, line3)
+	if r, _ := <-c; string(r.Source) != line3 {
+		t.Fatalf(`returned source incorrect. got: "%s", exp "%s"`, r.Source, line3)
 	}
 
 	if _, more := <-c; more {
@@ -306,6 +473,454 @@ func TestEngine_IndexThenSearch(t *testing.T) {
 	}
 }
 
+// TestEngine_SearchFailure tests that a search which fails once results are
+// being sent ends with the error.
+func TestEngine_SearchFailure(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+	e := NewEngine(dataDir)
+
+	line1 := "auth password accepted for user philip"
+	line2 := "auth password rejected for user philip"
+	if err := e.Index([]*Event{
+		newIndexableEvent(line1, parseTime("1982-02-05T04:43:00Z")),
+		newIndexableEvent(line2, parseTime("1982-02-06T04:43:00Z")),
+	}); err != nil {
+		t.Fatalf("failed to index events: %s", err.Error())
+	}
+
+	// The later index is searched last, and can no longer be searched.
+	if err := e.indexes[0].Close(); err != nil {
+		t.Fatalf("failed to close index: %s", err.Error())
+	}
+	c, err := e.Search("philip")
+	if err != nil {
+		t.Fatalf("failed to search: %s", err.Error())
+	}
+	if r := <-c; r.Err != nil || string(r.Source) != line1 {
+		t.Fatalf("wrong first result, got %v", r)
+	}
+	if r := <-c; r == nil || r.Err == nil {
+		t.Fatalf("no error for failed search, got %v", r)
+	}
+	if _, ok := <-c; ok {
+		t.Fatalf("results sent after error")
+	}
+}
+
+func TestEngine_IndexThenSearchQueryLanguage(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+	e := NewEngine(dataDir)
+
+	line1 := "auth password accepted for user philip"
+	ev1 := newIndexableEvent(line1, parseTime("1982-02-05T04:43:00Z"))
+	line2 := "auth password accepted for user root"
+	ev2 := newIndexableEvent(line2, parseTime("1982-02-05T04:43:01Z"))
+	line3 := "auth password rejected for user philip"
+	ev3 := newIndexableEvent(line3, parseTime("1982-02-05T04:43:02Z"))
+
+	if err := e.Index([]*Event{ev1, ev2, ev3}); err != nil {
+		t.Fatalf("failed to index events: %s", err.Error())
+	}
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{query: "message:philip", expected: []string{line1, line3}},
+		{query: "philip AND rejected", expected: []string{line3}},
+		{query: "philip rejected", expected: []string{line3}},
+		{query: "root OR rejected", expected: []string{line2, line3}},
+		{query: "password NOT philip", expected: []string{line2}},
+		{query: "(root OR philip) AND ACCEPTED", expected: []string{line1, line2}},
+		{query: "missing", expected: nil},
+	}
+
+	for _, tt := range tests {
+		c, err := e.Search(tt.query)
+		if err != nil {
+			t.Fatalf("failed to search for '%s': %s", tt.query, err.Error())
+		}
+		got := sources(c)
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Fatalf("wrong results for query '%s', got %v, exp %v", tt.query, got, tt.expected)
+		}
+	}
+
+	if _, err := e.Search("pid:philip"); err == nil {
+		t.Fatalf("search for non-numeric pid did not return an error")
+	}
+	if _, err := e.Search("philip AND"); err == nil {
+		t.Fatalf("search for invalid query did not return an error")
+	}
+}
+
+func TestEngine_IndexThenSearchParsedFields(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+	e := NewEngine(dataDir)
+
+	line1 := "<134>1 1982-02-05T04:43:00Z web01 nginx 1999 - GET /index.html"
+	ev1 := newParsedEvent(line1, "web01", "nginx", 1999, "10.0.0.1:1234")
+	line2 := "<134>1 1982-02-05T04:43:01Z web02 nginx 2000 - GET /index.html"
+	ev2 := newParsedEvent(line2, "web02", "nginx", 2000, "10.0.0.2:1234")
+	line3 := "<134>1 1982-02-05T04:43:02Z Web01 sshd 22 - password accepted"
+	ev3 := newParsedEvent(line3, "Web01", "sshd", 22, "10.0.0.1:1234")
+	line4 := "sshd password rejected"
+	ev4 := &Event{
+		&input.Event{
+			Text:          line4,
+			Parsed:        map[string]interface{}{input.ParseErrorField: "RFC5424"},
+			ReceptionTime: parseTime("1982-02-05T04:43:03Z"),
+		},
+	}
+
+	if err := e.Index([]*Event{ev1, ev2, ev3, ev4}); err != nil {
+		t.Fatalf("failed to index events: %s", err.Error())
+	}
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{query: "host:web01", expected: []string{line1, line3}},
+		{query: "host:web01 AND app:nginx", expected: []string{line1}},
+		{query: "app:nginx NOT host:web01", expected: []string{line2}},
+		{query: "pid:2000", expected: []string{line2}},
+		{query: "priority:134", expected: []string{line1, line2, line3}},
+		{query: "source_ip:10.0.0.1", expected: []string{line1, line3}},
+		{query: "host:web", expected: nil},
+		{query: "parse_error:rfc5424", expected: []string{line4}},
+		{query: "password NOT parse_error:rfc5424", expected: []string{line3}},
+	}
+
+	for _, tt := range tests {
+		c, err := e.Search(tt.query)
+		if err != nil {
+			t.Fatalf("failed to search for '%s': %s", tt.query, err.Error())
+		}
+		got := sources(c)
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Fatalf("wrong results for query '%s', got %v, exp %v", tt.query, got, tt.expected)
+		}
+	}
+
+	if _, err := e.Search("pid:abc"); err == nil {
+		t.Fatalf("search for non-numeric pid did not return an error")
+	}
+}
+
+func TestEngine_IndexThenSearchStructuredData(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+	e := NewEngine(dataDir)
+
+	p, err := input.NewParser("syslog", input.ParserConfig{})
+	if err != nil {
+		t.Fatalf("failed to create parser: %s", err.Error())
+	}
+	line1 := `<165>1 1982-02-05T04:43:00Z web01 evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="Application"] An application event`
+	line2 := `<165>1 1982-02-05T04:43:01Z web01 evntslog - ID47 [exampleSDID@32473 iut="4" eventSource="Security Log"] A security event`
+	var events []*Event
+	for _, l := range []string{line1, line2} {
+		if !p.Parse([]byte(l)) {
+			t.Fatalf("failed to parse '%s'", l)
+		}
+		events = append(events, &Event{
+			&input.Event{
+				Text:          l,
+				Parsed:        p.Result,
+				ReceptionTime: parseTime("1982-02-05T04:43:00Z"),
+			},
+		})
+	}
+	if err := e.Index(events); err != nil {
+		t.Fatalf("failed to index events: %s", err.Error())
+	}
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{query: "sd.exampleSDID@32473.iut:3", expected: []string{line1}},
+		{query: "sd.exampleSDID@32473.eventSource:security", expected: []string{line2}},
+		{query: "sd.exampleSDID@32473.eventSource:application OR sd.exampleSDID@32473.iut:4", expected: []string{line1, line2}},
+		{query: "sd.exampleSDID@32473.other:3", expected: nil},
+		{query: "message_id:id47", expected: []string{line1, line2}},
+	}
+
+	for _, tt := range tests {
+		c, err := e.Search(tt.query)
+		if err != nil {
+			t.Fatalf("failed to search for '%s': %s", tt.query, err.Error())
+		}
+		got := sources(c)
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Fatalf("wrong results for query '%s', got %v, exp %v", tt.query, got, tt.expected)
+		}
+	}
+}
+
+func TestEngine_IndexThenSearchJSON(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+	e := NewEngine(dataDir)
+	if err := e.Open(); err != nil {
+		t.Fatalf("failed to open engine: %s", err.Error())
+	}
+	defer e.Close()
+
+	p, err := input.NewParser("json", input.ParserConfig{})
+	if err != nil {
+		t.Fatalf("failed to create parser: %s", err.Error())
+	}
+	line1 := `{"timestamp":"1982-02-05T04:43:00Z","level":"error","user_id":1234,"msg":"payment failed"}`
+	line2 := `{"timestamp":"1982-02-05T04:43:01Z","level":"info","user_id":1234,"msg":"payment retried"}`
+	line3 := `{"timestamp":"1982-02-05T04:43:02Z","level":"error","user_id":5678,"http":{"status":500,"retry":false}}`
+	var events []*Event
+	for _, l := range []string{line1, line2, line3} {
+		if !p.Parse([]byte(l)) {
+			t.Fatalf("failed to parse '%s'", l)
+		}
+		events = append(events, &Event{
+			&input.Event{
+				Text:          l,
+				Parsed:        p.Result,
+				ReceptionTime: parseTime("1982-02-05T05:00:00Z"),
+			},
+		})
+	}
+	if err := e.Index(events); err != nil {
+		t.Fatalf("failed to index events: %s", err.Error())
+	}
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{query: "level:error AND user_id:1234", expected: []string{line1}},
+		{query: "level:ERROR", expected: []string{line1, line3}},
+		{query: "user_id:1234 NOT level:error", expected: []string{line2}},
+		{query: "msg:payment", expected: []string{line1, line2}},
+		{query: "http.status:500", expected: []string{line3}},
+		{query: "http.retry:false", expected: []string{line3}},
+		{query: "user_id:1", expected: nil},
+		{query: "user:root", expected: nil},
+	}
+
+	for _, tt := range tests {
+		c, err := e.Search(tt.query)
+		if err != nil {
+			t.Fatalf("failed to search for '%s': %s", tt.query, err.Error())
+		}
+		got := sources(c)
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Fatalf("wrong results for query '%s', got %v, exp %v", tt.query, got, tt.expected)
+		}
+	}
+}
+
+func TestEngine_IndexThenSearchRange(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+	e := NewEngine(dataDir)
+	e.IndexDuration = time.Hour
+
+	line1 := "auth password accepted for user philip"
+	ev1 := newIndexableEvent(line1, parseTime("1982-02-05T04:43:00Z"))
+	line2 := "auth password accepted for user root"
+	ev2 := newIndexableEvent(line2, parseTime("1982-02-05T05:43:00Z"))
+	line3 := "auth password rejected for user philip"
+	ev3 := newIndexableEvent(line3, parseTime("1982-02-05T06:43:00Z"))
+
+	if err := e.Index([]*Event{ev1, ev2, ev3}); err != nil {
+		t.Fatalf("failed to index events: %s", err.Error())
+	}
+	if len(e.indexes) != 3 {
+		t.Fatalf("wrong number of indexes created, exp 3, got %d", len(e.indexes))
+	}
+
+	tests := []struct {
+		r        TimeRange
+		expected []string
+	}{
+		{r: TimeRange{}, expected: []string{line1, line2, line3}},
+		{r: TimeRange{Start: parseTime("1982-02-05T05:00:00Z")}, expected: []string{line2, line3}},
+		{r: TimeRange{Start: parseTime("1982-02-05T05:43:00Z")}, expected: []string{line2, line3}},
+		{r: TimeRange{Start: parseTime("1982-02-05T05:43:01Z")}, expected: []string{line3}},
+		{r: TimeRange{End: parseTime("1982-02-05T05:43:00Z")}, expected: []string{line1}},
+		{
+			r:        TimeRange{Start: parseTime("1982-02-05T04:50:00Z"), End: parseTime("1982-02-05T06:00:00Z")},
+			expected: []string{line2},
+		},
+		{r: TimeRange{Start: parseTime("1982-02-05T07:00:00Z")}, expected: nil},
+	}
+
+	for n, tt := range tests {
+		c, err := e.SearchRange("password", tt.r)
+		if err != nil {
+			t.Fatalf("test %d: failed to search: %s", n, err.Error())
+		}
+		got := sources(c)
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Fatalf("test %d: wrong results, got %v, exp %v", n, got, tt.expected)
+		}
+	}
+}
+
+func TestEngine_IndexThenSearchPage(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+	e := NewEngine(dataDir)
+	e.IndexDuration = time.Hour
+
+	// Create events spanning multiple indexes.
+	rt := parseTime("1982-02-05T04:43:00Z")
+	var events []*Event
+	var lines []string
+	for n := 0; n < 25; n++ {
+		line := fmt.Sprintf("auth password accepted %d", n)
+		events = append(events, newIndexableEvent(line, rt))
+		lines = append(lines, line)
+		rt = rt.Add(10 * time.Minute)
+	}
+	if err := e.Index(events); err != nil {
+		t.Fatalf("failed to index events: %s", err.Error())
+	}
+
+	for _, limit := range []int{1, 4, 5, 24, 25, 100} {
+		var got []string
+		var after DocID
+		for {
+			page, err := e.SearchPage("password", TimeRange{}, after, limit)
+			if err != nil {
+				t.Fatalf("failed to search page with limit %d: %s", limit, err.Error())
+			}
+			if len(page.Results) > limit {
+				t.Fatalf("page with limit %d has %d results", limit, len(page.Results))
+			}
+			if page.Total != uint64(len(lines)) {
+				t.Fatalf("page with limit %d has wrong total, got %d, exp %d", limit, page.Total, len(lines))
+			}
+			got = append(got, pageSources(page)...)
+			if !page.Truncated {
+				break
+			}
+			if len(page.Results) != limit {
+				t.Fatalf("truncated page with limit %d has only %d results", limit, len(page.Results))
+			}
+			after = page.Cursor
+		}
+		if !reflect.DeepEqual(got, lines) {
+			t.Fatalf("wrong results paging with limit %d, got %v, exp %v", limit, got, lines)
+		}
+	}
+
+	// Paging should respect the time range.
+	r := TimeRange{Start: parseTime("1982-02-05T06:00:00Z"), End: parseTime("1982-02-05T07:00:00Z")}
+	page, err := e.SearchPage("password", r, "", 4)
+	if err != nil {
+		t.Fatalf("failed to search page: %s", err.Error())
+	}
+	if page.Total != 6 {
+		t.Fatalf("wrong total for time range, got %d, exp 6", page.Total)
+	}
+	if !reflect.DeepEqual(pageSources(page), lines[8:12]) || !page.Truncated {
+		t.Fatalf("wrong first page for time range, got %v, truncated: %v", pageSources(page), page.Truncated)
+	}
+	page, err = e.SearchPage("password", r, page.Cursor, 4)
+	if err != nil {
+		t.Fatalf("failed to search page: %s", err.Error())
+	}
+	if !reflect.DeepEqual(pageSources(page), lines[12:14]) || page.Truncated {
+		t.Fatalf("wrong second page for time range, got %v, truncated: %v", pageSources(page), page.Truncated)
+	}
+
+	if _, err := e.SearchPage("password", TimeRange{}, "", 0); err == nil {
+		t.Fatalf("search with zero limit did not return an error")
+	}
+}
+
+func TestEngine_SearchBeyondPageSize(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+	e := NewEngine(dataDir)
+
+	rt := parseTime("1982-02-05T04:43:00Z")
+	n := 2*searchPageSize + 1
+	events := make([]*Event, 0, n)
+	for i := 0; i < n; i++ {
+		events = append(events, newIndexableEvent("auth password accepted", rt))
+		rt = rt.Add(time.Millisecond)
+	}
+	if err := e.Index(events); err != nil {
+		t.Fatalf("failed to index events: %s", err.Error())
+	}
+
+	c, err := e.Search("password")
+	if err != nil {
+		t.Fatalf("failed to search: %s", err.Error())
+	}
+	got := 0
+	for range c {
+		got++
+	}
+	if got != n {
+		t.Fatalf("wrong number of results, got %d, exp %d", got, n)
+	}
+}
+
+func TestEngine_SearchResult(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+	e := NewEngine(dataDir)
+	e.IndexDuration = time.Hour
+
+	line := "<134>1 1982-02-05T04:43:00Z web01 nginx 1999 - GET /index.html"
+	ev := newParsedEvent(line, "web01", "nginx", 1999, "10.0.0.1:1234")
+	ev.ReceptionTime = parseTime("1982-02-05T04:43:05Z")
+	ev.Sequence = 1234
+	if err := e.Index([]*Event{ev}); err != nil {
+		t.Fatalf("failed to index events: %s", err.Error())
+	}
+
+	c, err := e.Search("host:web01")
+	if err != nil {
+		t.Fatalf("failed to search: %s", err.Error())
+	}
+	r, ok := <-c
+	if !ok {
+		t.Fatalf("no search result returned")
+	}
+
+	if r.ID != ev.ID() {
+		t.Errorf("wrong result ID, got %s, exp %s", r.ID, ev.ID())
+	}
+	if r.Index != "19820205_0400" {
+		t.Errorf("wrong result index, got %s", r.Index)
+	}
+	if string(r.Source) != line || r.Event.Text != line {
+		t.Errorf("wrong result source, got %s", r.Source)
+	}
+	if !r.ReferenceTime.Equal(parseTime("1982-02-05T04:43:00Z")) || !r.Event.ReferenceTime().Equal(r.ReferenceTime) {
+		t.Errorf("wrong result reference time, got %s", r.ReferenceTime)
+	}
+	if !r.Event.ReceptionTime.Equal(ev.ReceptionTime) {
+		t.Errorf("wrong result reception time, got %s", r.Event.ReceptionTime)
+	}
+	if r.Event.SourceIP != "10.0.0.1:1234" || r.Event.Sequence != 1234 {
+		t.Errorf("wrong result source IP or sequence, got %s, %d", r.Event.SourceIP, r.Event.Sequence)
+	}
+	if r.Event.Parsed["host"] != "web01" || r.Event.Parsed["pid"] != float64(1999) {
+		t.Errorf("wrong result parsed fields, got %v", r.Event.Parsed)
+	}
+}
+
 func TestEngine_createIndexForReferenceTime(t *testing.T) {
 	dataDir := tempPath()
 	defer os.RemoveAll(dataDir)
@@ -357,6 +972,60 @@ func TestEngine_RetentionEnforcement(t *testing.T) {
 	}
 }
 
+func TestEngine_DiskRetentionEnforcement(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+
+	e := NewEngine(dataDir)
+	if err := e.Open(); err != nil {
+		t.Fatalf("failed to open index at %s for indexing test: %s", dataDir, err.Error())
+	}
+	defer e.Close()
+
+	now := time.Now().UTC()
+	newest, _ := e.createIndex(now.Add(-1*time.Hour), now)
+	older, _ := e.createIndex(now.Add(-3*time.Hour), now.Add(-2*time.Hour))
+	_, _ = e.createIndex(now.Add(-5*time.Hour), now.Add(-4*time.Hour))
+
+	// Indexes should not be deleted while within the limits.
+	e.MaxSize = 1 << 40
+	e.enforceDiskRetention()
+	if len(e.indexes) != 3 {
+		t.Fatalf("engine has wrong number of indexes within size limit, exp 3, got %d", len(e.indexes))
+	}
+
+	// Only the oldest index should be deleted to bring the size within the limit.
+	size, err := dirSize(dataDir)
+	if err != nil {
+		t.Fatalf("failed to get size of data directory: %s", err.Error())
+	}
+	e.MaxSize = size - 1
+	reclaimed := bytesReclaimed()
+	e.enforceDiskRetention()
+	if len(e.indexes) != 2 || e.indexes[0] != newest || e.indexes[1] != older {
+		t.Fatalf("disk retention enforcement deleted wrong indexes, got %v", e.indexes)
+	}
+	if bytesReclaimed() <= reclaimed {
+		t.Fatalf("bytes reclaimed not counted")
+	}
+
+	// The newest index should never be deleted, however little space is free.
+	e.MaxSize = 0
+	e.MinFreeSpace = 1 << 62
+	e.enforceDiskRetention()
+	if len(e.indexes) != 1 || e.indexes[0] != newest {
+		t.Fatalf("disk retention enforcement deleted wrong indexes, got %v", e.indexes)
+	}
+}
+
+// bytesReclaimed returns the number of bytes reclaimed by retention enforcement.
+func bytesReclaimed() int64 {
+	if v, ok := stats.Get("retentionBytesReclaimed").(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
 func testEngineIndexForReferenceTime(t *testing.T, e *Engine) {
 	start1 := parseTime("1982-02-05T04:00:00Z")
 	start2 := parseTime("1982-02-05T05:00:00Z")
@@ -459,6 +1128,24 @@ func testEngineIndexPrime(t *testing.T, e *Engine) {
 	}
 }
 
+// sources returns the source of every result received on the channel.
+func sources(c <-chan *Result) []string {
+	var s []string
+	for r := range c {
+		s = append(s, string(r.Source))
+	}
+	return s
+}
+
+// pageSources returns the source of every result in the page.
+func pageSources(p *Page) []string {
+	var s []string
+	for _, r := range p.Results {
+		s = append(s, string(r.Source))
+	}
+	return s
+}
+
 func newEngine(path string, numShards int, indexDuration time.Duration) *Engine {
 	e := NewEngine(path)
 	e.Open()
@@ -532,3 +1219,23 @@ func newIndexableEvent(line string, refTime time.Time) *Event {
 		},
 	}
 }
+
+func newParsedEvent(line, host, app string, pid int, sourceIP string) *Event {
+	timestamp := strings.Fields(line)[1]
+	return &Event{
+		&input.Event{
+			Text: line,
+			Parsed: map[string]interface{}{
+				"priority":   134,
+				"version":    1,
+				"timestamp":  timestamp,
+				"host":       host,
+				"app":        app,
+				"pid":        pid,
+				"message_id": "-",
+			},
+			ReceptionTime: parseTime(timestamp),
+			SourceIP:      sourceIP,
+		},
+	}
+}
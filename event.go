@@ -1,7 +1,10 @@
 package ekanite
 
 import (
+	"encoding/json"
 	"fmt"
+	"net"
+	"time"
 
 	"github.com/ekanite/ekanite/input"
 )
@@ -22,16 +25,73 @@ func (e Event) ID() DocID {
 		uint64(e.ReferenceTime().UnixNano()), uint64(e.Sequence)))
 }
 
-// Data returns the indexable data.
+// Data returns the indexable data. This is the log line itself, any fields
+// parsed from it, the address of the sender, and the event times.
 func (e Event) Data() interface{} {
-	return struct {
-		Message string
-	}{
-		Message: e.Text,
+	data := make(map[string]interface{}, len(e.Parsed)+4)
+	for k, v := range e.Parsed {
+		// The message and timestamp are indexed via the log line and reference time.
+		if k == "message" || k == "timestamp" {
+			continue
+		}
+		data[k] = v
 	}
+	data["Message"] = e.Text
+	data["ReferenceTime"] = e.ReferenceTime()
+	data["ReceptionTime"] = e.ReceptionTime
+	if e.SourceIP != "" {
+		data["source_ip"] = sourceHost(e.SourceIP)
+	}
+	return data
 }
 
 // Source returns the original received data.
 func (e Event) Source() []byte {
 	return []byte(e.Text)
 }
+
+// eventMetadata is the metadata of an Event, as stored alongside its source.
+type eventMetadata struct {
+	Parsed        map[string]interface{} `json:"parsed,omitempty"`
+	ReceptionTime time.Time              `json:"reception_time"`
+	Sequence      int64                  `json:"sequence"`
+	SourceIP      string                 `json:"source_ip,omitempty"`
+}
+
+// Metadata returns the metadata of the event, encoded for storage.
+func (e Event) Metadata() ([]byte, error) {
+	return json.Marshal(eventMetadata{
+		Parsed:        e.Parsed,
+		ReceptionTime: e.ReceptionTime,
+		Sequence:      e.Sequence,
+		SourceIP:      e.SourceIP,
+	})
+}
+
+// newStoredEvent returns the Event with the given source and stored metadata.
+// The metadata may be nil, as it is for events indexed before metadata was stored.
+func newStoredEvent(source, metadata []byte) (*Event, error) {
+	ev := &Event{&input.Event{Text: string(source)}}
+	if metadata == nil {
+		return ev, nil
+	}
+
+	var m eventMetadata
+	if err := json.Unmarshal(metadata, &m); err != nil {
+		return nil, fmt.Errorf("failed to decode event metadata: %s", err.Error())
+	}
+	ev.Parsed = m.Parsed
+	ev.ReceptionTime = m.ReceptionTime
+	ev.Sequence = m.Sequence
+	ev.SourceIP = m.SourceIP
+	return ev, nil
+}
+
+// sourceHost returns the host part of the given address, which may or may
+// not include a port.
+func sourceHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
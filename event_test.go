@@ -1,6 +1,7 @@
 package ekanite
 
 import (
+	"reflect"
 	"testing"
 	"time"
 
@@ -35,3 +36,42 @@ func TestEvent_NewUnparsed(t *testing.T) {
 		t.Errorf("wrong Event reference time, exp: %s, got %s", now, ev.ReferenceTime())
 	}
 }
+
+// TestEvent_Data tests that parsed fields are returned as indexable data.
+func TestEvent_Data(t *testing.T) {
+	text := "<134>1 2003-08-24T05:14:15.000003-07:00 ubuntu sshd 1999 - password accepted"
+	now := time.Now()
+	ev := &Event{
+		&input.Event{
+			Text: text,
+			Parsed: map[string]interface{}{
+				"priority":   134,
+				"version":    1,
+				"timestamp":  "2003-08-24T05:14:15.000003-07:00",
+				"host":       "ubuntu",
+				"app":        "sshd",
+				"pid":        1999,
+				"message_id": "-",
+				"message":    "password accepted",
+			},
+			ReceptionTime: now,
+			SourceIP:      "192.1.2.3:5678",
+		},
+	}
+
+	exp := map[string]interface{}{
+		"Message":       text,
+		"ReferenceTime": parseTime("2003-08-24T05:14:15.000003-07:00"),
+		"ReceptionTime": now,
+		"priority":      134,
+		"version":       1,
+		"host":          "ubuntu",
+		"app":           "sshd",
+		"pid":           1999,
+		"message_id":    "-",
+		"source_ip":     "192.1.2.3",
+	}
+	if got := ev.Data(); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("wrong Event data, exp: %v, got %v", exp, got)
+	}
+}
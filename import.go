@@ -0,0 +1,116 @@
+package ekanite
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/ekanite/ekanite/input"
+)
+
+// DefaultImportBatchSize is the number of events indexed at once by an Importer,
+// unless otherwise configured.
+const DefaultImportBatchSize = 1000
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+)
+
+// Importer indexes log lines read from files, such as archived logs, directly
+// into an EventIndexer. Each event is indexed according to the timestamp parsed
+// from its log line.
+type Importer struct {
+	indexer   EventIndexer
+	BatchSize int       // Number of events indexed at once.
+	Since     time.Time // If non-zero, events with earlier reference times are skipped.
+}
+
+// ImportStats are the results of importing log lines.
+type ImportStats struct {
+	Lines    int // Log lines read
+	Imported int // Events indexed
+	Dropped  int // Log lines which could not be parsed, and were not indexed
+	Skipped  int // Events with reference times before Since
+}
+
+// NewImporter returns an Importer indexing events into e.
+func NewImporter(e EventIndexer) *Importer {
+	return &Importer{
+		indexer:   e,
+		BatchSize: DefaultImportBatchSize,
+	}
+}
+
+// Import reads newline-delimited log lines from r until EOF, parsing them with
+// the given parser, and indexes the resulting events. The data may be gzip or
+// bzip2 compressed, which is detected automatically. Log lines which cannot be
+// parsed are handled according to the Unparsed policy of the parser.
+func (im *Importer) Import(r io.Reader, parser *input.LogHandler) (ImportStats, error) {
+	var st ImportStats
+	reader, err := decompress(r)
+	if err != nil {
+		return st, err
+	}
+
+	batch := make([]*Event, 0, im.BatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := im.indexer.Index(batch); err != nil {
+			return err
+		}
+		st.Imported += len(batch)
+		stats.Add("eventsImported", int64(len(batch)))
+		batch = make([]*Event, 0, im.BatchSize)
+		return nil
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if line = strings.TrimRight(line, "\r\n"); line != "" {
+			st.Lines++
+			if e := input.ParseEvent(parser, line, ""); e == nil {
+				st.Dropped++
+			} else if !im.Since.IsZero() && e.ReferenceTime().Before(im.Since) {
+				st.Skipped++
+			} else {
+				batch = append(batch, &Event{e})
+				if len(batch) >= im.BatchSize {
+					if err := flush(); err != nil {
+						return st, err
+					}
+				}
+			}
+		}
+
+		if err == io.EOF {
+			return st, flush()
+		} else if err != nil {
+			return st, err
+		}
+	}
+}
+
+// decompress returns a reader of the data read from r, decompressing it if
+// it is gzip or bzip2 compressed.
+func decompress(r io.Reader) (*bufio.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(3)
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return bufio.NewReader(gz), nil
+	case bytes.HasPrefix(magic, bzip2Magic):
+		return bufio.NewReader(bzip2.NewReader(br)), nil
+	}
+	return br, nil
+}
@@ -0,0 +1,111 @@
+package ekanite
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ekanite/ekanite/input"
+)
+
+func TestImporter_Import(t *testing.T) {
+	p, err := input.NewParser("syslog", input.ParserConfig{})
+	if err != nil {
+		t.Fatalf("failed to create parser: %s", err.Error())
+	}
+
+	line1 := "<134>1 1982-02-05T04:43:00Z web01 nginx 1999 - GET /index.html"
+	line2 := "<134>1 1982-02-05T05:43:00Z web01 nginx 1999 - GET /login.html"
+	line3 := "<134>1 1982-02-06T04:43:00Z web01 nginx 1999 - GET /logout.html"
+	data := strings.Join([]string{line1, "not syslog", line2, "", line3 + "\r"}, "\n")
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(data))
+	w.Close()
+
+	for _, r := range []struct {
+		name string
+		data []byte
+	}{
+		{name: "plain", data: []byte(data)},
+		{name: "gzip", data: gz.Bytes()},
+	} {
+		indexer := &TestIndexer{}
+		im := NewImporter(indexer)
+		im.BatchSize = 2
+		st, err := im.Import(bytes.NewReader(r.data), p)
+		if err != nil {
+			t.Fatalf("failed to import %s data: %s", r.name, err.Error())
+		}
+		if exp := (ImportStats{Lines: 4, Imported: 3, Dropped: 1}); st != exp {
+			t.Fatalf("wrong stats for %s data, exp %+v, got %+v", r.name, exp, st)
+		}
+		if indexer.BatchesRx != 2 || indexer.EventsRx != 3 {
+			t.Fatalf("wrong batches indexed for %s data, got %d batches of %d events", r.name, indexer.BatchesRx, indexer.EventsRx)
+		}
+	}
+
+	// Events earlier than Since should be skipped.
+	indexer := &TestIndexer{}
+	im := NewImporter(indexer)
+	im.Since = parseTime("1982-02-05T05:00:00Z")
+	st, err := im.Import(strings.NewReader(data), p)
+	if err != nil {
+		t.Fatalf("failed to import data: %s", err.Error())
+	}
+	if exp := (ImportStats{Lines: 4, Imported: 2, Dropped: 1, Skipped: 1}); st != exp {
+		t.Fatalf("wrong stats, exp %+v, got %+v", exp, st)
+	}
+}
+
+func TestImporter_ImportCorpus(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+	e := NewEngine(dataDir)
+	e.RetentionPeriod = 100 * 365 * 24 * time.Hour
+	if err := e.Open(); err != nil {
+		t.Fatalf("failed to open engine: %s", err.Error())
+	}
+	defer e.Close()
+
+	f, err := os.Open("test_resources/logs1k.txt.bz2")
+	if err != nil {
+		t.Fatalf("failed to open corpus: %s", err.Error())
+	}
+	defer f.Close()
+
+	p, err := input.NewParser("syslog", input.ParserConfig{})
+	if err != nil {
+		t.Fatalf("failed to create parser: %s", err.Error())
+	}
+	st, err := NewImporter(e).Import(f, p)
+	if err != nil {
+		t.Fatalf("failed to import corpus: %s", err.Error())
+	}
+	if st.Lines != 738 || st.Imported != 738 {
+		t.Fatalf("wrong stats for corpus, got %+v", st)
+	}
+
+	total, err := e.Total()
+	if err != nil {
+		t.Fatalf("failed to get total: %s", err.Error())
+	}
+	if total != 738 {
+		t.Fatalf("wrong number of events indexed, exp 738, got %d", total)
+	}
+
+	// Events should be indexed according to their timestamps.
+	r := TimeRange{Start: parseTime("2015-08-24T00:45:44Z"), End: parseTime("2015-08-24T00:46:35Z")}
+	c, err := e.SearchRange("", r)
+	if err != nil {
+		t.Fatalf("failed to search corpus: %s", err.Error())
+	}
+	got := sources(c)
+	if len(got) != 3 || !strings.HasPrefix(got[0], "<134>0 2015-08-24T00:45:44.251252+00:00 fisher") {
+		t.Fatalf("wrong events in time range, got %v", got)
+	}
+}
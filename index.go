@@ -16,14 +16,17 @@ import (
 	"github.com/blevesearch/bleve"
 	"github.com/blevesearch/bleve/analysis/analyzer/custom"
 	"github.com/blevesearch/bleve/analysis/tokenizer/regexp"
+	"github.com/blevesearch/bleve/analysis/tokenizer/single"
 	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/search/query"
 )
 
 const (
-	endTimeFileName  = "endtime"
-	indexNameLayout  = "20060102_1504"
-	maxSearchHitSize = 10000
-	maxShardCount    = 9999
+	endTimeFileName = "endtime"
+	indexNameLayout = "20060102_1504"
+	searchPageSize  = 1000
+	maxShardCount   = 9999
+	docIDLength     = 32
 )
 
 // DocID is a string, with the following configuration. It's 32-characters long, encoding 2
@@ -32,6 +35,30 @@ const (
 // characters represent the least-significant 64-bit number.
 type DocID string
 
+// ParseDocID parses the given string as a DocID, returning an error if it is
+// not a valid DocID.
+func ParseDocID(s string) (DocID, error) {
+	if len(s) != docIDLength {
+		return "", fmt.Errorf("invalid document ID '%s'", s)
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return "", fmt.Errorf("invalid document ID '%s'", s)
+		}
+	}
+	return DocID(s), nil
+}
+
+// Time returns the time encoded in the most-significant 64-bit number of the DocID.
+// For the DocID of an Event, this is its reference time.
+func (d DocID) Time() time.Time {
+	n, err := strconv.ParseUint(string(d[0:16]), 16, 64)
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse 64-bit word: %s", err.Error()))
+	}
+	return time.Unix(0, int64(n)).UTC()
+}
+
 // DocIDs is a slice of DocIDs.
 type DocIDs []DocID
 
@@ -67,6 +94,55 @@ type Document interface {
 	Source() []byte
 }
 
+// MetadataDocument is implemented by Documents which have metadata that should be
+// stored alongside their source.
+type MetadataDocument interface {
+	Document
+	Metadata() ([]byte, error)
+}
+
+// IndexError is returned when documents could not be indexed. Documents which
+// are not listed were indexed.
+type IndexError struct {
+	mu     sync.Mutex
+	Failed map[DocID]error // The error for each document which could not be indexed.
+}
+
+// Error returns a description of the error, including that of one document.
+func (e *IndexError) Error() string {
+	for id, err := range e.Failed {
+		return fmt.Sprintf("failed to index %d document(s), including %s: %s", len(e.Failed), id, err.Error())
+	}
+	return "failed to index documents"
+}
+
+// add records that the given documents could not be indexed due to err. If err
+// is an IndexError, only the documents it lists are recorded.
+func (e *IndexError) add(documents []Document, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.Failed == nil {
+		e.Failed = make(map[DocID]error)
+	}
+	if ie, ok := err.(*IndexError); ok {
+		for id, err := range ie.Failed {
+			e.Failed[id] = err
+		}
+		return
+	}
+	for _, d := range documents {
+		e.Failed[d.ID()] = err
+	}
+}
+
+// err returns the IndexError, or nil if all documents were indexed.
+func (e *IndexError) err() error {
+	if len(e.Failed) == 0 {
+		return nil
+	}
+	return e
+}
+
 // Index represents a collection of shards. It contains data for a specific time range.
 type Index struct {
 	path      string    // Path to shard data
@@ -215,6 +291,9 @@ func OpenIndex(path string) (*Index, error) {
 // Path returns the path to storage for the index.
 func (i *Index) Path() string { return i.path }
 
+// Name returns the name of the index.
+func (i *Index) Name() string { return filepath.Base(i.path) }
+
 // StartTime returns the inclusive start time of the index.
 func (i *Index) StartTime() time.Time { return i.startTime }
 
@@ -227,6 +306,11 @@ func (i *Index) Expired(t time.Time, r time.Duration) bool {
 	return i.endTime.Add(r).Before(t)
 }
 
+// Size returns the size, in bytes, of the files of the index.
+func (i *Index) Size() (int64, error) {
+	return dirSize(i.path)
+}
+
 // Total returns the number of documents in the index.
 func (i *Index) Total() (uint64, error) {
 	var total uint64
@@ -246,7 +330,15 @@ func (i *Index) Contains(t time.Time) bool {
 	return (t.Equal(i.startTime) || t.After(i.startTime)) && t.Before(i.endTime)
 }
 
+// Overlaps returns whether the index's time range overlaps the given time
+// range. The start time is inclusive, and the end time exclusive. A zero start
+// or end time leaves that side of the range unbounded.
+func (i *Index) Overlaps(start, end time.Time) bool {
+	return (start.IsZero() || start.Before(i.endTime)) && (end.IsZero() || end.After(i.startTime))
+}
+
 // Index indexes the slice of documents in the index. It takes care of all shard routing.
+// If any documents could not be indexed, an *IndexError listing them is returned.
 func (i *Index) Index(documents []Document) error {
 	var wg sync.WaitGroup
 	shardBatches := make(map[*Shard][]Document, 0)
@@ -256,34 +348,47 @@ func (i *Index) Index(documents []Document) error {
 	}
 
 	// Index each batch in parallel.
+	var indexErr IndexError
 	for shard, batch := range shardBatches {
 		wg.Add(1)
 		go func(s *Shard, b []Document) {
 			defer wg.Done()
-			s.Index(b)
+			if err := s.Index(b); err != nil {
+				indexErr.add(b, err)
+			}
 		}(shard, batch)
 	}
 	wg.Wait()
-	return nil
+	return indexErr.err()
 }
 
-// Search performs a search of the index using the given query. Returns IDs of documents
-// which satisfy all queries. Returns Doc IDs in sorted order, ascending.
-func (i *Index) Search(q string) (DocIDs, error) {
-	query := bleve.NewQueryStringQuery(q)
-	searchRequest := bleve.NewSearchRequest(query)
-	searchRequest.Size = maxSearchHitSize
+// Search performs a search of the index using the given query. Returns the IDs of at
+// most size documents which satisfy the query, in ascending order, and the total number
+// of documents which satisfy the query. If after is set, only IDs greater than after are
+// returned, allowing the results to be paged through. An error is returned if any shard
+// cannot be searched.
+func (i *Index) Search(q query.Query, after DocID, size int) (DocIDs, uint64, error) {
+	searchRequest := bleve.NewSearchRequest(q)
+	searchRequest.Size = size
+	searchRequest.SortBy([]string{"_id"})
+	if after != "" {
+		searchRequest.SetSearchAfter([]string{string(after)})
+	}
 	searchResults, err := i.Alias.Search(searchRequest)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+	// Shards which fail are otherwise omitted from the results.
+	if searchResults.Status.Failed > 0 {
+		return nil, 0, fmt.Errorf("failed to search %d of %d shard(s): %v",
+			searchResults.Status.Failed, searchResults.Status.Total, searchResults.Status.Errors)
 	}
 
 	docIDs := make(DocIDs, 0, len(searchResults.Hits))
 	for _, d := range searchResults.Hits {
 		docIDs = append(docIDs, DocID(d.ID))
 	}
-	sort.Sort(docIDs)
-	return docIDs, nil
+	return docIDs, searchResults.Total, nil
 }
 
 // Document returns the source from the index for the given ID.
@@ -295,6 +400,16 @@ func (i *Index) Document(id DocID) ([]byte, error) {
 	return s.Document(id)
 }
 
+// Metadata returns the metadata stored for the given ID. If the document has
+// no metadata, nil is returned.
+func (i *Index) Metadata(id DocID) ([]byte, error) {
+	s := i.Shard(id)
+	if s == nil {
+		return nil, fmt.Errorf("document %s not found", id)
+	}
+	return s.Metadata(id)
+}
+
 // Close closes the index.
 func (i *Index) Close() error {
 	for _, s := range i.Shards {
@@ -305,6 +420,22 @@ func (i *Index) Close() error {
 	return nil
 }
 
+// dirSize returns the total size, in bytes, of the files within the directory
+// at the given path.
+func dirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(_ string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
 // DeleteIndex deletes the index.
 func DeleteIndex(i *Index) error {
 	_ = i.Close()
@@ -362,17 +493,39 @@ func (s *Shard) Close() error {
 	return s.b.Close()
 }
 
-// Index indexes a slice of Documents into the shard.
+// Index indexes a slice of Documents into the shard. If any documents could not be
+// indexed, an *IndexError listing them is returned.
 func (s *Shard) Index(documents []Document) error {
 	batch := s.b.NewBatch()
 
+	// Documents which cannot be added to the batch are skipped, so that the
+	// others are still indexed.
+	var indexErr IndexError
+	added := make([]Document, 0, len(documents))
 	for _, d := range documents {
+		var m []byte
+		if md, ok := d.(MetadataDocument); ok {
+			var err error
+			if m, err = md.Metadata(); err != nil {
+				indexErr.add([]Document{d}, err)
+				continue
+			}
+		}
 		if err := batch.Index(string(d.ID()), d.Data()); err != nil {
-			return err // XXX return errors en-masse
+			indexErr.add([]Document{d}, err)
+			continue
 		}
 		batch.SetInternal([]byte(d.ID()), d.Source())
+		if m != nil {
+			batch.SetInternal(metadataKey(d.ID()), m)
+		}
+		added = append(added, d)
+	}
+
+	if err := s.b.Batch(batch); err != nil {
+		indexErr.add(added, err)
 	}
-	return s.b.Batch(batch)
+	return indexErr.err()
 }
 
 // Total returns the number of events in the shard.
@@ -389,6 +542,17 @@ func (s *Shard) Document(id DocID) ([]byte, error) {
 	return source, nil
 }
 
+// Metadata returns the metadata from the shard for the given ID, or nil if the
+// document has no metadata.
+func (s *Shard) Metadata(id DocID) ([]byte, error) {
+	return s.b.GetInternal(metadataKey(id))
+}
+
+// metadataKey returns the key under which the metadata for the given ID is stored.
+func metadataKey(id DocID) []byte {
+	return []byte("m" + string(id))
+}
+
 // listShards returns the list of shards, in alphabetical order, in
 // the given directory.
 func listShards(path string) ([]string, error) {
@@ -442,13 +606,38 @@ func buildIndexMapping() (*mapping.IndexMappingImpl, error) {
 	}
 	indexMapping.DefaultAnalyzer = "ekanite"
 
+	// Fields such as hostnames are indexed as a single, case-insensitive, token.
+	err = indexMapping.AddCustomAnalyzer("ekanite_keyword",
+		map[string]interface{}{
+			"type":          custom.Name,
+			"char_filters":  []interface{}{},
+			"tokenizer":     single.Name,
+			"token_filters": []interface{}{`to_lower`},
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	// Parsed fields without explicit mappings are indexed, but not stored.
+	indexMapping.StoreDynamic = false
+
 	// Create field-specific mappings.
 
 	simpleJustIndexed := bleve.NewTextFieldMapping()
 	simpleJustIndexed.Store = false
-	simpleJustIndexed.IncludeInAll = true // XXX Move to false when using AST
+	simpleJustIndexed.IncludeInAll = false
 	simpleJustIndexed.IncludeTermVectors = false
 
+	keywordJustIndexed := bleve.NewTextFieldMapping()
+	keywordJustIndexed.Analyzer = "ekanite_keyword"
+	keywordJustIndexed.Store = false
+	keywordJustIndexed.IncludeInAll = false
+	keywordJustIndexed.IncludeTermVectors = false
+
+	numericJustIndexed := bleve.NewNumericFieldMapping()
+	numericJustIndexed.Store = false
+	numericJustIndexed.IncludeInAll = false
+
 	timeJustIndexed := bleve.NewDateTimeFieldMapping()
 	timeJustIndexed.Store = false
 	timeJustIndexed.IncludeInAll = false
@@ -460,6 +649,14 @@ func buildIndexMapping() (*mapping.IndexMappingImpl, error) {
 	articleMapping.AddFieldMappingsAt("Message", simpleJustIndexed)
 	articleMapping.AddFieldMappingsAt("ReferenceTime", timeJustIndexed)
 	articleMapping.AddFieldMappingsAt("ReceptionTime", timeJustIndexed)
+	for _, f := range searchFields {
+		switch f.kind {
+		case keywordField:
+			articleMapping.AddFieldMappingsAt(f.name, keywordJustIndexed)
+		case numericField:
+			articleMapping.AddFieldMappingsAt(f.name, numericJustIndexed)
+		}
+	}
 
 	// Tell the index about field mappings.
 	indexMapping.DefaultMapping = articleMapping
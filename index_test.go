@@ -1,10 +1,15 @@
 package ekanite
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"reflect"
 	"sort"
 	"testing"
 	"time"
+
+	"github.com/blevesearch/bleve"
 )
 
 type testDoc struct {
@@ -29,6 +34,43 @@ func (t testDoc) Data() interface{} {
 }
 func (t testDoc) Source() []byte { return []byte(t.line) }
 
+// badMetadataDoc is a testDoc whose metadata cannot be encoded.
+type badMetadataDoc struct {
+	testDoc
+}
+
+func (t badMetadataDoc) Metadata() ([]byte, error) {
+	return nil, errors.New("bad metadata")
+}
+
+func TestDocID_Parse(t *testing.T) {
+	tests := []struct {
+		s    string
+		fail bool
+	}{
+		{s: "000000000000000a0000000000000001"},
+		{s: "", fail: true},
+		{s: "000000000000000a000000000000001", fail: true},
+		{s: "000000000000000A0000000000000001", fail: true},
+		{s: "000000000000000g0000000000000001", fail: true},
+	}
+	for _, tt := range tests {
+		id, err := ParseDocID(tt.s)
+		if tt.fail != (err != nil) {
+			t.Errorf("wrong result parsing '%s' as doc ID, err: %v", tt.s, err)
+		}
+		if err == nil && string(id) != tt.s {
+			t.Errorf("wrong doc ID parsed, got %s, exp %s", id, tt.s)
+		}
+	}
+
+	rt := parseTime("1982-02-05T04:43:00Z")
+	ev := newIndexableEvent("auth password accepted", rt)
+	if !ev.ID().Time().Equal(rt) {
+		t.Fatalf("wrong time from doc ID, got %s, exp %s", ev.ID().Time(), rt)
+	}
+}
+
 func TestIndex_NewIndex(t *testing.T) {
 	path := tempPath()
 	defer os.RemoveAll(path)
@@ -80,6 +122,38 @@ func TestIndex_NewIndex(t *testing.T) {
 	}
 }
 
+func TestIndex_Overlaps(t *testing.T) {
+	path := tempPath()
+	defer os.RemoveAll(path)
+
+	startTime := parseTime("2006-01-02T22:00:00Z").UTC()
+	endTime := startTime.Add(time.Hour)
+	i, _ := NewIndex(path, startTime, endTime, 1)
+	defer i.Close()
+
+	tests := []struct {
+		start    time.Time
+		end      time.Time
+		overlaps bool
+	}{
+		{overlaps: true},
+		{start: startTime, overlaps: true},
+		{start: endTime.Add(-time.Second), overlaps: true},
+		{start: endTime, overlaps: false},
+		{end: startTime, overlaps: false},
+		{end: startTime.Add(time.Second), overlaps: true},
+		{start: startTime.Add(-2 * time.Hour), end: startTime.Add(-time.Hour), overlaps: false},
+		{start: startTime.Add(-time.Hour), end: endTime.Add(time.Hour), overlaps: true},
+		{start: startTime.Add(time.Minute), end: endTime.Add(-time.Minute), overlaps: true},
+	}
+	for n, tt := range tests {
+		if i.Overlaps(tt.start, tt.end) != tt.overlaps {
+			t.Errorf("Overlaps test #%d: index %s has incorrect overlap for %s - %s",
+				n, path, tt.start, tt.end)
+		}
+	}
+}
+
 func TestIndex_Expired(t *testing.T) {
 	path := tempPath()
 	defer os.RemoveAll(path)
@@ -195,6 +269,37 @@ func TestIndex_Index(t *testing.T) {
 	}
 }
 
+func TestIndex_IndexError(t *testing.T) {
+	path := tempPath()
+	defer os.RemoveAll(path)
+	now := time.Now().UTC()
+	i, _ := NewIndex(path, now, now, 2)
+
+	d1 := testDoc{id: DocID("00000000000000000000000000001234"), line: "password accepted for user root"}
+	d2 := badMetadataDoc{testDoc{id: DocID("00000000000000000000000000005678"), line: "GET /index.html"}}
+	d3 := testDoc{id: DocID("00000000000000000000000000009abc"), line: "sshd version 4.0"}
+
+	// Only the document which could not be indexed should be reported.
+	err := i.Index([]Document{d1, d2, d3})
+	ie, ok := err.(*IndexError)
+	if !ok {
+		t.Fatalf("wrong error indexing batch, got %v", err)
+	}
+	if len(ie.Failed) != 1 || ie.Failed[d2.ID()] == nil {
+		t.Fatalf("wrong documents failed, got %v", ie.Failed)
+	}
+	if n, _ := i.Total(); n != 2 {
+		t.Fatalf("wrong number of documents in index, exp 2, got %d", n)
+	}
+
+	// All documents should be reported once the index is closed.
+	i.Close()
+	err = i.Index([]Document{d1, d3})
+	if ie, ok := err.(*IndexError); !ok || len(ie.Failed) != 2 {
+		t.Fatalf("wrong error indexing batch into closed index, got %v", err)
+	}
+}
+
 func TestIndex_Document(t *testing.T) {
 	path := tempPath()
 	defer os.RemoveAll(path)
@@ -216,6 +321,14 @@ func TestIndex_Document(t *testing.T) {
 	if string(b) != source {
 		t.Fatalf(`source of retrieved document not correct, got: "%s", exp: "%s"`, string(b), source)
 	}
+
+	m, err := i.Metadata(id)
+	if err != nil {
+		t.Fatalf(`failed to retrieve metadata for document ID "%s": %s`, id, err.Error())
+	}
+	if m != nil {
+		t.Fatalf(`document without metadata returned metadata: "%s"`, string(m))
+	}
 }
 
 func TestIndex_IndexSimpleSearch(t *testing.T) {
@@ -304,7 +417,7 @@ func TestIndex_IndexSimpleSearch(t *testing.T) {
 		t.Logf("running '%s'", testName)
 		for _, tt := range tests {
 			// Perform the search
-			IDs, err := idx.Search(tt.Phrase)
+			IDs, _, err := idx.Search(bleve.NewQueryStringQuery(tt.Phrase), "", 100)
 			if err != nil {
 				t.Errorf("error while searching for '%s': %s", tt.Phrase, err.Error())
 			}
@@ -345,6 +458,50 @@ func TestIndex_IndexSimpleSearch(t *testing.T) {
 	f("re-opened index search test", i)
 }
 
+func TestIndex_SearchPaged(t *testing.T) {
+	path := tempPath()
+	defer os.RemoveAll(path)
+	now := time.Now().UTC()
+	i, _ := NewIndex(path, now, now, 4)
+
+	var docs []Document
+	var exp []string
+	for n := 0; n < 25; n++ {
+		id := DocID(fmt.Sprintf("%016x%016x", n, n))
+		docs = append(docs, testDoc{id: id, line: fmt.Sprintf("auth password accepted %d", n)})
+		exp = append(exp, string(id))
+	}
+	if err := i.Index(docs); err != nil {
+		t.Fatalf("failed to index batch into index at %s", path)
+	}
+
+	var got []string
+	var after DocID
+	for {
+		ids, total, err := i.Search(bleve.NewQueryStringQuery("password"), after, 10)
+		if err != nil {
+			t.Fatalf("error while searching: %s", err.Error())
+		}
+		if total != 25 {
+			t.Fatalf("wrong total number of hits, got %d, exp 25", total)
+		}
+		if len(ids) > 10 {
+			t.Fatalf("search returned %d IDs, more than requested", len(ids))
+		}
+		for _, id := range ids {
+			got = append(got, string(id))
+		}
+		if len(ids) < 10 {
+			break
+		}
+		after = ids[len(ids)-1]
+	}
+
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("paged search returned wrong IDs, got %v, exp %v", got, exp)
+	}
+}
+
 func TestIndex_Shard(t *testing.T) {
 	path := tempPath()
 	defer os.RemoveAll(path)
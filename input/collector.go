@@ -1,12 +1,10 @@
 package input
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"crypto/tls"
 	"expvar"
 	"fmt"
-	"io"
 	"net"
 	"strings"
 	"sync/atomic"
@@ -22,54 +20,156 @@ func init() {
 
 const (
 	newlineTimeout = time.Duration(1000 * time.Millisecond)
-	msgBufSize     = 256
+
+	// DefaultMaxMessageSize is the size, in bytes, of the longest log message
+	// accepted by collectors, unless otherwise configured. Longer messages are
+	// truncated.
+	DefaultMaxMessageSize = 64 * 1024
+
+	// MaxUDPMessageSize is the largest maximum message size of UDP collectors,
+	// as no larger message can be sent in a UDP datagram.
+	MaxUDPMessageSize = 64 * 1024
+
+	// TruncatedField is the field set on events for log lines which were
+	// truncated, if MarkTruncated of the ParserConfig is set.
+	TruncatedField = "truncated"
 )
 
 // Collector specifies the interface all network collectors must implement.
+// Stop stops the collector receiving log messages, once those already sent to
+// it are received. No events are sent once Stop returns, unless the context is
+// done first, in which case its error is returned.
 type Collector interface {
 	Start(chan<- *Event) error
+	Stop(ctx context.Context) error
 	Addr() net.Addr
 }
 
+// ParseEvent returns the event for the given log line, received from the given
+// address, parsed by the given parser. If the line cannot be parsed, it is
+// handled according to the Unparsed policy of the parser, and nil may be returned.
+func ParseEvent(parser *LogHandler, line, sourceIP string) *Event {
+	e := &Event{
+		Text:          line,
+		ReceptionTime: time.Now().UTC(),
+		Sequence:      atomic.AddInt64(&sequenceNumber, 1),
+		SourceIP:      sourceIP,
+	}
+	if !parser.Parse([]byte(line)) {
+		return parser.Unparsed.Unparsed(e, parser.Fmt)
+	}
+	e.Parsed = parser.Result
+	return e
+}
+
+// parseTruncatedEvent returns the event for the given log line, as ParseEvent
+// does, marking it if the log line was truncated and the parser is so configured.
+func parseTruncatedEvent(parser *LogHandler, line, sourceIP string, truncated bool) *Event {
+	e := ParseEvent(parser, line, sourceIP)
+	if e == nil || !truncated || !parser.MarkTruncated {
+		return e
+	}
+	if e.Parsed == nil {
+		e.Parsed = make(map[string]interface{})
+	}
+	e.Parsed[TruncatedField] = true
+	return e
+}
+
+// maxMessageSize returns the given maximum message size, or the default if it
+// is not set.
+func maxMessageSize(size int) int {
+	if size <= 0 {
+		return DefaultMaxMessageSize
+	}
+	return size
+}
+
+// readDatagram reads a datagram from conn into buf, which must be larger than
+// the maximum message size. It returns the log line it contains, whether the
+// line was truncated, and the address of the sender.
+func readDatagram(conn net.PacketConn, buf []byte, maxSize int) (string, bool, net.Addr, error) {
+	n, addr, err := conn.ReadFrom(buf)
+	if err != nil {
+		return "", false, addr, err
+	}
+	truncated := n > maxSize
+	if truncated {
+		n = maxSize
+	}
+	return strings.Trim(string(buf[:n]), "\r\n"), truncated, addr, nil
+}
+
 // TCPCollector represents a network collector that accepts and handler TCP connections.
 type TCPCollector struct {
-	iface  string
-	format string
+	iface   string
+	format  string
+	config  ParserConfig
+	maxSize int
 
 	addr      net.Addr
 	tlsConfig *tls.Config
+	ln        *listener
 }
 
 // UDPCollector represents a network collector that accepts UDP packets.
 type UDPCollector struct {
-	format string
-	addr   *net.UDPAddr
+	format  string
+	config  ParserConfig
+	maxSize int
+	addr    *net.UDPAddr
+	reader  *datagramReader
 }
 
 // NewCollector returns a network collector of the specified type, that will bind
-// to the given inteface on Start(). If config is non-nil, a secure Collector will
-// be returned. Secure Collectors require the protocol be TCP.
-func NewCollector(proto, iface, format string, tlsConfig *tls.Config) (Collector, error) {
+// to the given inteface on Start(), and parse log messages in the given format,
+// as configured by config. Log messages longer than maxSize bytes are
+// truncated, or, for HTTP, rejected; if maxSize is zero, DefaultMaxMessageSize
+// is used. If tlsConfig is non-nil, a secure Collector will be returned. Secure
+// Collectors require the protocol be TCP, RELP, or HTTP.
+func NewCollector(proto, iface, format string, config ParserConfig, maxSize int, tlsConfig *tls.Config) (Collector, error) {
 	// Verify that a parser can be instantiated. The actual parser that is used will
 	// be created by the connection handler.
-	_, err := NewParser(format)
+	_, err := NewParser(format, config)
 	if err != nil {
 		return nil, err
 	}
 
+	maxSize = maxMessageSize(maxSize)
 	if strings.ToLower(proto) == "tcp" {
 		return &TCPCollector{
 			iface:     iface,
 			format:    format,
+			config:    config,
+			maxSize:   maxSize,
+			tlsConfig: tlsConfig,
+		}, nil
+	} else if strings.ToLower(proto) == "relp" {
+		return &RELPCollector{
+			iface:     iface,
+			format:    format,
+			config:    config,
+			maxSize:   maxSize,
+			tlsConfig: tlsConfig,
+		}, nil
+	} else if strings.ToLower(proto) == "http" {
+		return &HTTPCollector{
+			iface:     iface,
+			format:    format,
+			config:    config,
+			maxSize:   maxSize,
 			tlsConfig: tlsConfig,
 		}, nil
 	} else if strings.ToLower(proto) == "udp" {
+		if maxSize > MaxUDPMessageSize {
+			return nil, fmt.Errorf("maximum UDP message size is %d bytes", MaxUDPMessageSize)
+		}
 		addr, err := net.ResolveUDPAddr("udp", iface)
 		if err != nil {
 			return nil, err
 		}
 
-		return &UDPCollector{addr: addr, format: format}, nil
+		return &UDPCollector{addr: addr, format: format, config: config, maxSize: maxSize}, nil
 	}
 	return nil, fmt.Errorf("unsupport collector protocol")
 }
@@ -87,19 +187,23 @@ func (s *TCPCollector) Start(c chan<- *Event) error {
 		return err
 	}
 	s.addr = ln.Addr()
+	s.ln = newListener(ln)
 
-	go func() {
-		for {
-			conn, err := ln.Accept()
-			if err != nil {
-				continue
-			}
-			go s.handleConnection(conn, c)
-		}
-	}()
+	go s.ln.serve(func(conn net.Conn) {
+		s.handleConnection(conn, c)
+	})
 	return nil
 }
 
+// Stop instructs the TCPCollector to stop accepting connections, and to close
+// existing connections once log lines already sent on them are received.
+func (s *TCPCollector) Stop(ctx context.Context) error {
+	if s.ln == nil {
+		return nil
+	}
+	return s.ln.close(ctx)
+}
+
 // Addr returns the net.Addr that the Collector is bound to, in a race-say manner.
 func (s *TCPCollector) Addr() net.Addr {
 	return s.addr
@@ -112,55 +216,21 @@ func (s *TCPCollector) handleConnection(conn net.Conn, c chan<- *Event) {
 		conn.Close()
 	}()
 
-	parser, err := NewParser(s.format)
+	parser, err := NewParser(s.format, s.config)
 	if err != nil {
 		panic(fmt.Sprintf("failed to create TCP connection parser:%s", err.Error()))
 	}
 
-	delimiter := NewSyslogDelimiter(msgBufSize)
-	reader := bufio.NewReader(conn)
-	var log string
-	var match bool
-
-	for {
-		conn.SetReadDeadline(time.Now().Add(newlineTimeout))
-		b, err := reader.ReadByte()
-		if err != nil {
-			stats.Add("tcpConnReadError", 1)
-			if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
-				stats.Add("tcpConnReadTimeout", 1)
-			} else if err == io.EOF {
-				stats.Add("tcpConnReadEOF", 1)
-			} else {
-				stats.Add("tcpConnUnrecoverError", 1)
-				return
-			}
-
-			log, match = delimiter.Vestige()
-		} else {
-			stats.Add("tcpBytesRead", 1)
-			log, match = delimiter.Push(b)
-		}
-
-		// Log line available?
-		if match {
-			stats.Add("tcpEventsRx", 1)
-			if parser.Parse(bytes.NewBufferString(log).Bytes()) {
-				c <- &Event{
-					Text:          string(parser.Raw),
-					Parsed:        parser.Result,
-					ReceptionTime: time.Now().UTC(),
-					Sequence:      atomic.AddInt64(&sequenceNumber, 1),
-					SourceIP:      conn.RemoteAddr().String(),
-				}
-			}
-		}
-
-		// Was the connection closed?
-		if err == io.EOF {
-			return
-		}
+	st := &stream{
+		conn:     conn,
+		proto:    "tcp",
+		sourceIP: conn.RemoteAddr().String(),
+		maxSize:  s.maxSize,
+		parser:   parser,
+		c:        c,
+		stop:     s.ln.stop,
 	}
+	st.read()
 }
 
 // Start instructs the UDPCollector to start reading packets from the interface.
@@ -169,29 +239,31 @@ func (s *UDPCollector) Start(c chan<- *Event) error {
 	if err != nil {
 		return err
 	}
+	s.addr = conn.LocalAddr().(*net.UDPAddr)
 
-	parser, err := NewParser(s.format)
+	parser, err := NewParser(s.format, s.config)
 	if err != nil {
 		panic(fmt.Sprintf("failed to create UDP parser:%s", err.Error()))
 	}
 
+	s.reader = newDatagramReader(conn)
 	go func() {
-		buf := make([]byte, msgBufSize)
+		defer close(s.reader.done)
+		buf := make([]byte, s.maxSize+1)
 		for {
-			n, addr, err := conn.ReadFromUDP(buf)
-			stats.Add("udpBytesRead", int64(n))
+			log, truncated, addr, err := readDatagram(conn, buf, s.maxSize)
+			stats.Add("udpBytesRead", int64(len(log)))
 			if err != nil {
+				if s.reader.stopped() {
+					return
+				}
 				continue
 			}
-			log := strings.Trim(string(buf[:n]), "\r\n")
-			if parser.Parse(bytes.NewBufferString(log).Bytes()) {
-				c <- &Event{
-					Text:          log,
-					Parsed:        parser.Result,
-					ReceptionTime: time.Now().UTC(),
-					Sequence:      atomic.AddInt64(&sequenceNumber, 1),
-					SourceIP:      addr.String(),
-				}
+			if truncated {
+				stats.Add("udpTruncated", 1)
+			}
+			if e := parseTruncatedEvent(parser, log, addr.String(), truncated); e != nil {
+				c <- e
 			}
 			stats.Add("udpEventsRx", 1)
 		}
@@ -199,6 +271,15 @@ func (s *UDPCollector) Start(c chan<- *Event) error {
 	return nil
 }
 
+// Stop instructs the UDPCollector to stop reading packets, once packets already
+// received are read.
+func (s *UDPCollector) Stop(ctx context.Context) error {
+	if s.reader == nil {
+		return nil
+	}
+	return s.reader.close(ctx)
+}
+
 // Addr returns the net.Addr to which the UDP collector is bound.
 func (s *UDPCollector) Addr() net.Addr {
 	return s.addr
@@ -0,0 +1,100 @@
+package input
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_CollectorMaxMessageSize(t *testing.T) {
+	if _, err := NewCollector("udp", "127.0.0.1:0", "syslog", ParserConfig{}, MaxUDPMessageSize+1, nil); err == nil {
+		t.Fatalf("created UDP collector with too large maximum message size")
+	}
+
+	short := "<13>1 2003-10-11T22:14:15.003Z - myapp 12 - - hello"
+	long := "<13>1 2003-10-11T22:14:15.003Z - myapp 12 - - " + strings.Repeat("x", 1000)
+	for _, proto := range []string{"udp", "tcp"} {
+		collector, err := NewCollector(proto, "127.0.0.1:0", "syslog", ParserConfig{MarkTruncated: true}, 512, nil)
+		if err != nil {
+			t.Fatalf("failed to create %s collector: %s", proto, err.Error())
+		}
+		c := make(chan *Event, 2)
+		if err := collector.Start(c); err != nil {
+			t.Fatalf("failed to start %s collector: %s", proto, err.Error())
+		}
+		conn, err := net.Dial(proto, collector.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to connect to %s collector: %s", proto, err.Error())
+		}
+
+		for _, line := range []string{long, short} {
+			if proto == "tcp" {
+				line += "\n"
+			}
+			if _, err := conn.Write([]byte(line)); err != nil {
+				t.Fatalf("failed to write to %s collector: %s", proto, err.Error())
+			}
+		}
+
+		for _, exp := range []struct {
+			text      string
+			truncated bool
+		}{
+			{text: long[:512], truncated: true},
+			{text: short},
+		} {
+			select {
+			case e := <-c:
+				if e.Text != exp.text {
+					t.Fatalf("wrong text received by %s collector, exp %s, got %s", proto, exp.text, e.Text)
+				}
+				if _, ok := e.Parsed[TruncatedField]; ok != exp.truncated {
+					t.Fatalf("wrong truncated field for %s collector, exp %v, got %v", proto, exp.truncated, e.Parsed)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for event from %s collector", proto)
+			}
+		}
+		conn.Close()
+	}
+}
+
+func Test_CollectorStop(t *testing.T) {
+	line := "<13>1 2003-10-11T22:14:15.003Z - myapp 12 - - hello"
+	for _, proto := range []string{"udp", "tcp"} {
+		collector, err := NewCollector(proto, "127.0.0.1:0", "syslog", ParserConfig{}, 0, nil)
+		if err != nil {
+			t.Fatalf("failed to create %s collector: %s", proto, err.Error())
+		}
+		c := make(chan *Event, 2)
+		if err := collector.Start(c); err != nil {
+			t.Fatalf("failed to start %s collector: %s", proto, err.Error())
+		}
+		conn, err := net.Dial(proto, collector.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to connect to %s collector: %s", proto, err.Error())
+		}
+		if _, err := conn.Write([]byte(line + "\n")); err != nil {
+			t.Fatalf("failed to write to %s collector: %s", proto, err.Error())
+		}
+
+		// The log line already sent should be received before Stop returns.
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := collector.Stop(ctx); err != nil {
+			t.Fatalf("failed to stop %s collector: %s", proto, err.Error())
+		}
+		cancel()
+		if len(c) != 1 {
+			t.Fatalf("wrong number of events received by %s collector, exp 1, got %d", proto, len(c))
+		}
+		conn.Close()
+
+		if proto == "tcp" {
+			if _, err := net.Dial(proto, collector.Addr().String()); err == nil {
+				t.Fatalf("connected to stopped %s collector", proto)
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package input
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	// maxOctetCountDigits is the largest number of digits accepted in the
+	// MSG-LEN of an octet-counted frame.
+	maxOctetCountDigits = 9
+)
+
+var (
+	errOctetCountInvalid = errors.New("octet-count-invalid")
+)
+
+// IsOctetCounted returns whether a stream beginning with the given byte uses
+// octet-counting framing. Octet-counted frames begin with a non-zero digit,
+// whereas syslog messages framed by newlines begin with '<'.
+func IsOctetCounted(b byte) bool {
+	return b >= '1' && b <= '9'
+}
+
+// An OctetCountingReader reads syslog messages framed using octet-counting,
+// as described by RFC 6587, section 3.4.1. Each message is preceded by its
+// length in bytes and a space, so messages may contain any bytes, including
+// newlines.
+type OctetCountingReader struct {
+	reader    *bufio.Reader
+	maxSize   int
+	truncated bool
+}
+
+// NewOctetCountingReader returns an OctetCountingReader reading from the given
+// reader. Messages longer than maxSize bytes are truncated.
+func NewOctetCountingReader(r *bufio.Reader, maxSize int) *OctetCountingReader {
+	return &OctetCountingReader{
+		reader:  r,
+		maxSize: maxSize,
+	}
+}
+
+// Next returns the next message. io.EOF is returned if the stream ends cleanly
+// between messages. Any other error means framing has been lost, and no further
+// messages can be read.
+func (o *OctetCountingReader) Next() (string, error) {
+	n, err := o.readLength()
+	if err != nil {
+		return "", err
+	}
+
+	o.truncated = n > o.maxSize
+	size := n
+	if o.truncated {
+		size = o.maxSize
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(o.reader, buf); err != nil {
+		return "", unexpectedEOF(err)
+	}
+	if o.truncated {
+		if _, err := io.CopyN(ioutil.Discard, o.reader, int64(n-size)); err != nil {
+			return "", unexpectedEOF(err)
+		}
+		return string(buf), nil
+	}
+	return strings.TrimRight(string(buf), "\r\n"), nil
+}
+
+// Truncated returns whether the last message returned was truncated.
+func (o *OctetCountingReader) Truncated() bool {
+	return o.truncated
+}
+
+// readLength reads the MSG-LEN and the following space of the next frame.
+// Any whitespace between frames, such as a trailing newline added by some
+// senders, is skipped.
+func (o *OctetCountingReader) readLength() (int, error) {
+	var b byte
+	var err error
+	for {
+		if b, err = o.reader.ReadByte(); err != nil {
+			return 0, err
+		}
+		if b != '\n' && b != '\r' {
+			break
+		}
+	}
+	if !IsOctetCounted(b) {
+		return 0, errOctetCountInvalid
+	}
+
+	n := int(b - '0')
+	for digits := 1; ; digits++ {
+		if b, err = o.reader.ReadByte(); err != nil {
+			return 0, unexpectedEOF(err)
+		}
+		if b == ' ' {
+			return n, nil
+		}
+		if b < '0' || b > '9' || digits == maxOctetCountDigits {
+			return 0, errOctetCountInvalid
+		}
+		n = n*10 + int(b-'0')
+	}
+}
+
+// unexpectedEOF converts io.EOF to io.ErrUnexpectedEOF, for use when a stream
+// ends part way through a frame.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
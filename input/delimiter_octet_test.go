@@ -0,0 +1,109 @@
+package input
+
+import (
+	"bufio"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+/*
+ * OctetCountingReader tests.
+ */
+
+func Test_OctetCountingReader(t *testing.T) {
+	tests := []struct {
+		name     string
+		stream   string
+		expected []string
+		err      error
+	}{
+		{
+			name:     "simple",
+			stream:   "18 <11>1 sshd is down16 <22>1 sshd is up",
+			expected: []string{"<11>1 sshd is down", "<22>1 sshd is up"},
+			err:      io.EOF,
+		},
+		{
+			name:     "stacktrace",
+			stream:   "54 <145>1 OOM on line 42, dummy.java\n<12>class_loader.jar18 <11>1 sshd is down",
+			expected: []string{"<145>1 OOM on line 42, dummy.java\n<12>class_loader.jar", "<11>1 sshd is down"},
+			err:      io.EOF,
+		},
+		{
+			name:     "trailing newlines",
+			stream:   "19 <11>1 sshd is down\n\r\n17 <22>1 sshd is up\n",
+			expected: []string{"<11>1 sshd is down", "<22>1 sshd is up"},
+			err:      io.EOF,
+		},
+		{
+			name:     "too large",
+			stream:   "69 <11>1 sshd is down and stays down until the administrator restarts it16 <22>1 sshd is up",
+			expected: []string{"<11>1 sshd is down and stays down until the administrator restar*", "<22>1 sshd is up"},
+			err:      io.EOF,
+		},
+		{
+			name:     "truncated",
+			stream:   "18 <11>1 sshd is down16 <22>1 sshd",
+			expected: []string{"<11>1 sshd is down"},
+			err:      io.ErrUnexpectedEOF,
+		},
+		{
+			name:     "missing length",
+			stream:   "18 <11>1 sshd is down<22>1 sshd is up",
+			expected: []string{"<11>1 sshd is down"},
+			err:      errOctetCountInvalid,
+		},
+		{
+			name:     "invalid length",
+			stream:   "18x<11>1 sshd is down",
+			expected: []string{},
+			err:      errOctetCountInvalid,
+		},
+		{
+			name:     "length overflow",
+			stream:   "1234567890 <11>1 sshd is down",
+			expected: []string{},
+			err:      errOctetCountInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		r := NewOctetCountingReader(bufio.NewReader(strings.NewReader(tt.stream)), 64)
+		events := []string{}
+
+		var err error
+		for {
+			var event string
+			event, err = r.Next()
+			if err != nil {
+				break
+			}
+			if r.Truncated() {
+				event += "*"
+			}
+			events = append(events, event)
+		}
+
+		if !reflect.DeepEqual(events, tt.expected) {
+			t.Errorf("test %s: failed to read octet-counted messages, exp %v, got %v", tt.name, tt.expected, events)
+		}
+		if err != tt.err {
+			t.Errorf("test %s: wrong final error, exp %v, got %v", tt.name, tt.err, err)
+		}
+	}
+}
+
+func Test_IsOctetCounted(t *testing.T) {
+	for _, b := range []byte("123456789") {
+		if !IsOctetCounted(b) {
+			t.Errorf("%c not detected as octet-counted", b)
+		}
+	}
+	for _, b := range []byte("<0 \na") {
+		if IsOctetCounted(b) {
+			t.Errorf("%c detected as octet-counted", b)
+		}
+	}
+}
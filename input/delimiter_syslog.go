@@ -8,6 +8,10 @@ import (
 const (
 	// SYSLOG_DELIMITER indicates the start of a syslog line
 	SYSLOG_DELIMITER = `<[0-9]{1,3}>`
+
+	// maxDelimiterSize is the length of the longest delimiter, including the
+	// preceding newline.
+	maxDelimiterSize = len("\n<999>")
 )
 
 var syslogRegex *regexp.Regexp
@@ -17,20 +21,27 @@ var runRegex *regexp.Regexp
 func init() {
 	syslogRegex = regexp.MustCompile(SYSLOG_DELIMITER)
 	startRegex = regexp.MustCompile(SYSLOG_DELIMITER + `$`)
-	runRegex = regexp.MustCompile(`\n` + SYSLOG_DELIMITER)
+	runRegex = regexp.MustCompile(`\n` + SYSLOG_DELIMITER + `$`)
 }
 
-// A SyslogDelimiter detects when Syslog lines start.
+// A SyslogDelimiter detects when Syslog lines start. Lines longer than the
+// maximum size are truncated.
 type SyslogDelimiter struct {
-	buffer []byte
-	regex  *regexp.Regexp
+	buffer  []byte
+	regex   *regexp.Regexp
+	maxSize int
+
+	discarding bool // Whether the remainder of a truncated line is being discarded
+	truncated  bool // Whether the last line returned was truncated
 }
 
-// NewSyslogDelimiter returns an initialized SyslogDelimiter.
+// NewSyslogDelimiter returns an initialized SyslogDelimiter, returning lines of
+// at most maxSize bytes.
 func NewSyslogDelimiter(maxSize int) *SyslogDelimiter {
 	s := &SyslogDelimiter{}
-	s.buffer = make([]byte, 0, maxSize)
+	s.buffer = make([]byte, 0, maxSize+maxDelimiterSize)
 	s.regex = startRegex
+	s.maxSize = maxSize
 	return s
 }
 
@@ -38,34 +49,83 @@ func NewSyslogDelimiter(maxSize int) *SyslogDelimiter {
 // a new Syslog message, it'll be flagged via the bool.
 func (s *SyslogDelimiter) Push(b byte) (string, bool) {
 	s.buffer = append(s.buffer, b)
-	delimiter := s.regex.FindIndex(s.buffer)
+
+	// Any delimiter ends with the byte just pushed.
+	tail := len(s.buffer) - maxDelimiterSize
+	if tail < 0 {
+		tail = 0
+	}
+	delimiter := s.regex.FindIndex(s.buffer[tail:])
 	if delimiter == nil {
-		return "", false
+		return s.overflow()
 	}
+	start := tail + delimiter[0]
 
 	if s.regex == startRegex {
 		// First match -- switch to the regex for embedded lines, and
 		// drop any leading characters.
-		s.buffer = s.buffer[delimiter[0]:]
+		s.buffer = s.buffer[start:]
 		s.regex = runRegex
 		return "", false
 	}
 
-	dispatch := strings.TrimRight(string(s.buffer[:delimiter[0]]), "\r")
-	s.buffer = s.buffer[delimiter[0]+1:]
-	return dispatch, true
+	line := strings.TrimRight(string(s.buffer[:start]), "\r")
+	s.buffer = append(s.buffer[:0], s.buffer[start+1:]...)
+	if s.discarding {
+		s.discarding = false
+		return "", false
+	}
+	// The delimiter may be found before the buffer overflows, even though the
+	// line is longer than the maximum size.
+	s.truncated = len(line) > s.maxSize
+	if s.truncated {
+		line = line[:s.maxSize]
+	}
+	return line, true
+}
+
+// overflow handles a buffer which may hold more than a line of the maximum
+// size. If so, the line is truncated and returned, and the remainder discarded
+// until the next delimiter. Only enough bytes to detect that delimiter are kept.
+func (s *SyslogDelimiter) overflow() (string, bool) {
+	if len(s.buffer) < s.maxSize+maxDelimiterSize {
+		return "", false
+	}
+
+	var line string
+	if s.regex == runRegex && !s.discarding {
+		line = string(s.buffer[:s.maxSize])
+		s.discarding = true
+	}
+	s.buffer = append(s.buffer[:0], s.buffer[len(s.buffer)-maxDelimiterSize+1:]...)
+	if line == "" {
+		return "", false
+	}
+	s.truncated = true
+	return line, true
 }
 
 // Vestige returns the bytes which have been pushed to SyslogDelimiter, since
 // the last Syslog message was returned, but only if the buffer appears
 // to be a valid syslog message.
 func (s *SyslogDelimiter) Vestige() (string, bool) {
+	defer func() {
+		s.buffer = s.buffer[:0]
+		s.discarding = false
+	}()
+
 	delimiter := syslogRegex.FindIndex(s.buffer)
-	if delimiter == nil {
-		s.buffer = nil
+	if delimiter == nil || s.discarding {
 		return "", false
 	}
-	dispatch := strings.TrimRight(string(s.buffer), "\r\n")
-	s.buffer = nil
-	return dispatch, true
+	s.truncated = len(s.buffer) > s.maxSize
+	if s.truncated {
+		return string(s.buffer[:s.maxSize]), true
+	}
+	return strings.TrimRight(string(s.buffer), "\r\n"), true
+}
+
+// Truncated returns whether the last line returned was truncated.
+func (s *SyslogDelimiter) Truncated() bool {
+	return s.truncated
 }
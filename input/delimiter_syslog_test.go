@@ -108,3 +108,52 @@ func TestSyslogDelimiter_Vestige(t *testing.T) {
 		}
 	}
 }
+
+func TestSyslogDelimiter_Truncated(t *testing.T) {
+	d := NewSyslogDelimiter(16)
+	line := "<11>1 sshd is down and stays down\n<22>1 sshd is up\n<67>1 sshd is down again\r\n<33>1 ok\n<12>1"
+	var events []string
+	for _, b := range []byte(line) {
+		if event, match := d.Push(b); match {
+			if d.Truncated() {
+				event += "*"
+			}
+			events = append(events, event)
+		}
+	}
+	if event, match := d.Vestige(); match {
+		events = append(events, event)
+	}
+
+	exp := []string{"<11>1 sshd is do*", "<22>1 sshd is up", "<67>1 sshd is do*", "<33>1 ok", "<12>1"}
+	if len(events) != len(exp) {
+		t.Fatalf("wrong events, exp %v, got %v", exp, events)
+	}
+	for i := range exp {
+		if events[i] != exp[i] {
+			t.Fatalf("wrong event %d, exp %s, got %s", i, exp[i], events[i])
+		}
+	}
+
+	// A line one byte longer than the maximum size is delimited before the
+	// buffer overflows.
+	d = NewSyslogDelimiter(16)
+	var event string
+	var match bool
+	for _, b := range []byte("<11>1 sshd is dow\n<22>1") {
+		if e, m := d.Push(b); m {
+			event, match = e, m
+		}
+	}
+	if !match || event != "<11>1 sshd is do" || !d.Truncated() {
+		t.Fatalf("wrong line one byte too long, got %s %v", event, match)
+	}
+
+	d = NewSyslogDelimiter(16)
+	for _, b := range []byte("<11>1 sshd is down!") {
+		d.Push(b)
+	}
+	if event, match := d.Vestige(); !match || event != "<11>1 sshd is do" || !d.Truncated() {
+		t.Fatalf("wrong truncated vestige, got %s %v", event, match)
+	}
+}
@@ -9,6 +9,7 @@ type Event struct {
 	ReceptionTime time.Time              // Time log line was received
 	Sequence      int64                  // Provides order of reception
 	SourceIP      string                 // Sender's IP address
+	Ack           func()                 // If non-nil, called once the event is indexed or queued
 
 	referenceTime time.Time // Memomized reference time
 }
@@ -21,9 +22,8 @@ func NewEvent() *Event {
 // ReferenceTime returns the reference time of an event.
 func (e *Event) ReferenceTime() time.Time {
 	if e.referenceTime.IsZero() {
-		if e.Parsed == nil {
-			e.referenceTime = e.ReceptionTime
-		} else if refTime, err := time.Parse(time.RFC3339, e.Parsed["timestamp"].(string)); err != nil {
+		ts, _ := e.Parsed["timestamp"].(string)
+		if refTime, err := time.Parse(time.RFC3339, ts); err != nil {
 			e.referenceTime = e.ReceptionTime
 		} else {
 			e.referenceTime = refTime
@@ -0,0 +1,532 @@
+package input
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultFilePollInterval is how often files are checked for new lines.
+	DefaultFilePollInterval = time.Second
+
+	// maxFileLineSize is the largest line read from a file. Longer lines are
+	// discarded.
+	maxFileLineSize = 64 * 1024
+
+	// fileFingerprintSize is the number of bytes, from the start of a file,
+	// checkpointed to recognise the file after a restart.
+	fileFingerprintSize = 256
+)
+
+// FileCollector represents a collector that follows files matching one or more
+// glob patterns, such as /var/log/app/*.log, reading log lines as they are
+// appended. Files which are rotated, by renaming or removal, are read to the end
+// before their replacement is followed, and files which are truncated are read
+// again from the start. The offset in each file up to which events have been
+// acknowledged is checkpointed, so following resumes where it left off when the
+// collector is restarted, reading again any lines not yet indexed or queued.
+type FileCollector struct {
+	patterns   []string
+	format     string
+	config     ParserConfig
+	checkpoint string
+	interval   time.Duration
+
+	files        []*tailedFile
+	checkpointed map[string]fileCheckpoint // Checkpointed offsets of files not yet followed
+	dirty        bool
+
+	mu      sync.Mutex // Serializes writing the checkpoint
+	stopped bool       // Whether files are no longer followed, so acknowledgements write the checkpoint
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// tailedFile is a file being followed.
+type tailedFile struct {
+	path     string
+	f        *os.File
+	fi       os.FileInfo
+	offset   int64  // Offset read to
+	skipping bool   // Whether a line which is too long is being discarded
+	buf      []byte // Buffer for reading the file, reused by every read
+	print    []byte // The start of the file, identifying it in the checkpoint
+	saved    int64  // Offset last checkpointed
+
+	mu      sync.Mutex
+	acked   int64       // Offset to which the events of all lines have been acknowledged
+	pending []*fileLine // Lines whose events are not yet acknowledged, in order
+}
+
+// fileLine is a line read from a tailed file.
+type fileLine struct {
+	end   int64 // Offset of the end of the line
+	acked bool
+}
+
+// fileCheckpoint is the checkpointed offset of a file.
+type fileCheckpoint struct {
+	Path        string `json:"path"`
+	Offset      int64  `json:"offset"`
+	Fingerprint []byte `json:"fingerprint"`
+}
+
+// fileAddr is the address of a FileCollector.
+type fileAddr string
+
+func (a fileAddr) Network() string { return "file" }
+func (a fileAddr) String() string  { return string(a) }
+
+// NewFileCollector returns a collector that will, on Start(), follow the files
+// matching the given glob patterns, parsing them in the given format, as
+// configured by config. Offsets are checkpointed to the file at the given path.
+func NewFileCollector(patterns []string, format string, config ParserConfig, checkpoint string) (Collector, error) {
+	// Verify that a parser can be instantiated.
+	_, err := NewParser(format, config)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range patterns {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %s", p, err.Error())
+		}
+	}
+	return &FileCollector{
+		patterns:   patterns,
+		format:     format,
+		config:     config,
+		checkpoint: checkpoint,
+		interval:   DefaultFilePollInterval,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}, nil
+}
+
+// Start instructs the FileCollector to start following files.
+func (s *FileCollector) Start(c chan<- *Event) error {
+	if err := s.loadCheckpoint(); err != nil {
+		return err
+	}
+
+	parser, err := NewParser(s.format, s.config)
+	if err != nil {
+		panic(fmt.Sprintf("failed to create file parser:%s", err.Error()))
+	}
+
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			s.poll(parser, c)
+			select {
+			case <-ticker.C:
+			case <-s.stop:
+				// Read any lines appended since the last poll.
+				s.poll(parser, c)
+				s.finish()
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// finish stops following files. Events of lines which are acknowledged from now
+// on are checkpointed as they are acknowledged, and those acknowledged since the
+// last poll are checkpointed now.
+func (s *FileCollector) finish() {
+	s.mu.Lock()
+	s.stopped = true
+	s.writeCheckpoint()
+	s.mu.Unlock()
+	for _, t := range s.files {
+		t.f.Close()
+	}
+}
+
+// Stop instructs the FileCollector to stop following files, once lines already
+// written to them are read.
+func (s *FileCollector) Stop(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Addr returns the glob patterns followed by the collector.
+func (s *FileCollector) Addr() net.Addr {
+	return fileAddr(strings.Join(s.patterns, ","))
+}
+
+// poll reads any new lines from the files matching the patterns, and
+// checkpoints the offsets to which lines have been acknowledged.
+func (s *FileCollector) poll(parser *LogHandler, c chan<- *Event) {
+	// Read the remainder of any file which has been rotated, as it will not
+	// be written to again.
+	var rotated []*tailedFile
+	followed := s.files[:0]
+	for _, t := range s.files {
+		fi, err := os.Stat(t.path)
+		if err != nil || !os.SameFile(fi, t.fi) {
+			s.read(t, parser, c, true)
+			t.f.Close()
+			rotated = append(rotated, t)
+			s.dirty = true
+			stats.Add("fileRotated", 1)
+			continue
+		}
+		if fi.Size() < t.offset {
+			stats.Add("fileTruncated", 1)
+			t.truncate()
+			s.dirty = true
+		}
+		t.fi = fi
+		followed = append(followed, t)
+	}
+	s.files = followed
+
+	matched := s.match()
+	for _, path := range matched {
+		if s.following(path) {
+			continue
+		}
+		t, err := s.open(path, rotated)
+		if err != nil {
+			stats.Add("fileOpenError", 1)
+			continue
+		}
+		s.files = append(s.files, t)
+		s.dirty = true
+	}
+
+	for _, t := range s.files {
+		s.read(t, parser, c, false)
+	}
+
+	// Offsets of files which were rotated or deleted while not followed will
+	// never be needed.
+	s.pruneCheckpoint(matched)
+
+	for _, t := range s.files {
+		if t.ackedOffset() != t.saved {
+			s.dirty = true
+		}
+	}
+	if s.dirty {
+		s.mu.Lock()
+		if s.writeCheckpoint() {
+			s.dirty = false
+		}
+		s.mu.Unlock()
+	}
+}
+
+// pruneCheckpoint removes the checkpointed offsets of files not among the given
+// paths.
+func (s *FileCollector) pruneCheckpoint(paths []string) {
+	if len(s.checkpointed) == 0 {
+		return
+	}
+	exists := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		exists[path] = true
+	}
+	for path := range s.checkpointed {
+		if !exists[path] {
+			delete(s.checkpointed, path)
+			stats.Add("fileCheckpointPruned", 1)
+			s.dirty = true
+		}
+	}
+}
+
+// ack records that the event of the given line was acknowledged. Once the
+// collector is stopped, the checkpoint is written at once, as it is no longer
+// written by polling.
+func (s *FileCollector) ack(t *tailedFile, l *fileLine) {
+	t.ack(l)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		s.writeCheckpoint()
+	}
+}
+
+// writeCheckpoint writes the checkpoint, logging any failure. It returns whether
+// the checkpoint was written. s.mu must be held.
+func (s *FileCollector) writeCheckpoint() bool {
+	if err := s.saveCheckpoint(); err != nil {
+		stats.Add("fileCheckpointError", 1)
+		log.Printf("failed to checkpoint file offsets to %s: %s", s.checkpoint, err.Error())
+		return false
+	}
+	return true
+}
+
+// match returns the paths of all regular files matching the patterns.
+func (s *FileCollector) match() []string {
+	var paths []string
+	seen := make(map[string]bool)
+	for _, p := range s.patterns {
+		matches, _ := filepath.Glob(p)
+		for _, m := range matches {
+			if fi, err := os.Stat(m); err != nil || !fi.Mode().IsRegular() || seen[m] {
+				continue
+			}
+			seen[m] = true
+			paths = append(paths, m)
+		}
+	}
+	return paths
+}
+
+// following returns whether the file at the given path is being followed.
+func (s *FileCollector) following(path string) bool {
+	for _, t := range s.files {
+		if t.path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// open starts following the file at the given path. If the file was rotated
+// from another path, or was followed before a restart, reading continues from
+// where it left off. Otherwise the file is read from the start.
+func (s *FileCollector) open(path string, rotated []*tailedFile) (*tailedFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	// A file rotated to another path which is followed continues to be
+	// followed, along with its lines not yet acknowledged.
+	for _, r := range rotated {
+		if os.SameFile(fi, r.fi) {
+			r.path, r.f, r.fi = path, f, fi
+			return r, nil
+		}
+	}
+
+	t := &tailedFile{path: path, f: f, fi: fi}
+	if cp, ok := s.checkpointed[path]; ok {
+		delete(s.checkpointed, path)
+		if fi.Size() >= cp.Offset && bytes.Equal(fingerprint(f, cp.Offset), cp.Fingerprint) {
+			t.offset, t.acked = cp.Offset, cp.Offset
+		}
+	}
+	return t, nil
+}
+
+// read reads the complete lines appended to the file since it was last read.
+// If final is set, any incomplete line at the end of the file is also read.
+func (s *FileCollector) read(t *tailedFile, parser *LogHandler, c chan<- *Event, final bool) {
+	if t.buf == nil {
+		t.buf = make([]byte, maxFileLineSize)
+	}
+	buf := t.buf
+	defer t.updateFingerprint()
+	for {
+		n, err := t.f.ReadAt(buf, t.offset)
+		if n == 0 {
+			if err != nil && err != io.EOF {
+				stats.Add("fileReadError", 1)
+			}
+			return
+		}
+		stats.Add("fileBytesRead", int64(n))
+
+		b := buf[:n]
+		for {
+			i := bytes.IndexByte(b, '\n')
+			if i < 0 {
+				break
+			}
+			t.offset += int64(i + 1)
+			s.dispatch(t, parser, c, b[:i])
+			b = b[i+1:]
+		}
+
+		if len(b) == len(buf) {
+			// No newline within the longest line which is accepted.
+			if !t.skipping {
+				stats.Add("fileLineTooLong", 1)
+			}
+			t.offset += int64(len(b))
+			t.skipping = true
+			t.ack(t.read())
+			continue
+		}
+		if err != nil {
+			if final && len(b) > 0 {
+				t.offset += int64(len(b))
+				s.dispatch(t, parser, c, b)
+			}
+			return
+		}
+	}
+}
+
+// dispatch sends the event for the given line, which ends at the offset read
+// to, unless it is the end of a line which is too long. Lines without an event
+// are acknowledged at once.
+func (s *FileCollector) dispatch(t *tailedFile, parser *LogHandler, c chan<- *Event, line []byte) {
+	l := t.read()
+	if t.skipping {
+		t.skipping = false
+		t.ack(l)
+		return
+	}
+	text := strings.TrimRight(string(line), "\r")
+	if text == "" {
+		t.ack(l)
+		return
+	}
+	stats.Add("fileEventsRx", 1)
+
+	// Log lines which could not be parsed have already been handled according
+	// to the Unparsed policy, so are acknowledged at once.
+	e := ParseEvent(parser, text, "")
+	if e == nil {
+		t.ack(l)
+		return
+	}
+	e.Ack = func() {
+		stats.Add("fileEventsAcked", 1)
+		s.ack(t, l)
+	}
+	c <- e
+}
+
+// read records that the file was read to its current offset, returning the
+// line ending there.
+func (t *tailedFile) read() *fileLine {
+	l := &fileLine{end: t.offset}
+	t.mu.Lock()
+	t.pending = append(t.pending, l)
+	t.mu.Unlock()
+	return l
+}
+
+// ack records that the given line was acknowledged, advancing the acknowledged
+// offset over all leading lines which have been acknowledged.
+func (t *tailedFile) ack(l *fileLine) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l.acked = true
+	for len(t.pending) > 0 && t.pending[0].acked {
+		t.acked = t.pending[0].end
+		t.pending = t.pending[1:]
+	}
+}
+
+// ackedOffset returns the offset to which all lines have been acknowledged.
+func (t *tailedFile) ackedOffset() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.acked
+}
+
+// truncate restarts reading the file from the start. Lines read before the
+// file was truncated no longer advance the acknowledged offset.
+func (t *tailedFile) truncate() {
+	t.offset, t.skipping, t.print = 0, false, nil
+	t.mu.Lock()
+	t.acked, t.pending = 0, nil
+	t.mu.Unlock()
+}
+
+// updateFingerprint reads the start of the file, if not already read in full.
+func (t *tailedFile) updateFingerprint() {
+	if len(t.print) < fileFingerprintSize {
+		t.print = fingerprint(t.f, fileFingerprintSize)
+	}
+}
+
+// loadCheckpoint reads the checkpointed offsets, if any.
+func (s *FileCollector) loadCheckpoint() error {
+	s.checkpointed = make(map[string]fileCheckpoint)
+	b, err := ioutil.ReadFile(s.checkpoint)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var cps []fileCheckpoint
+	if err := json.Unmarshal(b, &cps); err != nil {
+		return fmt.Errorf("invalid checkpoint %s: %s", s.checkpoint, err.Error())
+	}
+	for _, cp := range cps {
+		s.checkpointed[cp.Path] = cp
+	}
+	return nil
+}
+
+// saveCheckpoint writes the acknowledged offsets of all files, replacing the
+// previous checkpoint. Offsets of files not yet followed are kept, until the
+// files are followed, or no longer exist.
+func (s *FileCollector) saveCheckpoint() error {
+	cps := make([]fileCheckpoint, 0, len(s.files)+len(s.checkpointed))
+	for _, t := range s.files {
+		offset := t.ackedOffset()
+		fp := t.print
+		if int64(len(fp)) > offset {
+			fp = fp[:offset]
+		}
+		cps = append(cps, fileCheckpoint{
+			Path:        t.path,
+			Offset:      offset,
+			Fingerprint: fp,
+		})
+		t.saved = offset
+	}
+	for _, cp := range s.checkpointed {
+		cps = append(cps, cp)
+	}
+	b, err := json.Marshal(cps)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.checkpoint + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.checkpoint)
+}
+
+// fingerprint returns the bytes at the start of the file, up to the given offset,
+// which identify the file.
+func fingerprint(f *os.File, offset int64) []byte {
+	n := int64(fileFingerprintSize)
+	if offset < n {
+		n = offset
+	}
+	b := make([]byte, n)
+	n2, _ := f.ReadAt(b, 0)
+	return b[:n2]
+}
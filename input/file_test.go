@@ -0,0 +1,251 @@
+package input
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_FileCollector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ekanite_")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	checkpoint := filepath.Join(dir, "offsets.json")
+
+	// Lines are not parsed, but indexed.
+	config := ParserConfig{Unparsed: IndexUnparsed{}}
+	newCollector := func() (*FileCollector, *LogHandler) {
+		collector, err := NewFileCollector([]string{filepath.Join(dir, "*.log")}, "syslog", config, checkpoint)
+		if err != nil {
+			t.Fatalf("failed to create collector: %s", err.Error())
+		}
+		s := collector.(*FileCollector)
+		if err := s.loadCheckpoint(); err != nil {
+			t.Fatalf("failed to load checkpoint: %s", err.Error())
+		}
+		parser, err := NewParser("syslog", config)
+		if err != nil {
+			t.Fatalf("failed to create parser: %s", err.Error())
+		}
+		return s, parser
+	}
+	poll := func(s *FileCollector, parser *LogHandler, exp ...string) {
+		c := make(chan *Event, 10)
+		s.poll(parser, c)
+		close(c)
+		var got []string
+		for e := range c {
+			got = append(got, e.Text)
+			e.Ack()
+		}
+		if !reflect.DeepEqual(got, exp) {
+			t.Fatalf("wrong lines read, exp %v, got %v", exp, got)
+		}
+
+		// Checkpoint the acknowledged lines, as the next poll would.
+		if err := s.saveCheckpoint(); err != nil {
+			t.Fatalf("failed to checkpoint: %s", err.Error())
+		}
+	}
+	write := func(f *os.File, s string) {
+		if _, err := f.WriteString(s); err != nil {
+			t.Fatalf("failed to write to file: %s", err.Error())
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create file: %s", err.Error())
+	}
+	write(f, "line 1\nline 2\r\n\nline")
+	s, parser := newCollector()
+	poll(s, parser, "line 1", "line 2")
+	write(f, " 3\n")
+	poll(s, parser, "line 3")
+	poll(s, parser)
+
+	// Lines written before the file is rotated should be read to the end.
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatalf("failed to rotate file: %s", err.Error())
+	}
+	write(f, "line 4\nline 5")
+	f.Close()
+	f, err = os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create file: %s", err.Error())
+	}
+	write(f, "line 6\n")
+	poll(s, parser, "line 4", "line 5", "line 6")
+
+	// Truncated files should be read from the start.
+	if err := f.Truncate(0); err != nil {
+		t.Fatalf("failed to truncate file: %s", err.Error())
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatalf("failed to seek file: %s", err.Error())
+	}
+	poll(s, parser)
+	write(f, "line 7\n")
+	poll(s, parser, "line 7")
+
+	// Lines longer than the maximum should be discarded.
+	write(f, strings.Repeat("x", 2*maxFileLineSize)+"\nline 8\n")
+	poll(s, parser, "line 8")
+
+	// Following should resume from the checkpoint after a restart.
+	write(f, "line 9\n")
+	s, parser = newCollector()
+	poll(s, parser, "line 9")
+
+	// Lines whose events were not acknowledged should be read again after a restart.
+	write(f, "line 10\n")
+	c := make(chan *Event, 10)
+	s.poll(parser, c)
+	if e := <-c; e.Text != "line 10" {
+		t.Fatalf("wrong line read, exp line 10, got %s", e.Text)
+	}
+	s, parser = newCollector()
+	poll(s, parser, "line 10")
+
+	// Offsets of files deleted while not followed should not be kept.
+	other := filepath.Join(dir, "other.log")
+	if err := ioutil.WriteFile(other, []byte("other 1\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %s", err.Error())
+	}
+	poll(s, parser, "other 1")
+	poll(s, parser)
+	if err := os.Remove(other); err != nil {
+		t.Fatalf("failed to remove file: %s", err.Error())
+	}
+	s, parser = newCollector()
+	if _, ok := s.checkpointed[other]; !ok {
+		t.Fatalf("offset of %s not checkpointed", other)
+	}
+	poll(s, parser)
+	s, parser = newCollector()
+	if _, ok := s.checkpointed[other]; ok {
+		t.Fatalf("offset of deleted file %s still checkpointed", other)
+	}
+
+	// A file replaced while not followed should be read from the start.
+	f.Close()
+	if err := ioutil.WriteFile(path, []byte(strings.Repeat("new\n", 100000)+"line 11\n"), 0644); err != nil {
+		t.Fatalf("failed to replace file: %s", err.Error())
+	}
+	s, parser = newCollector()
+	c = make(chan *Event, 100001)
+	s.poll(parser, c)
+	if len(c) != 100001 {
+		t.Fatalf("wrong number of lines read from replaced file, got %d", len(c))
+	}
+}
+
+func Test_FileCollectorStart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ekanite_")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	checkpoint := filepath.Join(dir, "offsets.json")
+
+	line := "<13>1 2003-10-11T22:14:15.003Z - myapp 12 - - hello"
+	if err := ioutil.WriteFile(filepath.Join(dir, "app.log"), []byte(line+"\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %s", err.Error())
+	}
+
+	if _, err := NewFileCollector([]string{"[invalid"}, "syslog", ParserConfig{}, filepath.Join(dir, "offsets.json")); err == nil {
+		t.Fatalf("created collector with invalid pattern")
+	}
+	collector, err := NewFileCollector([]string{filepath.Join(dir, "*.log")}, "syslog", ParserConfig{}, checkpoint)
+	if err != nil {
+		t.Fatalf("failed to create collector: %s", err.Error())
+	}
+	c := make(chan *Event, 1)
+	if err := collector.Start(c); err != nil {
+		t.Fatalf("failed to start collector: %s", err.Error())
+	}
+
+	var e *Event
+	select {
+	case e = <-c:
+		if e.Text != line || e.Parsed["app"] != "myapp" {
+			t.Fatalf("wrong event received, got %v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for event")
+	}
+
+	// Stopping twice should not panic.
+	for i := 0; i < 2; i++ {
+		if err := collector.Stop(context.Background()); err != nil {
+			t.Fatalf("failed to stop collector: %s", err.Error())
+		}
+	}
+
+	// Events acknowledged once the collector is stopped should be checkpointed.
+	e.Ack()
+	b, err := ioutil.ReadFile(checkpoint)
+	if err != nil {
+		t.Fatalf("failed to read checkpoint: %s", err.Error())
+	}
+	var cps []fileCheckpoint
+	if err := json.Unmarshal(b, &cps); err != nil {
+		t.Fatalf("failed to decode checkpoint: %s", err.Error())
+	}
+	if len(cps) != 1 || cps[0].Offset != int64(len(line)+1) {
+		t.Fatalf("wrong checkpoint after acknowledgement, got %+v", cps)
+	}
+}
+
+// Test_FileCollectorStopPendingAcks tests that events acknowledged after the
+// final poll, but before the collector is stopped, are checkpointed.
+func Test_FileCollectorStopPendingAcks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ekanite_")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	checkpoint := filepath.Join(dir, "offsets.json")
+	line := "<13>1 2003-10-11T22:14:15.003Z - myapp 12 - - hello"
+	if err := ioutil.WriteFile(filepath.Join(dir, "app.log"), []byte(line+"\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %s", err.Error())
+	}
+
+	collector, err := NewFileCollector([]string{filepath.Join(dir, "*.log")}, "syslog", ParserConfig{}, checkpoint)
+	if err != nil {
+		t.Fatalf("failed to create collector: %s", err.Error())
+	}
+	s := collector.(*FileCollector)
+	if err := s.loadCheckpoint(); err != nil {
+		t.Fatalf("failed to load checkpoint: %s", err.Error())
+	}
+	parser, err := NewParser("syslog", ParserConfig{})
+	if err != nil {
+		t.Fatalf("failed to create parser: %s", err.Error())
+	}
+	c := make(chan *Event, 1)
+	s.poll(parser, c)
+	(<-c).Ack()
+	s.finish()
+
+	b, err := ioutil.ReadFile(checkpoint)
+	if err != nil {
+		t.Fatalf("failed to read checkpoint: %s", err.Error())
+	}
+	var cps []fileCheckpoint
+	if err := json.Unmarshal(b, &cps); err != nil {
+		t.Fatalf("failed to decode checkpoint: %s", err.Error())
+	}
+	if len(cps) != 1 || cps[0].Offset != int64(len(line)+1) {
+		t.Fatalf("wrong checkpoint after stopping, got %+v", cps)
+	}
+}
@@ -0,0 +1,305 @@
+package input
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// maxGELFMessageSize is the largest GELF message accepted, once reassembled
+	// and decompressed.
+	maxGELFMessageSize = 1024 * 1024
+
+	// maxGELFChunks is the largest number of chunks a GELF message may be sent
+	// in, and gelfChunkTimeout the time within which all must be received.
+	maxGELFChunks    = 128
+	gelfChunkTimeout = 5 * time.Second
+
+	// maxGELFPending is the largest number of chunked messages which may be
+	// awaiting reassembly at once.
+	maxGELFPending = 1024
+
+	// gelfChunkHeaderSize is the size of the header of each chunk: the magic
+	// bytes, the message ID, the sequence number, and the sequence count.
+	gelfChunkHeaderSize = 12
+
+	// udpPacketSize is the size of the largest UDP packet.
+	udpPacketSize = 64 * 1024
+)
+
+var (
+	gelfChunkMagic = []byte{0x1e, 0x0f}
+	gzipMagic      = []byte{0x1f, 0x8b}
+)
+
+// GELFCollector represents a collector that accepts log messages in the Graylog
+// Extended Log Format, such as those sent by Docker's gelf logging driver.
+// Over UDP, messages may be chunked, and compressed with zlib or gzip. Over
+// TCP, messages are uncompressed, and each is terminated by a null byte.
+type GELFCollector struct {
+	proto  string
+	iface  string
+	config ParserConfig
+	addr   net.Addr
+
+	reader *datagramReader
+	ln     *listener
+}
+
+// NewGELFCollector returns a collector that will bind to the given interface on
+// Start(), parsing log messages as configured by config. The protocol is either
+// "udp" or "tcp".
+func NewGELFCollector(proto, iface string, config ParserConfig) (Collector, error) {
+	proto = strings.ToLower(proto)
+	if proto != "udp" && proto != "tcp" {
+		return nil, fmt.Errorf("unsupported GELF collector protocol")
+	}
+	return &GELFCollector{proto: proto, iface: iface, config: config}, nil
+}
+
+// Start instructs the GELFCollector to bind to the interface, and start receiving
+// log messages.
+func (s *GELFCollector) Start(c chan<- *Event) error {
+	if s.proto == "udp" {
+		addr, err := net.ResolveUDPAddr("udp", s.iface)
+		if err != nil {
+			return err
+		}
+		conn, err := net.ListenUDP("udp", addr)
+		if err != nil {
+			return err
+		}
+		s.addr = conn.LocalAddr()
+		s.reader = newDatagramReader(conn)
+		go s.readPackets(conn, c)
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", s.iface)
+	if err != nil {
+		return err
+	}
+	s.addr = ln.Addr()
+	s.ln = newListener(ln)
+	go s.ln.serve(func(conn net.Conn) {
+		s.handleConnection(conn, c)
+	})
+	return nil
+}
+
+// Stop instructs the GELFCollector to stop receiving log messages, once those
+// already sent to it are received.
+func (s *GELFCollector) Stop(ctx context.Context) error {
+	if s.reader != nil {
+		return s.reader.close(ctx)
+	}
+	if s.ln != nil {
+		return s.ln.close(ctx)
+	}
+	return nil
+}
+
+// Addr returns the net.Addr that the Collector is bound to.
+func (s *GELFCollector) Addr() net.Addr {
+	return s.addr
+}
+
+func (s *GELFCollector) readPackets(conn *net.UDPConn, c chan<- *Event) {
+	parser, err := NewParser(GELFName, s.config)
+	if err != nil {
+		panic(fmt.Sprintf("failed to create GELF parser:%s", err.Error()))
+	}
+
+	defer close(s.reader.done)
+	chunks := newGELFChunks()
+	buf := make([]byte, udpPacketSize)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		stats.Add("gelfUDPBytesRead", int64(n))
+		if err != nil {
+			if s.reader.stopped() {
+				return
+			}
+			continue
+		}
+
+		packet := buf[:n]
+		if bytes.HasPrefix(packet, gelfChunkMagic) {
+			if packet = chunks.add(packet, time.Now()); packet == nil {
+				continue
+			}
+		}
+		msg, err := decompressGELF(packet)
+		if err != nil {
+			stats.Add("gelfUDPDecompressError", 1)
+			continue
+		}
+		if e := ParseEvent(parser, string(msg), addr.String()); e != nil {
+			c <- e
+		}
+		stats.Add("gelfUDPEventsRx", 1)
+	}
+}
+
+func (s *GELFCollector) handleConnection(conn net.Conn, c chan<- *Event) {
+	stats.Add("gelfTCPConnections", 1)
+	defer func() {
+		stats.Add("gelfTCPConnections", -1)
+		conn.Close()
+	}()
+
+	parser, err := NewParser(GELFName, s.config)
+	if err != nil {
+		panic(fmt.Sprintf("failed to create GELF connection parser:%s", err.Error()))
+	}
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), maxGELFMessageSize)
+	scanner.Split(scanNullTerminated)
+	for scanner.Scan() {
+		msg := strings.TrimSpace(scanner.Text())
+		if msg == "" {
+			continue
+		}
+		stats.Add("gelfTCPBytesRead", int64(len(msg)))
+		if e := ParseEvent(parser, msg, conn.RemoteAddr().String()); e != nil {
+			c <- e
+		}
+		stats.Add("gelfTCPEventsRx", 1)
+	}
+	if err := scanner.Err(); err != nil {
+		stats.Add("gelfTCPConnReadError", 1)
+	}
+}
+
+// scanNullTerminated is a bufio.SplitFunc returning each null-terminated message.
+func scanNullTerminated(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// decompressGELF returns the given GELF message, decompressed if it was
+// compressed with zlib or gzip.
+func decompressGELF(b []byte) ([]byte, error) {
+	var r io.ReadCloser
+	var err error
+	switch {
+	case bytes.HasPrefix(b, gzipMagic):
+		r, err = gzip.NewReader(bytes.NewReader(b))
+	case len(b) > 1 && b[0] == 0x78:
+		r, err = zlib.NewReader(bytes.NewReader(b))
+	default:
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	msg, err := ioutil.ReadAll(io.LimitReader(r, maxGELFMessageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(msg) > maxGELFMessageSize {
+		return nil, fmt.Errorf("GELF message exceeds %d bytes", maxGELFMessageSize)
+	}
+	return msg, nil
+}
+
+// gelfChunks reassembles chunked GELF messages. Messages which are not
+// completely received within gelfChunkTimeout are discarded.
+type gelfChunks struct {
+	pending   map[string]*gelfChunked
+	lastSweep time.Time
+}
+
+// gelfChunked is a GELF message awaiting the receipt of all of its chunks.
+type gelfChunked struct {
+	chunks   [][]byte
+	received int
+	size     int
+	first    time.Time
+}
+
+func newGELFChunks() *gelfChunks {
+	return &gelfChunks{pending: make(map[string]*gelfChunked)}
+}
+
+// add adds the given chunk, received at the given time. If it is the last
+// chunk of its message to be received, the reassembled message is returned.
+func (g *gelfChunks) add(chunk []byte, now time.Time) []byte {
+	if now.Sub(g.lastSweep) >= gelfChunkTimeout {
+		g.sweep(now)
+	}
+
+	if len(chunk) < gelfChunkHeaderSize {
+		stats.Add("gelfChunkInvalid", 1)
+		return nil
+	}
+	id := string(chunk[2:10])
+	seq, count := int(chunk[10]), int(chunk[11])
+	if count == 0 || count > maxGELFChunks || seq >= count {
+		stats.Add("gelfChunkInvalid", 1)
+		return nil
+	}
+
+	m, ok := g.pending[id]
+	if !ok {
+		if len(g.pending) >= maxGELFPending {
+			stats.Add("gelfChunkDropped", 1)
+			return nil
+		}
+		m = &gelfChunked{chunks: make([][]byte, count), first: now}
+		g.pending[id] = m
+	}
+	if len(m.chunks) != count {
+		stats.Add("gelfChunkInvalid", 1)
+		return nil
+	}
+	if m.chunks[seq] != nil {
+		return nil
+	}
+
+	data := chunk[gelfChunkHeaderSize:]
+	if m.size+len(data) > maxGELFMessageSize {
+		stats.Add("gelfChunkDropped", 1)
+		delete(g.pending, id)
+		return nil
+	}
+	m.chunks[seq] = make([]byte, len(data))
+	copy(m.chunks[seq], data)
+	m.received++
+	m.size += len(data)
+	if m.received < count {
+		return nil
+	}
+
+	delete(g.pending, id)
+	stats.Add("gelfChunkedRx", 1)
+	return bytes.Join(m.chunks, nil)
+}
+
+// sweep discards messages which have not been completely received in time.
+func (g *gelfChunks) sweep(now time.Time) {
+	for id, m := range g.pending {
+		if now.Sub(m.first) >= gelfChunkTimeout {
+			delete(g.pending, id)
+			stats.Add("gelfChunkExpired", 1)
+		}
+	}
+	g.lastSweep = now
+}
@@ -0,0 +1,152 @@
+package input
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_GELFCollectorUDP(t *testing.T) {
+	collector, err := NewGELFCollector("udp", "127.0.0.1:0", ParserConfig{})
+	if err != nil {
+		t.Fatalf("failed to create collector: %s", err.Error())
+	}
+	c := make(chan *Event, 1)
+	if err := collector.Start(c); err != nil {
+		t.Fatalf("failed to start collector: %s", err.Error())
+	}
+	conn, err := net.Dial("udp", collector.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect to collector: %s", err.Error())
+	}
+	defer conn.Close()
+
+	msg := `{"version":"1.1","host":"docker01","short_message":"disk full","level":3,"timestamp":1454407200.5,"_container_name":"billing"}`
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(msg))
+	w.Close()
+
+	var zl bytes.Buffer
+	zw := zlib.NewWriter(&zl)
+	zw.Write([]byte(msg))
+	zw.Close()
+
+	// Send the gzip-compressed message in three chunks, out of order.
+	id := []byte("abcdefgh")
+	b := gz.Bytes()
+	third := len(b) / 3
+	parts := [][]byte{b[:third], b[third : 2*third], b[2*third:]}
+	var chunks [][]byte
+	for _, seq := range []int{2, 0, 1} {
+		chunk := append(append(append([]byte{}, gelfChunkMagic...), id...), byte(seq), byte(len(parts)))
+		chunks = append(chunks, append(chunk, parts[seq]...))
+	}
+
+	tests := []struct {
+		name    string
+		packets [][]byte
+	}{
+		{name: "uncompressed", packets: [][]byte{[]byte(msg)}},
+		{name: "gzip", packets: [][]byte{gz.Bytes()}},
+		{name: "zlib", packets: [][]byte{zl.Bytes()}},
+		{name: "chunked", packets: chunks},
+	}
+	for _, tt := range tests {
+		for _, p := range tt.packets {
+			if _, err := conn.Write(p); err != nil {
+				t.Fatalf("failed to write %s message: %s", tt.name, err.Error())
+			}
+		}
+
+		select {
+		case e := <-c:
+			if e.Text != msg {
+				t.Fatalf("wrong text for %s message, got %s", tt.name, e.Text)
+			}
+			if e.Parsed["host"] != "docker01" || e.Parsed["container_name"] != "billing" {
+				t.Fatalf("wrong fields for %s message, got %v", tt.name, e.Parsed)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s message", tt.name)
+		}
+	}
+}
+
+func Test_GELFCollectorTCP(t *testing.T) {
+	collector, err := NewGELFCollector("tcp", "127.0.0.1:0", ParserConfig{})
+	if err != nil {
+		t.Fatalf("failed to create collector: %s", err.Error())
+	}
+	c := make(chan *Event, 2)
+	if err := collector.Start(c); err != nil {
+		t.Fatalf("failed to start collector: %s", err.Error())
+	}
+	conn, err := net.Dial("tcp", collector.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect to collector: %s", err.Error())
+	}
+	defer conn.Close()
+
+	msg1 := `{"version":"1.1","host":"docker01","short_message":"first"}`
+	msg2 := `{"version":"1.1","host":"docker01","short_message":"second"}`
+	if _, err := conn.Write([]byte(msg1 + "\x00" + msg2 + "\x00")); err != nil {
+		t.Fatalf("failed to write messages: %s", err.Error())
+	}
+
+	for _, exp := range []string{"first", "second"} {
+		select {
+		case e := <-c:
+			if e.Parsed["message"] != exp {
+				t.Fatalf("wrong message, exp %s, got %v", exp, e.Parsed["message"])
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for message")
+		}
+	}
+}
+
+func Test_GELFChunks(t *testing.T) {
+	chunk := func(id string, seq, count int, data string) []byte {
+		b := append(append([]byte{}, gelfChunkMagic...), id...)
+		return append(append(b, byte(seq), byte(count)), data...)
+	}
+
+	now := time.Now()
+	g := newGELFChunks()
+	if m := g.add(chunk("message1", 0, 2, "hello "), now); m != nil {
+		t.Fatalf("message returned before all chunks received")
+	}
+	if m := g.add(chunk("message1", 0, 2, "hello "), now); m != nil {
+		t.Fatalf("message returned for duplicate chunk")
+	}
+	if m := g.add(chunk("message1", 1, 2, "world"), now); string(m) != "hello world" {
+		t.Fatalf("wrong reassembled message, got %s", m)
+	}
+	if len(g.pending) != 0 {
+		t.Fatalf("reassembled message still pending")
+	}
+
+	if m := g.add(chunk("message2", 0, 2, "hello "), now); m != nil {
+		t.Fatalf("message returned before all chunks received")
+	}
+	later := now.Add(gelfChunkTimeout)
+	if m := g.add(chunk("message2", 1, 2, "world"), later); m != nil {
+		t.Fatalf("message returned after chunks expired, got %s", m)
+	}
+
+	for _, c := range [][]byte{
+		chunk("message3", 0, 0, "hello"),
+		chunk("message3", 2, 2, "hello"),
+		chunk("message3", 0, maxGELFChunks+1, "hello"),
+		gelfChunkMagic,
+	} {
+		if m := g.add(c, later); m != nil {
+			t.Fatalf("message returned for invalid chunk")
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package input
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	// HTTPIngestPath is the path to which log lines are POSTed.
+	HTTPIngestPath = "/ingest"
+)
+
+// HTTPCollector represents a collector that accepts log lines POSTed over HTTP.
+// The body of each request is either newline-delimited log lines, parsed in
+// the collector's format, or, if the Content-Type is application/x-ndjson,
+// newline-delimited JSON objects. The body may be gzip-compressed, as
+// indicated by a Content-Encoding of gzip. The response reports how many lines
+// were accepted for indexing, and how many were rejected, including any lines
+// longer than the maximum message size.
+type HTTPCollector struct {
+	iface     string
+	format    string
+	config    ParserConfig
+	maxSize   int
+	tlsConfig *tls.Config
+
+	addr    net.Addr
+	server  *http.Server
+	c       chan<- *Event
+	parsers sync.Pool
+	json    sync.Pool
+}
+
+// httpIngestResponse is the response to a POST of log lines.
+type httpIngestResponse struct {
+	Accepted int    `json:"accepted"`
+	Rejected int    `json:"rejected"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Start instructs the HTTPCollector to bind to the interface and accept requests.
+func (s *HTTPCollector) Start(c chan<- *Event) error {
+	var ln net.Listener
+	var err error
+	if s.tlsConfig == nil {
+		ln, err = net.Listen("tcp", s.iface)
+	} else {
+		ln, err = tls.Listen("tcp", s.iface, s.tlsConfig)
+	}
+	if err != nil {
+		return err
+	}
+	s.addr = ln.Addr()
+	s.c = c
+
+	// Parsers are not safe for concurrent use, so each request uses its own.
+	s.parsers.New = func() interface{} {
+		p, err := NewParser(s.format, s.config)
+		if err != nil {
+			panic("failed to create HTTP parser:" + err.Error())
+		}
+		return p
+	}
+	s.json.New = func() interface{} {
+		p, err := NewParser(JSONName, s.config)
+		if err != nil {
+			panic("failed to create HTTP JSON parser:" + err.Error())
+		}
+		return p
+	}
+
+	s.server = &http.Server{Handler: s}
+	go s.server.Serve(ln)
+	return nil
+}
+
+// Stop instructs the HTTPCollector to stop accepting requests, once requests
+// already being received are complete.
+func (s *HTTPCollector) Stop(ctx context.Context) error {
+	if s.server == nil {
+		return nil
+	}
+	return s.server.Shutdown(ctx)
+}
+
+// Addr returns the net.Addr that the Collector is bound to.
+func (s *HTTPCollector) Addr() net.Addr {
+	return s.addr
+}
+
+// ServeHTTP implements a http.Handler, accepting POSTed log lines.
+func (s *HTTPCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	stats.Add("httpRequests", 1)
+	if r.URL.Path != HTTPIngestPath {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body := io.Reader(r.Body)
+	if strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			writeIngestResponse(w, http.StatusBadRequest, &httpIngestResponse{Error: "invalid gzip body: " + err.Error()})
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	pool := &s.parsers
+	if ct, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil &&
+		(ct == "application/x-ndjson" || ct == "application/ndjson") {
+		pool = &s.json
+	}
+	p := pool.Get().(*LogHandler)
+	defer pool.Put(p)
+
+	resp := &httpIngestResponse{}
+	err := readLines(body, s.maxSize, func(line string, ok bool) {
+		line = strings.TrimRight(line, "\r")
+		if ok && strings.TrimSpace(line) == "" {
+			return
+		}
+
+		if ok {
+			if e := ParseEvent(p, line, r.RemoteAddr); e != nil {
+				s.c <- e
+				stats.Add("httpEventsRx", 1)
+				resp.Accepted++
+				return
+			}
+		}
+		stats.Add("httpEventsRejected", 1)
+		resp.Rejected++
+	})
+	if err != nil {
+		resp.Error = "failed to read body: " + err.Error()
+		writeIngestResponse(w, http.StatusBadRequest, resp)
+		return
+	}
+	writeIngestResponse(w, http.StatusOK, resp)
+}
+
+// readLines reads newline-delimited lines from r, calling fn with each line.
+// Lines longer than max bytes are discarded, and fn called with ok false.
+func readLines(r io.Reader, max int, fn func(line string, ok bool)) error {
+	// The buffer also holds the newline ending a line of max bytes.
+	reader := bufio.NewReaderSize(r, max+1)
+	tooLong := false
+	for {
+		b, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			tooLong = true
+			continue
+		}
+		line := strings.TrimSuffix(string(b), "\n")
+		if tooLong || len(line) > max {
+			fn("", false)
+		} else if len(b) > 0 {
+			fn(line, true)
+		}
+		tooLong = false
+
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// writeIngestResponse writes the given response as JSON.
+func writeIngestResponse(w http.ResponseWriter, code int, resp *httpIngestResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,156 @@
+package input
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_HTTPCollector(t *testing.T) {
+	collector, err := NewCollector("http", "127.0.0.1:0", "syslog", ParserConfig{}, 0, nil)
+	if err != nil {
+		t.Fatalf("failed to create HTTP collector: %s", err.Error())
+	}
+	c := make(chan *Event, 100)
+	if err := collector.Start(c); err != nil {
+		t.Fatalf("failed to start HTTP collector: %s", err.Error())
+	}
+	url := "http://" + collector.Addr().String() + HTTPIngestPath
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte("<33>5 1985-04-12T23:20:50.52Z test.com cron 304 - password accepted\n"))
+	w.Close()
+
+	maxLine := "<33>5 1985-04-12T23:20:50.52Z test.com cron 304 - "
+	maxLine += strings.Repeat("x", DefaultMaxMessageSize-len(maxLine))
+
+	tests := []struct {
+		name        string
+		body        []byte
+		contentType string
+		encoding    string
+		code        int
+		accepted    int
+		rejected    int
+		fields      []map[string]interface{}
+	}{
+		{
+			name:     "raw",
+			body:     []byte("<33>5 1985-04-12T23:20:50.52Z test.com cron 304 - password accepted\r\n\nnot syslog\n<33>5 1985-04-12T23:20:51.52Z test.com sshd 22 - password rejected"),
+			code:     http.StatusOK,
+			accepted: 2,
+			rejected: 1,
+			fields:   []map[string]interface{}{{"app": "cron"}, {"app": "sshd"}},
+		},
+		{
+			name:        "ndjson",
+			body:        []byte(`{"timestamp":"1985-04-12T23:20:50.52Z","level":"error","user":{"id":1234}}` + "\n" + `{"level":` + "\n"),
+			contentType: "application/x-ndjson",
+			code:        http.StatusOK,
+			accepted:    1,
+			rejected:    1,
+			fields:      []map[string]interface{}{{"level": "error", "user.id": int64(1234)}},
+		},
+		{
+			name:     "gzip",
+			body:     gz.Bytes(),
+			encoding: "gzip",
+			code:     http.StatusOK,
+			accepted: 1,
+			fields:   []map[string]interface{}{{"app": "cron"}},
+		},
+		{
+			name:     "invalid gzip",
+			body:     []byte("not gzip"),
+			encoding: "gzip",
+			code:     http.StatusBadRequest,
+		},
+		{
+			name:     "line of maximum size",
+			body:     []byte(maxLine + "\n"),
+			code:     http.StatusOK,
+			accepted: 1,
+			fields:   []map[string]interface{}{{"app": "cron"}},
+		},
+		{
+			name:     "line too long",
+			body:     []byte(strings.Repeat("x", DefaultMaxMessageSize+1) + "\n<33>5 1985-04-12T23:20:50.52Z test.com cron 304 - password accepted"),
+			code:     http.StatusOK,
+			accepted: 1,
+			rejected: 1,
+			fields:   []map[string]interface{}{{"app": "cron"}},
+		},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest("POST", url, bytes.NewReader(tt.body))
+		if err != nil {
+			t.Fatalf("test %s: failed to create request: %s", tt.name, err.Error())
+		}
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		if tt.encoding != "" {
+			req.Header.Set("Content-Encoding", tt.encoding)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("test %s: failed to POST: %s", tt.name, err.Error())
+		}
+		var r httpIngestResponse
+		err = json.NewDecoder(resp.Body).Decode(&r)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("test %s: failed to decode response: %s", tt.name, err.Error())
+		}
+
+		if resp.StatusCode != tt.code {
+			t.Fatalf("test %s: wrong status code, exp %d, got %d", tt.name, tt.code, resp.StatusCode)
+		}
+		if r.Accepted != tt.accepted || r.Rejected != tt.rejected {
+			t.Fatalf("test %s: wrong counts, exp %d accepted %d rejected, got %d accepted %d rejected",
+				tt.name, tt.accepted, tt.rejected, r.Accepted, r.Rejected)
+		}
+		for _, fields := range tt.fields {
+			e := <-c
+			for k, v := range fields {
+				if e.Parsed[k] != v {
+					t.Fatalf("test %s: wrong value for field %s, exp %v, got %v", tt.name, k, v, e.Parsed[k])
+				}
+			}
+		}
+	}
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("failed to GET: %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("wrong status code for GET, exp %d, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+}
+
+func Test_ReadLines(t *testing.T) {
+	var lines []string
+	fn := func(line string, ok bool) {
+		if !ok {
+			line = "!"
+		}
+		lines = append(lines, line)
+	}
+	max := 20
+	body := strings.Repeat("a", max) + "\n" + strings.Repeat("b", max+1) + "\n\n" + strings.Repeat("c", max)
+	if err := readLines(strings.NewReader(body), max, fn); err != nil {
+		t.Fatalf("failed to read lines: %s", err.Error())
+	}
+	exp := []string{strings.Repeat("a", max), "!", "", strings.Repeat("c", max)}
+	if !reflect.DeepEqual(lines, exp) {
+		t.Fatalf("wrong lines read, exp %v, got %v", exp, lines)
+	}
+}
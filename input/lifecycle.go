@@ -0,0 +1,153 @@
+package input
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// drainTimeout is how long collectors continue to read, once stopped, so that
+// log lines which were already sent are received.
+const drainTimeout = 500 * time.Millisecond
+
+// listener accepts the connections of a connection-oriented collector, tracking
+// them so that they can be drained when the collector is stopped.
+type listener struct {
+	ln net.Listener
+
+	mu      sync.Mutex
+	conns   map[net.Conn]struct{}
+	stopped bool
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// newListener returns a listener accepting connections from ln.
+func newListener(ln net.Listener) *listener {
+	return &listener{
+		ln:    ln,
+		conns: make(map[net.Conn]struct{}),
+		stop:  make(chan struct{}),
+	}
+}
+
+// serve accepts connections, handling each with the given function in its own
+// goroutine, until the listener is closed.
+func (l *listener) serve(handle func(net.Conn)) {
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			select {
+			case <-l.stop:
+				return
+			default:
+				continue
+			}
+		}
+
+		l.mu.Lock()
+		if l.stopped {
+			l.mu.Unlock()
+			conn.Close()
+			return
+		}
+		l.conns[conn] = struct{}{}
+		l.wg.Add(1)
+		l.mu.Unlock()
+
+		go func() {
+			defer func() {
+				l.mu.Lock()
+				delete(l.conns, conn)
+				l.mu.Unlock()
+				l.wg.Done()
+			}()
+			handle(conn)
+		}()
+	}
+}
+
+// close stops accepting connections, and waits for the handlers of all
+// connections to return, once they have read for up to drainTimeout. If ctx
+// is done first, the connections are closed, and the error of ctx returned.
+func (l *listener) close(ctx context.Context) error {
+	l.mu.Lock()
+	l.stopped = true
+	close(l.stop)
+	l.ln.Close()
+	for conn := range l.conns {
+		conn.SetReadDeadline(time.Now().Add(drainTimeout))
+	}
+	l.mu.Unlock()
+
+	err := wait(ctx, &l.wg)
+	if err != nil {
+		l.mu.Lock()
+		for conn := range l.conns {
+			conn.Close()
+		}
+		l.mu.Unlock()
+	}
+	return err
+}
+
+// datagramReader coordinates stopping a goroutine reading datagrams from a
+// connectionless socket.
+type datagramReader struct {
+	conn net.PacketConn
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newDatagramReader returns a datagramReader for the given socket. The reading
+// goroutine must close done when it returns.
+func newDatagramReader(conn net.PacketConn) *datagramReader {
+	return &datagramReader{
+		conn: conn,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// stopped returns whether the reader was closed, in which case the reading
+// goroutine should return once reading fails.
+func (r *datagramReader) stopped() bool {
+	select {
+	case <-r.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// close waits for the reading goroutine to return, once it has read for up to
+// drainTimeout, and closes the socket. If ctx is done first, the error of ctx
+// is returned.
+func (r *datagramReader) close(ctx context.Context) error {
+	close(r.stop)
+	r.conn.SetReadDeadline(time.Now().Add(drainTimeout))
+	var err error
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	r.conn.Close()
+	return err
+}
+
+// wait waits for wg, returning the error of ctx if it is done first.
+func wait(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
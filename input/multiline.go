@@ -0,0 +1,196 @@
+package input
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+const (
+	// DefaultMultilineWindow is the time within which continuation lines must
+	// follow the previous line of an event, unless otherwise configured.
+	DefaultMultilineWindow = time.Second
+
+	// maxMultilineLines is the largest number of lines joined into a single
+	// event. Further continuation lines begin a new event.
+	maxMultilineLines = 1000
+
+	// multilineBufSize is the number of events which may be waiting to be joined.
+	multilineBufSize = 1000
+)
+
+// Multiline joins continuation lines, such as the lines of a Java stack trace,
+// to the log message they continue, so that both are indexed as a single event.
+// A log message is a continuation line if its message matches a pattern, and it
+// was sent by the same host, app, and process as the previous log message within
+// a time window. Events are sent on once no further continuation lines can follow.
+type Multiline struct {
+	pattern *regexp.Regexp
+	window  time.Duration
+
+	c       chan *Event
+	pending map[multilineKey]*multilineEvent
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// multilineKey identifies the sender of a log message.
+type multilineKey struct {
+	sourceIP string
+	host     interface{}
+	app      interface{}
+	pid      interface{}
+}
+
+// multilineEvent is an event to which continuation lines may still be joined.
+type multilineEvent struct {
+	e     *Event
+	lines int
+	last  time.Time
+}
+
+// NewMultiline returns a Multiline joining log messages whose messages match
+// the given regular expression to the preceding log message, if they follow it
+// within the given window.
+func NewMultiline(pattern string, window time.Duration) (*Multiline, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid multiline pattern: %s", err.Error())
+	}
+	if window <= 0 {
+		return nil, fmt.Errorf("multiline window must be positive")
+	}
+	return &Multiline{
+		pattern: re,
+		window:  window,
+		c:       make(chan *Event, multilineBufSize),
+		pending: make(map[multilineKey]*multilineEvent),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// Start starts joining events sent to the Multiline, sending the resulting events
+// to the given channel.
+func (m *Multiline) Start(c chan<- *Event) error {
+	go func() {
+		ticker := time.NewTicker(m.window / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case e := <-m.c:
+				m.add(e, time.Now(), c)
+			case now := <-ticker.C:
+				m.flush(now, c)
+			case <-m.stop:
+				m.drain(c)
+				close(m.done)
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop stops the Multiline, once all events already sent to it are joined, and
+// sent on along with all pending events. If the context is done first, its
+// error is returned.
+func (m *Multiline) Stop(ctx context.Context) error {
+	close(m.stop)
+	select {
+	case <-m.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// C returns the channel to which events should be sent.
+func (m *Multiline) C() chan<- *Event {
+	return m.c
+}
+
+// add joins the given event, received at the given time, to the pending event
+// from the same sender if it is a continuation line. Otherwise the pending event
+// is sent, and the given event becomes pending.
+func (m *Multiline) add(e *Event, now time.Time, c chan<- *Event) {
+	key := multilineKey{sourceIP: e.SourceIP}
+	if e.Parsed != nil {
+		key.host, key.app, key.pid = e.Parsed["host"], e.Parsed["app"], e.Parsed["pid"]
+		if procID, ok := e.Parsed["procid"]; ok {
+			key.pid = procID
+		}
+	}
+
+	msg := multilineMessage(e)
+	p, ok := m.pending[key]
+	if ok && now.Sub(p.last) < m.window && p.lines < maxMultilineLines && m.pattern.MatchString(msg) {
+		p.e.Text += "\n" + msg
+		if pm, ok := p.e.Parsed["message"].(string); ok {
+			p.e.Parsed["message"] = pm + "\n" + msg
+		}
+		if e.Ack != nil {
+			p.e.Ack = joinAcks(p.e.Ack, e.Ack)
+		}
+		p.lines++
+		p.last = now
+		stats.Add("multilineJoined", 1)
+		return
+	}
+
+	if ok {
+		c <- p.e
+	}
+	m.pending[key] = &multilineEvent{e: e, lines: 1, last: now}
+}
+
+// flush sends all pending events to which no further continuation lines can be
+// joined, as of the given time.
+func (m *Multiline) flush(now time.Time, c chan<- *Event) {
+	for key, p := range m.pending {
+		if now.Sub(p.last) >= m.window {
+			delete(m.pending, key)
+			c <- p.e
+		}
+	}
+}
+
+// drain joins all events waiting to be joined, then sends all pending events,
+// as no further continuation lines will follow.
+func (m *Multiline) drain(c chan<- *Event) {
+	for {
+		select {
+		case e := <-m.c:
+			m.add(e, time.Now(), c)
+		default:
+			for key, p := range m.pending {
+				delete(m.pending, key)
+				c <- p.e
+			}
+			return
+		}
+	}
+}
+
+// multilineMessage returns the message of the event, without any header, such
+// as that of a syslog message.
+func multilineMessage(e *Event) string {
+	if msg, ok := e.Parsed["message"].(string); ok {
+		return msg
+	}
+	return e.Text
+}
+
+// joinAcks returns a function calling both of the given acknowledgement
+// functions, either of which may be nil.
+func joinAcks(a, b func()) func() {
+	if a == nil {
+		return b
+	}
+	return func() {
+		a()
+		b()
+	}
+}
@@ -0,0 +1,98 @@
+package input
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_Multiline(t *testing.T) {
+	p, err := NewParser("syslog", ParserConfig{})
+	if err != nil {
+		t.Fatalf("failed to create parser: %s", err.Error())
+	}
+	m, err := NewMultiline(`^\s+at |^Caused by:`, time.Second)
+	if err != nil {
+		t.Fatalf("failed to create multiline: %s", err.Error())
+	}
+
+	event := func(host, pid, msg string) *Event {
+		e := ParseEvent(p, "<11>1 2016-02-28T09:57:10.804642398-05:00 "+host+" java "+pid+" - "+msg, "10.0.0.1")
+		if e == nil {
+			t.Fatalf("failed to parse event")
+		}
+		return e
+	}
+
+	c := make(chan *Event, 10)
+	now := time.Now()
+	m.add(event("app01", "1", "java.lang.NullPointerException"), now, c)
+	m.add(event("app01", "1", "\tat com.foo.Bar.baz(Bar.java:10)"), now, c)
+	m.add(event("app02", "1", "\tat com.foo.Bar.qux(Bar.java:20)"), now, c)
+	m.add(event("app01", "1", "Caused by: java.io.IOException"), now.Add(500*time.Millisecond), c)
+	m.add(event("app01", "1", "\tat com.foo.Bar.quux(Bar.java:30)"), now.Add(2*time.Second), c)
+	m.add(event("app01", "2", "\tat com.foo.Bar.corge(Bar.java:40)"), now.Add(2*time.Second), c)
+	m.add(event("app01", "1", "request complete"), now.Add(2*time.Second), c)
+
+	// The trace should be joined, and sent once a non-continuation line follows it.
+	if len(c) != 2 {
+		t.Fatalf("wrong number of events sent, exp 2, got %d", len(c))
+	}
+	e := <-c
+	exp := "java.lang.NullPointerException\n\tat com.foo.Bar.baz(Bar.java:10)\nCaused by: java.io.IOException"
+	if e.Parsed["message"] != exp {
+		t.Fatalf("wrong joined message, got %q", e.Parsed["message"])
+	}
+	if e.Text != "<11>1 2016-02-28T09:57:10.804642398-05:00 app01 java 1 - "+exp {
+		t.Fatalf("wrong joined text, got %q", e.Text)
+	}
+
+	// A continuation line after the window should not be joined.
+	if e = <-c; e.Parsed["message"] != "\tat com.foo.Bar.quux(Bar.java:30)" {
+		t.Fatalf("wrong event after window, got %q", e.Parsed["message"])
+	}
+
+	// Remaining events should be sent once the window has passed.
+	m.flush(now.Add(2*time.Second), c)
+	if len(c) != 1 {
+		t.Fatalf("wrong number of events flushed, exp 1, got %d", len(c))
+	}
+	if e = <-c; e.Parsed["host"] != "app02" {
+		t.Fatalf("wrong event flushed, got %v", e.Parsed)
+	}
+	m.flush(now.Add(3*time.Second), c)
+	if len(c) != 2 || len(m.pending) != 0 {
+		t.Fatalf("wrong number of events flushed, exp 2, got %d", len(c))
+	}
+
+	if _, err := NewMultiline(`(`, time.Second); err == nil {
+		t.Fatalf("created multiline with invalid pattern")
+	}
+}
+
+func Test_MultilineStop(t *testing.T) {
+	m, err := NewMultiline(`^\s+at `, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to create multiline: %s", err.Error())
+	}
+	c := make(chan *Event, 10)
+	if err := m.Start(c); err != nil {
+		t.Fatalf("failed to start multiline: %s", err.Error())
+	}
+
+	m.C() <- &Event{Text: "java.lang.NullPointerException"}
+	m.C() <- &Event{Text: "\tat com.foo.Bar.baz(Bar.java:10)"}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.Stop(ctx); err != nil {
+		t.Fatalf("failed to stop multiline: %s", err.Error())
+	}
+
+	// The pending event should be sent, although the window has not passed.
+	if len(c) != 1 {
+		t.Fatalf("wrong number of events sent, exp 1, got %d", len(c))
+	}
+	if e := <-c; e.Text != "java.lang.NullPointerException\n\tat com.foo.Bar.baz(Bar.java:10)" {
+		t.Fatalf("wrong event sent, got %q", e.Text)
+	}
+}
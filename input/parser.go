@@ -4,15 +4,80 @@ import (
 	"fmt"
 	"github.com/ekanite/ekanite/parser"
 	"log"
+	"time"
 )
 
 const (
 	RFC5424Standard   = "RFC5424"
 	RFC5424Name       = "syslog"
+	RFC3164Standard   = "RFC3164"
+	RFC3164Name       = "bsd"
 	WatchguardFirebox = "Watchguard"
 	WatchguardName    = "M200"
+	JSONStandard      = "JSON"
+	JSONName          = "json"
+	GELFStandard      = "GELF"
+	GELFName          = "gelf"
+	AutoFormat        = "auto"
 )
 
+// ParserConfig configures the parsers created by NewParser, and the handling of
+// the events they parse. The zero value is the default configuration.
+type ParserConfig struct {
+	// RFC3164 timestamps include neither the year nor the time zone, so these
+	// settings control how they are inferred. RFC3164Location is the time zone
+	// of RFC3164 timestamps, time.Local if nil. RFC3164Year, if non-zero, is the
+	// year of all RFC3164 timestamps. Otherwise the year is inferred such that
+	// timestamps are not in the future.
+	RFC3164Location *time.Location
+	RFC3164Year     int
+
+	// JSONTimestampKey is the key, after flattening, of the reference timestamp
+	// of JSON log messages, parser.DefaultJSONTimestampKey if empty.
+	JSONTimestampKey string
+
+	// RFC5424JSON is whether the MSG of RFC5424 messages is also parsed, if it
+	// is a JSON object.
+	RFC5424JSON bool
+
+	// Unparsed is the policy for log lines which cannot be parsed, DropUnparsed
+	// if nil.
+	Unparsed UnparsedPolicy
+
+	// MarkTruncated is whether events for log lines which were truncated, as
+	// they were longer than the collector's maximum message size, have the field
+	// TruncatedField set. Such log lines are counted regardless.
+	MarkTruncated bool
+}
+
+// rfc3164Parser returns an RFC3164 parser, as configured.
+func (c ParserConfig) rfc3164Parser() *parser.RFC3164 {
+	loc := c.RFC3164Location
+	if loc == nil {
+		loc = time.Local
+	}
+	return &parser.RFC3164{Location: loc, Year: c.RFC3164Year}
+}
+
+// jsonParser returns a JSON parser, as configured.
+func (c ParserConfig) jsonParser() *parser.JSON {
+	key := c.JSONTimestampKey
+	if key == "" {
+		key = parser.DefaultJSONTimestampKey
+	}
+	return &parser.JSON{TimestampKey: key}
+}
+
+// rfc5424Parser returns an RFC5424 parser, which also parses JSON messages if
+// so configured.
+func (c ParserConfig) rfc5424Parser() *parser.RFC5424 {
+	p := &parser.RFC5424{}
+	if c.RFC5424JSON {
+		p.JSON = c.jsonParser()
+	}
+	return p
+}
+
 type StatsCollector func(key string, delta int64)
 
 type LogParser interface {
@@ -27,11 +92,18 @@ type LogHandler struct {
 	Result map[string]interface{}
 	Parser LogParser
 	Stats  func(key string, delta int64)
+
+	Unparsed      UnparsedPolicy // Policy for log lines which cannot be parsed
+	MarkTruncated bool           // Whether events for truncated log lines are marked
 }
 
 func supportedFormats() [][]string {
 	return [][]string{{RFC5424Name, RFC5424Standard},
-		{WatchguardName, WatchguardFirebox}}
+		{RFC3164Name, RFC3164Standard},
+		{WatchguardName, WatchguardFirebox},
+		{JSONName, JSONStandard},
+		{GELFName, GELFStandard},
+		{AutoFormat, AutoFormat}}
 }
 
 // ValidFormat returns if the given format matches one of the possible formats.
@@ -45,20 +117,42 @@ func ValidFormat(f string) bool {
 	return false
 }
 
-// NewParser returns a new Parser instance.
-func NewParser(f string) (*LogHandler, error) {
+// NewParser returns a new Parser instance, configured by the given config.
+func NewParser(f string, config ParserConfig) (*LogHandler, error) {
 	if !ValidFormat(f) {
 		return nil, fmt.Errorf("%s is not a valid parser format", f)
 	}
 
-	var p = &LogHandler{}
+	var p = &LogHandler{
+		Unparsed:      config.Unparsed,
+		MarkTruncated: config.MarkTruncated,
+	}
+	if p.Unparsed == nil {
+		p.Unparsed = DropUnparsed{}
+	}
 
 	if f == RFC5424Name || f == RFC5424Standard {
-		p.Parser = &parser.RFC5424{}
+		p.Parser = config.rfc5424Parser()
 		p.Fmt = RFC5424Standard
+	} else if f == RFC3164Name || f == RFC3164Standard {
+		p.Parser = config.rfc3164Parser()
+		p.Fmt = RFC3164Standard
 	} else if f == WatchguardName || f == WatchguardFirebox {
 		p.Parser = &parser.Watchguard{}
 		p.Fmt = WatchguardFirebox
+	} else if f == JSONName || f == JSONStandard {
+		p.Parser = config.jsonParser()
+		p.Fmt = JSONStandard
+	} else if f == GELFName || f == GELFStandard {
+		p.Parser = &parser.GELF{}
+		p.Fmt = GELFStandard
+	} else if f == AutoFormat {
+		p.Parser = &parser.Auto{
+			RFC5424: config.rfc5424Parser(),
+			RFC3164: config.rfc3164Parser(),
+			JSON:    config.jsonParser(),
+		}
+		p.Fmt = AutoFormat
 	}
 
 	log.Printf("input format parser created for %s", f)
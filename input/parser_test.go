@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"reflect"
 	"testing"
+	"time"
+
+	"github.com/ekanite/ekanite/parser"
 )
 
 func Test_Formats(t *testing.T) {
@@ -16,18 +19,18 @@ func Test_Formats(t *testing.T) {
 	}
 
 	for _, f := range supportedFormats() {
-		p, _ = NewParser(f[0])
+		p, _ = NewParser(f[0], ParserConfig{})
 		if p.Fmt != f[1] {
 			mismatched(p.Fmt, f[0], f[1])
 		}
 	}
 	for _, f := range supportedFormats() {
-		p, _ = NewParser(f[1])
+		p, _ = NewParser(f[1], ParserConfig{})
 		if p.Fmt != f[1] {
 			mismatched(p.Fmt, f[1], "")
 		}
 	}
-	p, err := NewParser("unknown-format")
+	p, err := NewParser("unknown-format", ParserConfig{})
 	if err == nil {
 		t.Fatalf("parser successfully created with invalid format")
 	}
@@ -44,42 +47,42 @@ func Test_Parsing(t *testing.T) {
 			fmt:     "syslog",
 			message: `<134>1 2003-08-24T05:14:15.000003-07:00 ubuntu sshd 1999 - password accepted`,
 			expected: map[string]interface{}{
-				"priority":   134,
-				"version":    1,
-				"timestamp":  "2003-08-24T05:14:15.000003-07:00",
-				"host":       "ubuntu",
-				"app":        "sshd",
-				"pid":        1999,
-				"message_id": "-",
-				"message":    "password accepted",
+				"priority":  134,
+				"version":   1,
+				"timestamp": "2003-08-24T05:14:15.000003-07:00",
+				"host":      "ubuntu",
+				"app":       "sshd",
+				"pid":       1999,
+				"procid":    "1999",
+				"message":   "password accepted",
 			},
 		},
 		{
 			fmt:     "syslog",
 			message: `<33>5 1985-04-12T23:20:50.52Z test.com cron 304 - password accepted`,
 			expected: map[string]interface{}{
-				"priority":   33,
-				"version":    5,
-				"timestamp":  "1985-04-12T23:20:50.52Z",
-				"host":       "test.com",
-				"app":        "cron",
-				"pid":        304,
-				"message_id": "-",
-				"message":    "password accepted",
+				"priority":  33,
+				"version":   5,
+				"timestamp": "1985-04-12T23:20:50.52Z",
+				"host":      "test.com",
+				"app":       "cron",
+				"pid":       304,
+				"procid":    "304",
+				"message":   "password accepted",
 			},
 		},
 		{
 			fmt:     "syslog",
 			message: `<1>0 1985-04-12T19:20:50.52-04:00 test.com cron 65535 - password accepted`,
 			expected: map[string]interface{}{
-				"priority":   1,
-				"version":    0,
-				"timestamp":  "1985-04-12T19:20:50.52-04:00",
-				"host":       "test.com",
-				"app":        "cron",
-				"pid":        65535,
-				"message_id": "-",
-				"message":    "password accepted",
+				"priority":  1,
+				"version":   0,
+				"timestamp": "1985-04-12T19:20:50.52-04:00",
+				"host":      "test.com",
+				"app":       "cron",
+				"pid":       65535,
+				"procid":    "65535",
+				"message":   "password accepted",
 			},
 		},
 		{
@@ -92,6 +95,7 @@ func Test_Parsing(t *testing.T) {
 				"host":       "test.com",
 				"app":        "cron",
 				"pid":        65535,
+				"procid":     "65535",
 				"message_id": "msgid1234",
 				"message":    "password accepted",
 			},
@@ -100,98 +104,157 @@ func Test_Parsing(t *testing.T) {
 			fmt:     "syslog",
 			message: `<1>0 2003-08-24T05:14:15.000003-07:00 test.com cron 65535 - JVM NPE\nsome_file.java:48\n\tsome_other_file.java:902`,
 			expected: map[string]interface{}{
-				"priority":   1,
-				"version":    0,
-				"timestamp":  "2003-08-24T05:14:15.000003-07:00",
-				"host":       "test.com",
-				"app":        "cron",
-				"pid":        65535,
-				"message_id": "-",
-				"message":    `JVM NPE\nsome_file.java:48\n\tsome_other_file.java:902`,
+				"priority":  1,
+				"version":   0,
+				"timestamp": "2003-08-24T05:14:15.000003-07:00",
+				"host":      "test.com",
+				"app":       "cron",
+				"pid":       65535,
+				"procid":    "65535",
+				"message":   `JVM NPE\nsome_file.java:48\n\tsome_other_file.java:902`,
 			},
 		},
 		{
 			fmt:     "syslog",
 			message: `<27>1 2015-03-02T22:53:45-08:00 localhost.localdomain puppet-agent 5334 - mirrorurls.extend(list(self.metalink_data.urls()))`,
 			expected: map[string]interface{}{
-				"priority":   27,
-				"version":    1,
-				"timestamp":  "2015-03-02T22:53:45-08:00",
-				"host":       "localhost.localdomain",
-				"app":        "puppet-agent",
-				"pid":        5334,
-				"message_id": "-",
-				"message":    "mirrorurls.extend(list(self.metalink_data.urls()))",
+				"priority":  27,
+				"version":   1,
+				"timestamp": "2015-03-02T22:53:45-08:00",
+				"host":      "localhost.localdomain",
+				"app":       "puppet-agent",
+				"pid":       5334,
+				"procid":    "5334",
+				"message":   "mirrorurls.extend(list(self.metalink_data.urls()))",
 			},
 		},
 		{
 			fmt:     "syslog",
 			message: `<29>1 2015-03-03T06:49:08-08:00 localhost.localdomain puppet-agent 51564 - (/Stage[main]/Users_prd/Ssh_authorized_key[1063-username]) Dependency Group[group] has failures: true`,
 			expected: map[string]interface{}{
-				"priority":   29,
-				"version":    1,
-				"timestamp":  "2015-03-03T06:49:08-08:00",
-				"host":       "localhost.localdomain",
-				"app":        "puppet-agent",
-				"pid":        51564,
-				"message_id": "-",
-				"message":    "(/Stage[main]/Users_prd/Ssh_authorized_key[1063-username]) Dependency Group[group] has failures: true",
+				"priority":  29,
+				"version":   1,
+				"timestamp": "2015-03-03T06:49:08-08:00",
+				"host":      "localhost.localdomain",
+				"app":       "puppet-agent",
+				"pid":       51564,
+				"procid":    "51564",
+				"message":   "(/Stage[main]/Users_prd/Ssh_authorized_key[1063-username]) Dependency Group[group] has failures: true",
 			},
 		},
 		{
 			fmt:     "syslog",
 			message: `<142>1 2015-03-02T22:23:07-08:00 localhost.localdomain Keepalived_vrrp 21125 - VRRP_Instance(VI_1) ignoring received advertisement...`,
 			expected: map[string]interface{}{
-				"priority":   142,
-				"version":    1,
-				"timestamp":  "2015-03-02T22:23:07-08:00",
-				"host":       "localhost.localdomain",
-				"app":        "Keepalived_vrrp",
-				"pid":        21125,
-				"message_id": "-",
-				"message":    "VRRP_Instance(VI_1) ignoring received advertisement...",
+				"priority":  142,
+				"version":   1,
+				"timestamp": "2015-03-02T22:23:07-08:00",
+				"host":      "localhost.localdomain",
+				"app":       "Keepalived_vrrp",
+				"pid":       21125,
+				"procid":    "21125",
+				"message":   "VRRP_Instance(VI_1) ignoring received advertisement...",
 			},
 		},
 		{
 			fmt:     "syslog",
 			message: `<142>1 2015-03-02T22:23:07-08:00 localhost.localdomain Keepalived_vrrp 21125 - HEAD /wp-login.php HTTP/1.1" 200 167 "http://www.philipotoole.com/" "Mozilla/5.0 (Windows NT 6.1) AppleWebKit/537.11 (KHTML, like Gecko) Chrome/23.0.1271.97 Safari/537.11`,
 			expected: map[string]interface{}{
-				"priority":   142,
-				"version":    1,
-				"timestamp":  "2015-03-02T22:23:07-08:00",
-				"host":       "localhost.localdomain",
-				"app":        "Keepalived_vrrp",
-				"pid":        21125,
-				"message_id": "-",
-				"message":    `HEAD /wp-login.php HTTP/1.1" 200 167 "http://www.philipotoole.com/" "Mozilla/5.0 (Windows NT 6.1) AppleWebKit/537.11 (KHTML, like Gecko) Chrome/23.0.1271.97 Safari/537.11`,
+				"priority":  142,
+				"version":   1,
+				"timestamp": "2015-03-02T22:23:07-08:00",
+				"host":      "localhost.localdomain",
+				"app":       "Keepalived_vrrp",
+				"pid":       21125,
+				"procid":    "21125",
+				"message":   `HEAD /wp-login.php HTTP/1.1" 200 167 "http://www.philipotoole.com/" "Mozilla/5.0 (Windows NT 6.1) AppleWebKit/537.11 (KHTML, like Gecko) Chrome/23.0.1271.97 Safari/537.11`,
 			},
 		},
 		{
 			fmt:     "syslog",
 			message: `<134>0 2015-05-05T21:20:00.493320+00:00 fisher apache-access - - 173.247.206.174 - - [05/May/2015:21:19:52 +0000] "GET /2013/11/ HTTP/1.1" 200 22056 "http://www.philipotoole.com/" "Wget/1.15 (linux-gnu)"`,
 			expected: map[string]interface{}{
-				"priority":   134,
-				"version":    0,
-				"timestamp":  "2015-05-05T21:20:00.493320+00:00",
-				"host":       "fisher",
-				"app":        "apache-access",
-				"pid":        0,
-				"message_id": "-",
-				"message":    `173.247.206.174 - - [05/May/2015:21:19:52 +0000] "GET /2013/11/ HTTP/1.1" 200 22056 "http://www.philipotoole.com/" "Wget/1.15 (linux-gnu)"`,
+				"priority":  134,
+				"version":   0,
+				"timestamp": "2015-05-05T21:20:00.493320+00:00",
+				"host":      "fisher",
+				"app":       "apache-access",
+				"message":   `173.247.206.174 - - [05/May/2015:21:19:52 +0000] "GET /2013/11/ HTTP/1.1" 200 22056 "http://www.philipotoole.com/" "Wget/1.15 (linux-gnu)"`,
 			},
 		},
 		{
 			fmt:     "syslog",
 			message: `<134>0 2017-06-04T14:09:13+02:00 192.168.1.217 filterlog - - 67,,,0,vtnet0,match,pass,out,4,0x0,,127,3328,0,DF,6,tcp,366,192.168.1.66,31.13.86.4,50800,443,326,PA,1912507082:1912507408,2077294259,257,,`,
 			expected: map[string]interface{}{
-				"priority":   134,
-				"version":    0,
-				"timestamp":  "2017-06-04T14:09:13+02:00",
-				"host":       "192.168.1.217",
-				"app":        "filterlog",
-				"pid":        0,
-				"message_id": "-",
-				"message":    `67,,,0,vtnet0,match,pass,out,4,0x0,,127,3328,0,DF,6,tcp,366,192.168.1.66,31.13.86.4,50800,443,326,PA,1912507082:1912507408,2077294259,257,,`,
+				"priority":  134,
+				"version":   0,
+				"timestamp": "2017-06-04T14:09:13+02:00",
+				"host":      "192.168.1.217",
+				"app":       "filterlog",
+				"message":   `67,,,0,vtnet0,match,pass,out,4,0x0,,127,3328,0,DF,6,tcp,366,192.168.1.66,31.13.86.4,50800,443,326,PA,1912507082:1912507408,2077294259,257,,`,
+			},
+		},
+		{
+			fmt:     "syslog",
+			message: `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="Application" eventID="1011"][examplePriority@32473 class="high" class="urgent"] An application event log entry...`,
+			expected: map[string]interface{}{
+				"priority":                         165,
+				"version":                          1,
+				"timestamp":                        "2003-10-11T22:14:15.003Z",
+				"host":                             "mymachine.example.com",
+				"app":                              "evntslog",
+				"message_id":                       "ID47",
+				"message":                          "An application event log entry...",
+				"sd.exampleSDID@32473.iut":         "3",
+				"sd.exampleSDID@32473.eventSource": "Application",
+				"sd.exampleSDID@32473.eventID":     "1011",
+				"sd.examplePriority@32473.class":   "high urgent",
+			},
+		},
+		{
+			fmt:     "syslog",
+			message: "<165>1 - - - - - - \xEF\xBB\xBFpassword accepted",
+			expected: map[string]interface{}{
+				"priority":  165,
+				"version":   1,
+				"timestamp": "-",
+				"message":   "password accepted",
+			},
+		},
+		{
+			fmt:     "syslog",
+			message: `<33>7 2013-09-04T10:25:52.618085 test.com worker worker-3 - password accepted`,
+			expected: map[string]interface{}{
+				"priority":  33,
+				"version":   7,
+				"timestamp": "2013-09-04T10:25:52.618085",
+				"host":      "test.com",
+				"app":       "worker",
+				"procid":    "worker-3",
+				"message":   "password accepted",
+			},
+		},
+		{
+			fmt:     "syslog",
+			message: `<33>7 2013-09-04T10:25:52.618085 test.com cron -1 - password accepted`,
+			expected: map[string]interface{}{
+				"priority":  33,
+				"version":   7,
+				"timestamp": "2013-09-04T10:25:52.618085",
+				"host":      "test.com",
+				"app":       "cron",
+				"procid":    "-1",
+				"message":   "password accepted",
+			},
+		},
+		{
+			fmt:     "syslog",
+			message: `<33>7 2013-09-04T10:25:52.618085 - - - - password accepted`,
+			expected: map[string]interface{}{
+				"priority":  33,
+				"version":   7,
+				"timestamp": "2013-09-04T10:25:52.618085",
+				"message":   "password accepted",
 			},
 		},
 		{
@@ -214,11 +277,6 @@ func Test_Parsing(t *testing.T) {
 			message: `<33> 7 2013-09-04T10:25:52.618085 test.com cron 304 - - password accepted`,
 			fail:    true,
 		},
-		{
-			fmt:     "syslog",
-			message: `<33>7 2013-09-04T10:25:52.618085 test.com cron not_a_pid - password accepted`,
-			fail:    true,
-		},
 		{
 			fmt:     "syslog",
 			message: `5:52.618085 test.com cron 65535 - password accepted`,
@@ -227,7 +285,7 @@ func Test_Parsing(t *testing.T) {
 	}
 
 	for i, tt := range tests {
-		p, _ := NewParser(tt.fmt)
+		p, _ := NewParser(tt.fmt, ParserConfig{})
 		t.Logf("using %d\n", i+1)
 		ok := p.Parse(bytes.NewBufferString(tt.message).Bytes())
 		if tt.fail {
@@ -247,8 +305,322 @@ func Test_Parsing(t *testing.T) {
 	}
 }
 
+func Test_ParsingRFC3164(t *testing.T) {
+	now := time.Date(2016, time.January, 2, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		message  string
+		expected map[string]interface{}
+	}{
+		{
+			message: `<34>Oct 11 22:14:15 mymachine su: 'su root' failed for lonvick on /dev/pts/8`,
+			expected: map[string]interface{}{
+				"priority":  34,
+				"timestamp": "2015-10-11T22:14:15Z",
+				"host":      "mymachine",
+				"app":       "su",
+				"message":   "'su root' failed for lonvick on /dev/pts/8",
+			},
+		},
+		{
+			message: `<13>Jan  2 09:59:00 10.0.0.1 sshd[1999]: password accepted`,
+			expected: map[string]interface{}{
+				"priority":  13,
+				"timestamp": "2016-01-02T09:59:00Z",
+				"host":      "10.0.0.1",
+				"app":       "sshd",
+				"pid":       1999,
+				"message":   "password accepted",
+			},
+		},
+		{
+			message: `<13>Jan 05 09:59:00 web01 postfix/smtpd[42]: connect from unknown`,
+			expected: map[string]interface{}{
+				"priority":  13,
+				"timestamp": "2016-01-05T09:59:00Z",
+				"host":      "web01",
+				"app":       "postfix/smtpd",
+				"pid":       42,
+				"message":   "connect from unknown",
+			},
+		},
+		{
+			message: `<13>Jan  2 09:59:00 kernel: Out of memory`,
+			expected: map[string]interface{}{
+				"priority":  13,
+				"timestamp": "2016-01-02T09:59:00Z",
+				"app":       "kernel",
+				"message":   "Out of memory",
+			},
+		},
+		{
+			message: `<13>Dec 31 23:59:59 web01 the system is going down`,
+			expected: map[string]interface{}{
+				"priority":  13,
+				"timestamp": "2015-12-31T23:59:59Z",
+				"host":      "web01",
+				"message":   "the system is going down",
+			},
+		},
+		{
+			message: `<13>2016-01-02T09:59:00+02:00 web01 cron[3]: job started`,
+			expected: map[string]interface{}{
+				"priority":  13,
+				"timestamp": "2016-01-02T09:59:00+02:00",
+				"host":      "web01",
+				"app":       "cron",
+				"pid":       3,
+				"message":   "job started",
+			},
+		},
+		{
+			message: `<13>Mar  1 12:00:00 web01 java: NullPointerException` + "\n\tat Main.java:42",
+			expected: map[string]interface{}{
+				"priority":  13,
+				"timestamp": "2015-03-01T12:00:00Z",
+				"host":      "web01",
+				"app":       "java",
+				"message":   "NullPointerException\n\tat Main.java:42",
+			},
+		},
+		{
+			message: `Oct 11 22:14:15 mymachine su: no priority`,
+		},
+		{
+			message: `<192>Oct 11 22:14:15 mymachine su: invalid priority`,
+		},
+		{
+			message: `<34>Foo 11 22:14:15 mymachine su: invalid month`,
+		},
+		{
+			message: `<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - RFC5424`,
+		},
+	}
+
+	p := &LogHandler{Parser: &parser.RFC3164{Location: time.UTC, Now: func() time.Time { return now }}}
+	p.Parser.Init()
+	for _, tt := range tests {
+		ok := p.Parse([]byte(tt.message))
+		if tt.expected == nil {
+			if ok {
+				t.Errorf("parsing '%s' should fail, got %v", tt.message, p.Result)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("failed to parse '%s'", tt.message)
+			continue
+		}
+		if !reflect.DeepEqual(tt.expected, p.Result) {
+			t.Errorf("wrong result parsing '%s'\nexp: %v\ngot: %v", tt.message, tt.expected, p.Result)
+		}
+	}
+}
+
+func Test_ParsingRFC3164Config(t *testing.T) {
+	p, err := NewParser("bsd", ParserConfig{
+		RFC3164Location: time.FixedZone("EST", -5*60*60),
+		RFC3164Year:     2012,
+	})
+	if err != nil {
+		t.Fatalf("failed to create parser: %s", err.Error())
+	}
+	if !p.Parse([]byte(`<34>Feb 29 22:14:15 mymachine su: leap year`)) {
+		t.Fatalf("failed to parse message")
+	}
+	if exp, got := "2012-02-29T22:14:15-05:00", p.Result["timestamp"]; got != exp {
+		t.Fatalf("wrong timestamp, exp %s, got %v", exp, got)
+	}
+}
+
+func Test_ParsingJSON(t *testing.T) {
+	p, err := NewParser("json", ParserConfig{JSONTimestampKey: "ts"})
+	if err != nil {
+		t.Fatalf("failed to create parser: %s", err.Error())
+	}
+	if p.Parse([]byte(`["not", "an", "object"]`)) {
+		t.Fatalf("parsed JSON array as a message")
+	}
+	if !p.Parse([]byte(`{"ts":"2016-01-02T10:00:00Z","level":"error","http":{"status":500,"latency":0.25}}`)) {
+		t.Fatalf("failed to parse message")
+	}
+	for k, v := range map[string]interface{}{
+		"timestamp":    "2016-01-02T10:00:00Z",
+		"ts":           "2016-01-02T10:00:00Z",
+		"level":        "error",
+		"http.status":  int64(500),
+		"http.latency": 0.25,
+	} {
+		if p.Result[k] != v {
+			t.Errorf("wrong value for field %s, exp %v, got %v", k, v, p.Result[k])
+		}
+	}
+}
+
+func Test_ParsingRFC5424JSON(t *testing.T) {
+	message := `<134>1 2003-08-24T05:14:15Z ubuntu billing 1999 - {"timestamp":"2016-01-02T10:00:00Z","host":"other","level":"error","user_id":1234}`
+	p, err := NewParser("syslog", ParserConfig{})
+	if err != nil {
+		t.Fatalf("failed to create parser: %s", err.Error())
+	}
+	if !p.Parse([]byte(message)) {
+		t.Fatalf("failed to parse message")
+	}
+	if _, ok := p.Result["level"]; ok {
+		t.Fatalf("JSON message parsed, but not enabled")
+	}
+
+	p, err = NewParser("syslog", ParserConfig{RFC5424JSON: true})
+	if err != nil {
+		t.Fatalf("failed to create parser: %s", err.Error())
+	}
+	if !p.Parse([]byte(message)) {
+		t.Fatalf("failed to parse message")
+	}
+	for k, v := range map[string]interface{}{
+		"timestamp": "2003-08-24T05:14:15Z",
+		"host":      "ubuntu",
+		"app":       "billing",
+		"level":     "error",
+		"user_id":   int64(1234),
+	} {
+		if p.Result[k] != v {
+			t.Errorf("wrong value for field %s, exp %v, got %v", k, v, p.Result[k])
+		}
+	}
+
+	if !p.Parse([]byte(`<134>1 2003-08-24T05:14:15Z ubuntu billing 1999 - {not json}`)) {
+		t.Fatalf("failed to parse message with invalid JSON")
+	}
+	if p.Result["message"] != "{not json}" {
+		t.Fatalf("wrong message, got %v", p.Result["message"])
+	}
+}
+
+func Test_ParsingGELF(t *testing.T) {
+	p, err := NewParser("gelf", ParserConfig{})
+	if err != nil {
+		t.Fatalf("failed to create parser: %s", err.Error())
+	}
+	for _, m := range []string{
+		`{"version":"1.1","host":"docker01"}`,
+		`{"version":"1.1","short_message":"disk full"}`,
+		`not json`,
+	} {
+		if p.Parse([]byte(m)) {
+			t.Errorf("parsing '%s' should fail, got %v", m, p.Result)
+		}
+	}
+
+	if !p.Parse([]byte(`{"version":"1.1","host":"docker01","short_message":"disk full","full_message":"disk full\nat /var","level":3,` +
+		`"timestamp":1454407200.25,"_container_name":"billing","_user_id":1234,"_host":"other","_id":"reserved"}`)) {
+		t.Fatalf("failed to parse message")
+	}
+	exp := map[string]interface{}{
+		"gelf_version":   "1.1",
+		"host":           "docker01",
+		"message":        "disk full",
+		"full_message":   "disk full\nat /var",
+		"level":          int64(3),
+		"timestamp":      "2016-02-02T10:00:00.25Z",
+		"container_name": "billing",
+		"user_id":        int64(1234),
+	}
+	if len(p.Result) != len(exp) {
+		t.Fatalf("wrong number of fields, exp %v, got %v", exp, p.Result)
+	}
+	for k, v := range exp {
+		if p.Result[k] != v {
+			t.Errorf("wrong value for field %s, exp %v, got %v", k, v, p.Result[k])
+		}
+	}
+}
+
+func Test_ParsingAuto(t *testing.T) {
+	tests := []struct {
+		message string
+		format  string
+		fields  map[string]interface{}
+	}{
+		{
+			message: `<134>1 2003-08-24T05:14:15.000003-07:00 ubuntu sshd 1999 - password accepted`,
+			format:  "RFC5424",
+			fields:  map[string]interface{}{"host": "ubuntu", "app": "sshd", "pid": 1999},
+		},
+		{
+			message: `<34>Oct 11 22:14:15 mymachine su[12]: 'su root' failed for lonvick on /dev/pts/8`,
+			format:  "RFC3164",
+			fields:  map[string]interface{}{"host": "mymachine", "app": "su", "pid": 12},
+		},
+		{
+			message: `<140>Jan 13 16:23:02 M200 80XX0123456789 (2017-01-13T21:23:02Z) firewall msg_id="3000-0148" Allow 1-Trusted 0-External tcp 10.0.1.2 93.184.216.34`,
+			format:  "Watchguard",
+			fields:  map[string]interface{}{"model_name": "M200", "msg_id": "3000-0148", "disposition": "Allow"},
+		},
+		{
+			message: `{"timestamp":"2016-01-02T10:00:00Z","level":"error","user":{"id":1234}}`,
+			format:  "JSON",
+			fields:  map[string]interface{}{"timestamp": "2016-01-02T10:00:00Z", "level": "error", "user.id": int64(1234)},
+		},
+		{
+			message: " \t<134>1 2003-08-24T05:14:15.000003-07:00 ubuntu sshd 1999 - password accepted",
+			format:  "RFC5424",
+			fields:  map[string]interface{}{"host": "ubuntu", "app": "sshd", "pid": 1999},
+		},
+		{
+			message: "\xef\xbb\xbf<134>1 2003-08-24T05:14:15.000003-07:00 ubuntu sshd 1999 - password accepted",
+			format:  "RFC5424",
+			fields:  map[string]interface{}{"host": "ubuntu", "app": "sshd", "pid": 1999},
+		},
+		{
+			message: "\xef\xbb\xbf <34>Oct 11 22:14:15 mymachine su[12]: 'su root' failed for lonvick on /dev/pts/8",
+			format:  "RFC3164",
+			fields:  map[string]interface{}{"host": "mymachine", "app": "su", "pid": 12},
+		},
+		{
+			message: "\xef\xbb\xbf{\"level\":\"error\"}",
+			format:  "JSON",
+			fields:  map[string]interface{}{"level": "error"},
+		},
+		{
+			message: `password accepted`,
+		},
+		{
+			message: `{"unterminated": true`,
+		},
+		{
+			message: `<34> not syslog`,
+		},
+	}
+
+	p, err := NewParser("auto", ParserConfig{})
+	if err != nil {
+		t.Fatalf("failed to create parser: %s", err.Error())
+	}
+	for _, tt := range tests {
+		ok := p.Parse([]byte(tt.message))
+		if tt.format == "" {
+			if ok {
+				t.Errorf("parsing '%s' should fail, got %v", tt.message, p.Result)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("failed to parse '%s'", tt.message)
+			continue
+		}
+		if p.Result["format"] != tt.format {
+			t.Errorf("wrong format detected for '%s', exp %s, got %v", tt.message, tt.format, p.Result["format"])
+		}
+		for k, v := range tt.fields {
+			if p.Result[k] != v {
+				t.Errorf("wrong value for field %s of '%s', exp %v, got %v", k, tt.message, v, p.Result[k])
+			}
+		}
+	}
+}
+
 func Benchmark_Parsing(b *testing.B) {
-	p, _ := NewParser("syslog")
+	p, _ := NewParser("syslog", ParserConfig{})
 	for n := 0; n < b.N; n++ {
 		ok := p.Parse(bytes.NewBufferString(`<134>0 2015-05-05T21:20:00.493320+00:00 fisher apache-access - - 173.247.206.174 - - [05/May/2015:21:19:52 +0000] "GET /2013/11/ HTTP/1.  1" 200 22056 "http://www.philipotoole.com/" "Wget/1.15 (linux-gnu)"`).Bytes())
 		if !ok {
@@ -0,0 +1,342 @@
+package input
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	relpMaxTxnrLen    = 9  // Transaction numbers are at most 999999999
+	relpMaxCommandLen = 32 // Longest command permitted by RELP
+	relpMaxDataLenLen = 9  // Data lengths are at most 999999999
+
+	relpOK = "200 OK"
+)
+
+var errRELPFrameInvalid = errors.New("invalid RELP frame")
+
+// RELPCollector represents a network collector that accepts log messages sent
+// using the Reliable Event Logging Protocol, such as by rsyslog's omrelp. Each
+// log message is acknowledged only once its event is, when it has been indexed
+// or durably queued, so that senders retransmit log messages which were not,
+// such as when Ekanite restarts.
+type RELPCollector struct {
+	iface   string
+	format  string
+	config  ParserConfig
+	maxSize int
+
+	addr      net.Addr
+	tlsConfig *tls.Config
+	ln        *listener
+}
+
+// relpFrame is a RELP command, or response, sent by a peer.
+type relpFrame struct {
+	txnr      int
+	command   string
+	data      []byte
+	truncated bool // Whether data was truncated to the maximum message size
+}
+
+// Start instructs the RELPCollector to bind to the interface and accept connections.
+func (s *RELPCollector) Start(c chan<- *Event) error {
+	var ln net.Listener
+	var err error
+	if s.tlsConfig == nil {
+		ln, err = net.Listen("tcp", s.iface)
+	} else {
+		ln, err = tls.Listen("tcp", s.iface, s.tlsConfig)
+	}
+	if err != nil {
+		return err
+	}
+	s.addr = ln.Addr()
+	s.ln = newListener(ln)
+
+	go s.ln.serve(func(conn net.Conn) {
+		s.handleConnection(conn, c)
+	})
+	return nil
+}
+
+// Stop instructs the RELPCollector to stop accepting connections, and to close
+// existing connections once messages already sent on them are received. Senders
+// are told the connection is closing, so they retransmit any messages not yet
+// acknowledged once they reconnect.
+func (s *RELPCollector) Stop(ctx context.Context) error {
+	if s.ln == nil {
+		return nil
+	}
+	return s.ln.close(ctx)
+}
+
+// Addr returns the net.Addr that the Collector is bound to.
+func (s *RELPCollector) Addr() net.Addr {
+	return s.addr
+}
+
+func (s *RELPCollector) handleConnection(conn net.Conn, c chan<- *Event) {
+	stats.Add("relpConnections", 1)
+	defer func() {
+		stats.Add("relpConnections", -1)
+		conn.Close()
+	}()
+
+	parser, err := NewParser(s.format, s.config)
+	if err != nil {
+		panic(fmt.Sprintf("failed to create RELP connection parser:%s", err.Error()))
+	}
+
+	rsp := newRELPResponder(conn)
+	defer rsp.stop()
+
+	reader := bufio.NewReader(conn)
+	sourceIP := conn.RemoteAddr().String()
+	open := false
+	for {
+		f, err := readRELPFrame(reader, s.maxSize)
+		if err != nil && s.stopped() {
+			rsp.serverClose()
+			return
+		} else if err == io.EOF {
+			stats.Add("relpConnReadEOF", 1)
+			return
+		} else if err != nil {
+			stats.Add("relpConnReadError", 1)
+			if err == errRELPFrameInvalid {
+				stats.Add("relpFrameInvalid", 1)
+			}
+			return
+		}
+
+		switch f.command {
+		case "open":
+			open = true
+			rsp.respond(f.txnr, relpOK+"\nrelp_version=0\nrelp_software=ekanite\ncommands=syslog")
+		case "close":
+			rsp.respond(f.txnr, "")
+			return
+		case "syslog":
+			if !open {
+				rsp.respond(f.txnr, "500 session not open")
+				continue
+			}
+			log := strings.TrimRight(string(f.data), "\r\n")
+			stats.Add("relpBytesRead", int64(len(log)))
+			stats.Add("relpEventsRx", 1)
+			if f.truncated {
+				stats.Add("relpTruncated", 1)
+			}
+
+			// Log lines which could not be parsed have already been handled
+			// according to the Unparsed policy, so are acknowledged at once.
+			e := parseTruncatedEvent(parser, log, sourceIP, f.truncated)
+			if e == nil {
+				rsp.respond(f.txnr, relpOK)
+				continue
+			}
+			txnr := f.txnr
+			e.Ack = func() {
+				stats.Add("relpEventsAcked", 1)
+				rsp.respond(txnr, relpOK)
+			}
+			c <- e
+		default:
+			rsp.respond(f.txnr, "500 unsupported command")
+		}
+	}
+}
+
+// stopped returns whether the collector was stopped.
+func (s *RELPCollector) stopped() bool {
+	select {
+	case <-s.ln.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// readRELPFrame reads a frame from r. Data longer than maxSize is truncated.
+func readRELPFrame(r *bufio.Reader, maxSize int) (*relpFrame, error) {
+	txnr, sep, err := readRELPToken(r, relpMaxTxnrLen)
+	if err != nil {
+		return nil, err
+	}
+	f := &relpFrame{}
+	if f.txnr, err = strconv.Atoi(txnr); err != nil || f.txnr < 0 || sep != ' ' {
+		return nil, errRELPFrameInvalid
+	}
+	if f.command, sep, err = readRELPToken(r, relpMaxCommandLen); err != nil {
+		return nil, relpFrameError(err)
+	}
+	if f.command == "" || sep != ' ' {
+		return nil, errRELPFrameInvalid
+	}
+	dataLen, sep, err := readRELPToken(r, relpMaxDataLenLen)
+	if err != nil {
+		return nil, relpFrameError(err)
+	}
+	n, err := strconv.Atoi(dataLen)
+	if err != nil || n < 0 {
+		return nil, errRELPFrameInvalid
+	}
+
+	// A frame without data may end immediately after the data length.
+	if sep == '\n' {
+		if n != 0 {
+			return nil, errRELPFrameInvalid
+		}
+		return f, nil
+	}
+
+	read := n
+	if read > maxSize {
+		read = maxSize
+		f.truncated = true
+	}
+	f.data = make([]byte, read)
+	if _, err := io.ReadFull(r, f.data); err != nil {
+		return nil, relpFrameError(err)
+	}
+	if _, err := io.CopyN(ioutil.Discard, r, int64(n-read)); err != nil {
+		return nil, relpFrameError(err)
+	}
+	if b, err := r.ReadByte(); err != nil {
+		return nil, relpFrameError(err)
+	} else if b != '\n' {
+		return nil, errRELPFrameInvalid
+	}
+	return f, nil
+}
+
+// readRELPToken reads from r up to, and including, the next space or newline,
+// returning the bytes read before it, and the separator.
+func readRELPToken(r *bufio.Reader, maxLen int) (string, byte, error) {
+	var token []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", 0, err
+		}
+		if b == ' ' || b == '\n' {
+			return string(token), b, nil
+		}
+		if len(token) == maxLen {
+			return "", 0, errRELPFrameInvalid
+		}
+		token = append(token, b)
+	}
+}
+
+// relpFrameError returns the error for an error which occurred after the start
+// of a frame was read, when EOF indicates that the frame was incomplete.
+func relpFrameError(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// relpResponder sends responses on a RELP connection. Responses may be sent
+// from any goroutine without blocking, as events are acknowledged once indexed.
+type relpResponder struct {
+	conn net.Conn
+
+	mu      sync.Mutex
+	pending []byte
+	stopped bool
+
+	ready chan struct{}
+	done  chan struct{}
+}
+
+// newRELPResponder returns a started relpResponder for the given connection.
+func newRELPResponder(conn net.Conn) *relpResponder {
+	r := &relpResponder{
+		conn:  conn,
+		ready: make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// respond sends the response with the given data to the given transaction.
+// Responses after the responder is stopped are discarded.
+func (r *relpResponder) respond(txnr int, data string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return
+	}
+	if data == "" {
+		r.pending = append(r.pending, fmt.Sprintf("%d rsp 0\n", txnr)...)
+	} else {
+		r.pending = append(r.pending, fmt.Sprintf("%d rsp %d %s\n", txnr, len(data), data)...)
+	}
+	select {
+	case r.ready <- struct{}{}:
+	default:
+	}
+}
+
+// serverClose tells the peer that the connection is being closed.
+func (r *relpResponder) serverClose() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return
+	}
+	r.pending = append(r.pending, "0 serverclose 0\n"...)
+	select {
+	case r.ready <- struct{}{}:
+	default:
+	}
+}
+
+// stop stops the responder, once any responses already sent are written.
+func (r *relpResponder) stop() {
+	r.mu.Lock()
+	r.stopped = true
+	r.mu.Unlock()
+	select {
+	case r.ready <- struct{}{}:
+	default:
+	}
+	<-r.done
+}
+
+// run writes responses to the connection, until the responder is stopped.
+func (r *relpResponder) run() {
+	defer close(r.done)
+	for range r.ready {
+		r.mu.Lock()
+		b, stopped := r.pending, r.stopped
+		r.pending = nil
+		r.mu.Unlock()
+
+		if len(b) > 0 {
+			if _, err := r.conn.Write(b); err != nil {
+				stats.Add("relpConnWriteError", 1)
+				r.mu.Lock()
+				r.stopped = true
+				r.mu.Unlock()
+				return
+			}
+		}
+		if stopped {
+			return
+		}
+	}
+}
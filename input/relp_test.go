@@ -0,0 +1,133 @@
+package input
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_RELPCollector(t *testing.T) {
+	collector, err := NewCollector("relp", "127.0.0.1:0", "syslog", ParserConfig{}, 0, nil)
+	if err != nil {
+		t.Fatalf("failed to create collector: %s", err.Error())
+	}
+	c := make(chan *Event, 1)
+	if err := collector.Start(c); err != nil {
+		t.Fatalf("failed to start collector: %s", err.Error())
+	}
+	conn, err := net.Dial("tcp", collector.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect to collector: %s", err.Error())
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	expectResponse := func(exp string) {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		f, err := readRELPFrame(reader, DefaultMaxMessageSize)
+		if err != nil {
+			t.Fatalf("failed to read response: %s", err.Error())
+		}
+		if got := fmt.Sprintf("%d %s %s", f.txnr, f.command, f.data); got != exp {
+			t.Fatalf("wrong response, exp %q, got %q", exp, got)
+		}
+	}
+
+	offer := "relp_version=0\nrelp_software=test\ncommands=syslog"
+	conn.Write([]byte("1 open " + strconv.Itoa(len(offer)) + " " + offer + "\n"))
+	expectResponse("1 rsp 200 OK\nrelp_version=0\nrelp_software=ekanite\ncommands=syslog")
+
+	msg := "<134>1 2003-08-24T05:14:15.000003-07:00 web01 nginx 1999 - GET /index.html"
+	conn.Write([]byte("2 syslog " + strconv.Itoa(len(msg)) + " " + msg + "\n"))
+	var e *Event
+	select {
+	case e = <-c:
+		if e.Text != msg || e.Parsed["host"] != "web01" {
+			t.Fatalf("wrong event received, got %v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for event")
+	}
+
+	// The message should only be acknowledged once the event is.
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := reader.Peek(1); err == nil {
+		t.Fatalf("message acknowledged before event")
+	}
+	e.Ack()
+	expectResponse("2 rsp 200 OK")
+
+	conn.Write([]byte("3 bogus 0\n"))
+	expectResponse("3 rsp 500 unsupported command")
+
+	conn.Write([]byte("4 close 0\n"))
+	expectResponse("4 rsp ")
+}
+
+func Test_RELPCollectorNotOpen(t *testing.T) {
+	collector, err := NewCollector("relp", "127.0.0.1:0", "syslog", ParserConfig{}, 0, nil)
+	if err != nil {
+		t.Fatalf("failed to create collector: %s", err.Error())
+	}
+	if err := collector.Start(make(chan *Event, 1)); err != nil {
+		t.Fatalf("failed to start collector: %s", err.Error())
+	}
+	conn, err := net.Dial("tcp", collector.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect to collector: %s", err.Error())
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("1 syslog 5 hello\n"))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	f, err := readRELPFrame(bufio.NewReader(conn), DefaultMaxMessageSize)
+	if err != nil {
+		t.Fatalf("failed to read response: %s", err.Error())
+	}
+	if f.txnr != 1 || f.command != "rsp" || !strings.HasPrefix(string(f.data), "500 ") {
+		t.Fatalf("wrong response to message before open, got %d %s %s", f.txnr, f.command, f.data)
+	}
+}
+
+func Test_RELPFrame(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		txnr      int
+		command   string
+		data      string
+		truncated bool
+		err       bool
+	}{
+		{name: "data", input: "1 syslog 5 hello\n", txnr: 1, command: "syslog", data: "hello"},
+		{name: "data with newline", input: "12 syslog 11 hello\nworld\n", txnr: 12, command: "syslog", data: "hello\nworld"},
+		{name: "no data", input: "3 close 0\n", txnr: 3, command: "close"},
+		{name: "empty data", input: "3 close 0 \n", txnr: 3, command: "close", data: ""},
+		{name: "truncated", input: "1 syslog 24 hello world, hello world\n", txnr: 1, command: "syslog", data: "hello world, hel", truncated: true},
+		{name: "missing trailer", input: "1 syslog 5 hello!", err: true},
+		{name: "short data", input: "1 syslog 10 hello\n", err: true},
+		{name: "invalid txnr", input: "x syslog 5 hello\n", err: true},
+		{name: "invalid length", input: "1 syslog five hello\n", err: true},
+		{name: "missing data", input: "1 syslog 5\n", err: true},
+		{name: "long txnr", input: "1234567890 syslog 5 hello\n", err: true},
+	}
+	for _, tt := range tests {
+		f, err := readRELPFrame(bufio.NewReader(strings.NewReader(tt.input)), 16)
+		if tt.err {
+			if err == nil {
+				t.Fatalf("expected error for %s frame", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("failed to read %s frame: %s", tt.name, err.Error())
+		}
+		if f.txnr != tt.txnr || f.command != tt.command || string(f.data) != tt.data || f.truncated != tt.truncated {
+			t.Fatalf("wrong %s frame, got %+v", tt.name, f)
+		}
+	}
+}
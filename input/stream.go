@@ -0,0 +1,136 @@
+package input
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"time"
+)
+
+// stream reads log lines from a connection-oriented socket, such as a TCP
+// connection, and sends the resulting events to a channel.
+type stream struct {
+	conn     net.Conn
+	proto    string // Prefix of the keys of stats
+	sourceIP string
+	maxSize  int // Longest message accepted; longer messages are truncated
+	parser   *LogHandler
+	c        chan<- *Event
+	stop     <-chan struct{} // Closed once the collector is stopped
+
+	// Whether a NUL byte also ends a message, as syslog(3) of glibc terminates
+	// messages sent over a stream socket with a NUL rather than a newline.
+	nulTerminated bool
+}
+
+// read reads log lines until the connection is closed.
+func (s *stream) read() {
+	reader := bufio.NewReader(s.conn)
+
+	// Detect the framing used by the sender from the first byte received.
+	b, err := reader.Peek(1)
+	if err != nil {
+		return
+	}
+	if IsOctetCounted(b[0]) {
+		stats.Add(s.proto+"ConnOctetCounted", 1)
+		s.readOctetCounted(reader)
+		return
+	}
+	s.readNonTransparent(reader)
+}
+
+// readOctetCounted reads messages framed using octet-counting from the
+// connection, until the connection is closed or framing is lost.
+func (s *stream) readOctetCounted(reader *bufio.Reader) {
+	octets := NewOctetCountingReader(reader, s.maxSize)
+	for {
+		log, err := octets.Next()
+		if err == io.EOF {
+			stats.Add(s.proto+"ConnReadEOF", 1)
+			return
+		} else if err != nil {
+			stats.Add(s.proto+"ConnReadError", 1)
+			if err == errOctetCountInvalid {
+				stats.Add(s.proto+"OctetCountInvalid", 1)
+			} else {
+				stats.Add(s.proto+"ConnUnrecoverError", 1)
+			}
+			return
+		}
+
+		stats.Add(s.proto+"BytesRead", int64(len(log)))
+		stats.Add(s.proto+"EventsRx", 1)
+		s.dispatch(log, octets.Truncated())
+	}
+}
+
+// readNonTransparent reads messages framed by a trailing newline, or NUL if
+// nulTerminated is set, from the connection, until the connection is closed.
+func (s *stream) readNonTransparent(reader *bufio.Reader) {
+	delimiter := NewSyslogDelimiter(s.maxSize)
+	var log string
+	var match bool
+
+	for {
+		// Once the collector is stopped, the read deadline is left as set by
+		// the collector, so that only log lines already sent are read.
+		stopped := s.stopped()
+		if !stopped {
+			s.conn.SetReadDeadline(time.Now().Add(newlineTimeout))
+		}
+		b, err := reader.ReadByte()
+		if err != nil {
+			stats.Add(s.proto+"ConnReadError", 1)
+			if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
+				stats.Add(s.proto+"ConnReadTimeout", 1)
+			} else if err == io.EOF {
+				stats.Add(s.proto+"ConnReadEOF", 1)
+			} else {
+				stats.Add(s.proto+"ConnUnrecoverError", 1)
+				return
+			}
+
+			log, match = delimiter.Vestige()
+		} else if b == 0 && s.nulTerminated {
+			// The message is complete, so need not wait for the next.
+			stats.Add(s.proto+"BytesRead", 1)
+			log, match = delimiter.Vestige()
+		} else {
+			stats.Add(s.proto+"BytesRead", 1)
+			log, match = delimiter.Push(b)
+		}
+
+		// Log line available?
+		if match {
+			stats.Add(s.proto+"EventsRx", 1)
+			s.dispatch(log, delimiter.Truncated())
+		}
+
+		// Was the connection closed, or the collector stopped?
+		if err == io.EOF || (err != nil && stopped) {
+			return
+		}
+	}
+}
+
+// stopped returns whether the collector was stopped.
+func (s *stream) stopped() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// dispatch parses the log line received on the connection, and sends the
+// resulting event to the channel.
+func (s *stream) dispatch(log string, truncated bool) {
+	if truncated {
+		stats.Add(s.proto+"Truncated", 1)
+	}
+	if e := parseTruncatedEvent(s.parser, log, s.sourceIP, truncated); e != nil {
+		s.c <- e
+	}
+}
@@ -0,0 +1,212 @@
+package input
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultUnixSocketMode is the permissions of the Unix domain sockets created by
+// collectors, unless otherwise configured. By default any local user may log.
+const DefaultUnixSocketMode os.FileMode = 0666
+
+// UnixCollector represents a collector that accepts log lines on a Unix domain
+// socket, such as /dev/log.
+type UnixCollector struct {
+	proto   string
+	mode    os.FileMode
+	format  string
+	config  ParserConfig
+	maxSize int
+	addr    *net.UnixAddr
+
+	reader *datagramReader
+	ln     *listener
+}
+
+// NewUnixCollector returns a collector that will create, on Start(), a Unix
+// domain socket at the given path, with the given permissions, and parse log
+// messages in the given format, as configured by config. The protocol
+// is either "unixgram", for a datagram socket as used by syslog(3) and logger(1),
+// or "unix" for a stream socket. Messages on a stream socket may end with either
+// a newline or a NUL. Log messages longer than maxSize bytes are truncated; if
+// maxSize is zero, DefaultMaxMessageSize is used.
+func NewUnixCollector(proto, path, format string, config ParserConfig, maxSize int, mode os.FileMode) (Collector, error) {
+	// Verify that a parser can be instantiated. The actual parser that is used will
+	// be created by the socket handler.
+	_, err := NewParser(format, config)
+	if err != nil {
+		return nil, err
+	}
+
+	proto = strings.ToLower(proto)
+	if proto != "unix" && proto != "unixgram" {
+		return nil, fmt.Errorf("unsupported Unix collector protocol")
+	}
+	return &UnixCollector{
+		proto:   proto,
+		mode:    mode,
+		format:  format,
+		config:  config,
+		maxSize: maxMessageSize(maxSize),
+		addr:    &net.UnixAddr{Name: path, Net: proto},
+	}, nil
+}
+
+// Start instructs the UnixCollector to create the socket, and start receiving
+// log lines. Any existing socket at the path is first removed.
+func (s *UnixCollector) Start(c chan<- *Event) error {
+	if err := removeSocket(s.addr.Name); err != nil {
+		return err
+	}
+
+	parser, err := NewParser(s.format, s.config)
+	if err != nil {
+		panic(fmt.Sprintf("failed to create Unix socket parser:%s", err.Error()))
+	}
+
+	if s.proto == "unixgram" {
+		sock, err := bindSocket(s.addr.Name, s.mode, func(path string) (io.Closer, error) {
+			return net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+		})
+		if err != nil {
+			return err
+		}
+		conn := sock.(*net.UnixConn)
+		s.reader = newDatagramReader(conn)
+		go s.readDatagrams(conn, parser, c)
+		return nil
+	}
+
+	sock, err := bindSocket(s.addr.Name, s.mode, func(path string) (io.Closer, error) {
+		ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+		if err != nil {
+			return nil, err
+		}
+		// The socket is renamed once bound, so is removed by Stop instead.
+		ln.SetUnlinkOnClose(false)
+		return ln, nil
+	})
+	if err != nil {
+		return err
+	}
+	s.ln = newListener(sock.(*net.UnixListener))
+	go s.ln.serve(func(conn net.Conn) {
+		s.handleConnection(conn, c)
+	})
+	return nil
+}
+
+// Stop instructs the UnixCollector to stop receiving log lines, once those
+// already sent to it are received. The socket is removed.
+func (s *UnixCollector) Stop(ctx context.Context) error {
+	if s.reader != nil {
+		err := s.reader.close(ctx)
+		os.Remove(s.addr.Name)
+		return err
+	}
+	if s.ln != nil {
+		err := s.ln.close(ctx)
+		os.Remove(s.addr.Name)
+		return err
+	}
+	return nil
+}
+
+// Addr returns the address of the socket.
+func (s *UnixCollector) Addr() net.Addr {
+	return s.addr
+}
+
+func (s *UnixCollector) readDatagrams(conn *net.UnixConn, parser *LogHandler, c chan<- *Event) {
+	defer close(s.reader.done)
+	buf := make([]byte, s.maxSize+1)
+	for {
+		log, truncated, _, err := readDatagram(conn, buf, s.maxSize)
+		stats.Add("unixgramBytesRead", int64(len(log)))
+		if err != nil {
+			if s.reader.stopped() {
+				return
+			}
+			continue
+		}
+		if truncated {
+			stats.Add("unixgramTruncated", 1)
+		}
+		if e := parseTruncatedEvent(parser, log, "", truncated); e != nil {
+			c <- e
+		}
+		stats.Add("unixgramEventsRx", 1)
+	}
+}
+
+func (s *UnixCollector) handleConnection(conn net.Conn, c chan<- *Event) {
+	stats.Add("unixConnections", 1)
+	defer func() {
+		stats.Add("unixConnections", -1)
+		conn.Close()
+	}()
+
+	parser, err := NewParser(s.format, s.config)
+	if err != nil {
+		panic(fmt.Sprintf("failed to create Unix connection parser:%s", err.Error()))
+	}
+
+	st := &stream{
+		conn:          conn,
+		proto:         "unix",
+		maxSize:       s.maxSize,
+		parser:        parser,
+		c:             c,
+		stop:          s.ln.stop,
+		nulTerminated: true,
+	}
+	st.read()
+}
+
+// bindSocket creates a Unix domain socket at the given path, with the given
+// permissions, using bind to create the socket at a path it is given. The socket
+// is created in a private directory alongside the path, and only moved to the
+// path once its permissions are set, so that it cannot be connected to before.
+func bindSocket(path string, mode os.FileMode, bind func(path string) (io.Closer, error)) (io.Closer, error) {
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".ekanite")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "sock")
+	sock, err := bind(tmp)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(tmp, mode); err != nil {
+		sock.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		sock.Close()
+		return nil, err
+	}
+	return sock, nil
+}
+
+// removeSocket removes any Unix domain socket at the given path, left behind
+// by a previous process. Files other than sockets are not removed.
+func removeSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,142 @@
+package input
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_UnixCollectors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ekanite_")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	line := "<13>1 2003-10-11T22:14:15.003Z - myapp 12 - - hello"
+	for _, proto := range []string{"unixgram", "unix"} {
+		path := filepath.Join(dir, proto+".sock")
+
+		// A socket left behind by a previous process should be replaced.
+		if _, err := net.Listen("unix", path); err != nil {
+			t.Fatalf("failed to create stale socket: %s", err.Error())
+		}
+
+		collector, err := NewUnixCollector(proto, path, "syslog", ParserConfig{}, 0, 0620)
+		if err != nil {
+			t.Fatalf("failed to create %s collector: %s", proto, err.Error())
+		}
+		c := make(chan *Event, 1)
+		if err := collector.Start(c); err != nil {
+			t.Fatalf("failed to start %s collector: %s", proto, err.Error())
+		}
+		if collector.Addr().String() != path {
+			t.Fatalf("wrong address for %s collector, exp %s, got %s", proto, path, collector.Addr())
+		}
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("failed to stat %s socket: %s", proto, err.Error())
+		}
+		if fi.Mode().Perm() != 0620 {
+			t.Fatalf("wrong permissions for %s socket, exp %o, got %o", proto, 0620, fi.Mode().Perm())
+		}
+
+		conn, err := net.Dial(proto, path)
+		if err != nil {
+			t.Fatalf("failed to connect to %s collector: %s", proto, err.Error())
+		}
+		if _, err := conn.Write([]byte(line)); err != nil {
+			t.Fatalf("failed to write to %s collector: %s", proto, err.Error())
+		}
+		conn.Close()
+
+		select {
+		case e := <-c:
+			if e.Text != line || e.Parsed["app"] != "myapp" {
+				t.Fatalf("wrong event received by %s collector, got %v", proto, e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for event from %s collector", proto)
+		}
+	}
+}
+
+func Test_UnixCollectorNotSocket(t *testing.T) {
+	f, err := ioutil.TempFile("", "ekanite_")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %s", err.Error())
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	collector, err := NewUnixCollector("unixgram", f.Name(), "syslog", ParserConfig{}, 0, DefaultUnixSocketMode)
+	if err != nil {
+		t.Fatalf("failed to create collector: %s", err.Error())
+	}
+	if err := collector.Start(make(chan *Event)); err == nil {
+		t.Fatalf("collector replaced a file which is not a socket")
+	}
+
+	if _, err := NewUnixCollector("unixpacket", f.Name(), "syslog", ParserConfig{}, 0, DefaultUnixSocketMode); err == nil {
+		t.Fatalf("collector created with unsupported protocol")
+	}
+}
+
+func Test_UnixCollectorNulTerminated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ekanite_")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log.sock")
+
+	collector, err := NewUnixCollector("unix", path, "syslog", ParserConfig{}, 0, DefaultUnixSocketMode)
+	if err != nil {
+		t.Fatalf("failed to create collector: %s", err.Error())
+	}
+	c := make(chan *Event, 2)
+	if err := collector.Start(c); err != nil {
+		t.Fatalf("failed to start collector: %s", err.Error())
+	}
+	defer collector.Stop(context.Background())
+
+	// Only the socket should be left in the directory.
+	names, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read directory: %s", err.Error())
+	}
+	if len(names) != 1 || names[0].Name() != "log.sock" {
+		t.Fatalf("wrong files in socket directory, got %v", names)
+	}
+
+	// Messages sent by syslog(3) are terminated by a NUL, without a newline,
+	// and should be received without waiting for the next message.
+	lines := []string{
+		"<13>1 2003-10-11T22:14:15.003Z - myapp 12 - - hello",
+		"<13>1 2003-10-11T22:14:16.003Z - myapp 12 - - world",
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("failed to connect to collector: %s", err.Error())
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(lines[0] + "\x00" + lines[1] + "\x00")); err != nil {
+		t.Fatalf("failed to write to collector: %s", err.Error())
+	}
+
+	for _, line := range lines {
+		select {
+		case e := <-c:
+			if e.Text != line || e.Parsed["app"] != "myapp" {
+				t.Fatalf("wrong event received, exp %s, got %v", line, e)
+			}
+		case <-time.After(newlineTimeout / 2):
+			t.Fatalf("timed out waiting for event %s", line)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package input
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// ParseErrorField is the field set on events for log lines which could not be
+// parsed, when such lines are indexed. Its value is the input format.
+const ParseErrorField = "parse_error"
+
+// An UnparsedPolicy determines what happens to log lines which cannot be parsed.
+type UnparsedPolicy interface {
+	// Unparsed handles the event for a log line which could not be parsed in
+	// the given format. It returns the event to be indexed, if any.
+	Unparsed(e *Event, format string) *Event
+}
+
+// DropUnparsed is the UnparsedPolicy which drops log lines which cannot be parsed.
+type DropUnparsed struct{}
+
+// Unparsed drops the event.
+func (DropUnparsed) Unparsed(e *Event, format string) *Event {
+	stats.Add("unparsedDropped", 1)
+	return nil
+}
+
+// IndexUnparsed is the UnparsedPolicy which indexes log lines which cannot be
+// parsed. Such events use their reception time as their reference time, and
+// have the field ParseErrorField set.
+type IndexUnparsed struct{}
+
+// Unparsed marks the event as unparsed, and returns it for indexing.
+func (IndexUnparsed) Unparsed(e *Event, format string) *Event {
+	stats.Add("unparsedIndexed", 1)
+	e.Parsed = map[string]interface{}{ParseErrorField: format}
+	return e
+}
+
+// DeadLetterFile is the UnparsedPolicy which appends log lines which cannot be
+// parsed to a file. Events which cannot be indexed may also be appended to it.
+// Each log line is written as a JSON object on a single line.
+type DeadLetterFile struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+// deadLetter is an entry in a DeadLetterFile.
+type deadLetter struct {
+	ReceptionTime time.Time `json:"reception_time"`
+	SourceIP      string    `json:"source_ip,omitempty"`
+	Format        string    `json:"format,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	Text          string    `json:"text"`
+}
+
+// NewDeadLetterFile returns a DeadLetterFile appending to the file at the
+// given path. The file is created if it does not exist.
+func NewDeadLetterFile(path string) (*DeadLetterFile, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &DeadLetterFile{path: path, f: f}, nil
+}
+
+// Path returns the path of the file.
+func (d *DeadLetterFile) Path() string {
+	return d.path
+}
+
+// Unparsed appends the event to the file. No event is returned for indexing.
+func (d *DeadLetterFile) Unparsed(e *Event, format string) *Event {
+	b, err := json.Marshal(&deadLetter{
+		ReceptionTime: e.ReceptionTime,
+		SourceIP:      e.SourceIP,
+		Format:        format,
+		Text:          e.Text,
+	})
+	if err != nil {
+		stats.Add("unparsedDropped", 1)
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, err := d.f.Write(append(b, '\n')); err != nil {
+		stats.Add("unparsedDropped", 1)
+		return nil
+	}
+	stats.Add("unparsedDeadLettered", 1)
+	return nil
+}
+
+// DeadLetter appends the event, which could not be indexed due to the given
+// error, to the file.
+func (d *DeadLetterFile) DeadLetter(e *Event, err error) error {
+	b, jerr := json.Marshal(&deadLetter{
+		ReceptionTime: e.ReceptionTime,
+		SourceIP:      e.SourceIP,
+		Error:         err.Error(),
+		Text:          e.Text,
+	})
+	if jerr != nil {
+		return jerr
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, werr := d.f.Write(append(b, '\n'))
+	return werr
+}
+
+// Close closes the file.
+func (d *DeadLetterFile) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.f.Close()
+}
@@ -0,0 +1,90 @@
+package input
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_UnparsedPolicies(t *testing.T) {
+	newParser := func(policy UnparsedPolicy) *LogHandler {
+		p, err := NewParser("syslog", ParserConfig{Unparsed: policy})
+		if err != nil {
+			t.Fatalf("failed to create parser: %s", err.Error())
+		}
+		return p
+	}
+	parsed := "<33>5 1985-04-12T23:20:50.52Z test.com cron 304 - password accepted"
+	unparsed := "password accepted"
+
+	p := newParser(nil)
+	if e := ParseEvent(p, parsed, "10.0.0.1:514"); e == nil || e.Parsed["host"] != "test.com" {
+		t.Fatalf("parsed line not returned as parsed event")
+	}
+	if e := ParseEvent(p, unparsed, "10.0.0.1:514"); e != nil {
+		t.Fatalf("unparsed line not dropped")
+	}
+
+	p = newParser(IndexUnparsed{})
+	e := ParseEvent(p, unparsed, "10.0.0.1:514")
+	if e == nil {
+		t.Fatalf("unparsed line not returned for indexing")
+	}
+	if e.Text != unparsed || e.Parsed[ParseErrorField] != RFC5424Standard {
+		t.Fatalf("unparsed event incorrect, got %v", e)
+	}
+	if !e.ReferenceTime().Equal(e.ReceptionTime) {
+		t.Fatalf("unparsed event reference time is not its reception time")
+	}
+
+	dir, err := ioutil.TempDir("", "ekanite_")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	d, err := NewDeadLetterFile(filepath.Join(dir, "deadletter.log"))
+	if err != nil {
+		t.Fatalf("failed to create dead letter file: %s", err.Error())
+	}
+	p = newParser(d)
+	if e := ParseEvent(p, unparsed, "10.0.0.1:514"); e != nil {
+		t.Fatalf("dead lettered line returned for indexing")
+	}
+	if e := ParseEvent(p, "line\nwith newline", "10.0.0.2:514"); e != nil {
+		t.Fatalf("dead lettered line returned for indexing")
+	}
+	if err := d.DeadLetter(&Event{Text: "unindexed"}, errors.New("disk full")); err != nil {
+		t.Fatalf("failed to dead letter unindexed event: %s", err.Error())
+	}
+	if err := d.Close(); err != nil {
+		t.Fatalf("failed to close dead letter file: %s", err.Error())
+	}
+
+	b, err := ioutil.ReadFile(d.Path())
+	if err != nil {
+		t.Fatalf("failed to read dead letter file: %s", err.Error())
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("wrong number of dead letters, exp 3, got %d", len(lines))
+	}
+	var dl deadLetter
+	if err := json.Unmarshal([]byte(lines[1]), &dl); err != nil {
+		t.Fatalf("failed to decode dead letter: %s", err.Error())
+	}
+	if dl.Text != "line\nwith newline" || dl.SourceIP != "10.0.0.2:514" || dl.Format != RFC5424Standard {
+		t.Fatalf("dead letter incorrect, got %v", dl)
+	}
+
+	dl = deadLetter{}
+	if err := json.Unmarshal([]byte(lines[2]), &dl); err != nil {
+		t.Fatalf("failed to decode dead letter: %s", err.Error())
+	}
+	if dl.Text != "unindexed" || dl.Error != "disk full" || dl.Format != "" {
+		t.Fatalf("dead letter of unindexed event incorrect, got %v", dl)
+	}
+}
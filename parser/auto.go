@@ -0,0 +1,107 @@
+package parser
+
+import (
+	"bytes"
+	"regexp"
+)
+
+// FormatField is the field in which Auto records the detected format of
+// each message.
+const FormatField = "format"
+
+// Names of the formats detected by Auto.
+const (
+	RFC5424Format    = "RFC5424"
+	RFC3164Format    = "RFC3164"
+	WatchguardFormat = "Watchguard"
+	JSONFormat       = "JSON"
+)
+
+// Auto parses log messages in any of RFC5424, RFC3164, Watchguard, or JSON
+// format, detecting the format of each message. The name of the detected
+// format is recorded in the field FormatField.
+type Auto struct {
+	RFC5424    *RFC5424
+	RFC3164    *RFC3164
+	Watchguard *Watchguard
+	JSON       *JSON
+
+	rfc5424Header *regexp.Regexp
+}
+
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+var autoStats = func(key string, delta int64) {}
+
+func (p *Auto) Stats(callback func(key string, delta int64)) {
+	autoStats = callback
+}
+
+// logParser is implemented by the parser for each format.
+type logParser interface {
+	Init()
+	Parse(raw []byte, result *map[string]interface{})
+}
+
+// Init initializes the parser for each format, creating any which are nil.
+func (p *Auto) Init() {
+	if p.RFC5424 == nil {
+		p.RFC5424 = &RFC5424{}
+	}
+	if p.RFC3164 == nil {
+		p.RFC3164 = &RFC3164{}
+	}
+	if p.Watchguard == nil {
+		p.Watchguard = &Watchguard{}
+	}
+	if p.JSON == nil {
+		p.JSON = &JSON{}
+	}
+	for _, lp := range []logParser{p.RFC5424, p.RFC3164, p.Watchguard, p.JSON} {
+		lp.Init()
+	}
+
+	// RFC5424 messages are distinguished from other syslog messages by the
+	// VERSION, which follows the PRI.
+	p.rfc5424Header = regexp.MustCompile(`^<[0-9]{1,3}>[0-9]{1,3} `)
+}
+
+func (p *Auto) Parse(raw []byte, result *map[string]interface{}) {
+	// Any byte order mark, and leading whitespace, is not part of the message.
+	raw = bytes.TrimLeft(bytes.TrimPrefix(raw, utf8BOM), " \t\r\n")
+
+	var formats []string
+	switch {
+	case len(raw) > 0 && raw[0] == '{':
+		formats = []string{JSONFormat}
+	case p.rfc5424Header.Match(raw):
+		formats = []string{RFC5424Format}
+	case len(raw) > 0 && raw[0] == '<':
+		// Watchguard messages are also valid RFC3164 messages, so the more
+		// specific format is tried first.
+		formats = []string{WatchguardFormat, RFC3164Format}
+	}
+
+	for _, f := range formats {
+		p.parser(f).Parse(raw, result)
+		if len(*result) > 0 {
+			autoStats("autoDetected"+f, 1)
+			(*result)[FormatField] = f
+			return
+		}
+	}
+	autoStats("autoUndetected", 1)
+}
+
+// parser returns the parser for the given format.
+func (p *Auto) parser(format string) logParser {
+	switch format {
+	case RFC5424Format:
+		return p.RFC5424
+	case RFC3164Format:
+		return p.RFC3164
+	case WatchguardFormat:
+		return p.Watchguard
+	}
+	return p.JSON
+}
@@ -0,0 +1,86 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"time"
+)
+
+// GELF parses log messages in the Graylog Extended Log Format, such as
+// {"version":"1.1","host":"web01","short_message":"disk full","level":3,"_user_id":1234}.
+// The short message is the field message, and the full message full_message.
+// The level, a syslog severity, is the field level. The timestamp, in seconds
+// since the epoch, becomes the reference timestamp. Additional fields, whose
+// keys begin with '_', are fields named without the leading '_', unless they
+// would replace one of the standard fields.
+type GELF struct{}
+
+var gelfStats = func(key string, delta int64) {}
+
+func (p *GELF) Stats(callback func(key string, delta int64)) {
+	gelfStats = callback
+}
+
+func (p *GELF) Init() {}
+
+func (p *GELF) Parse(raw []byte, result *map[string]interface{}) {
+	var obj map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber()
+	if err := d.Decode(&obj); err != nil || obj == nil {
+		gelfStats("gelfUnparsed", 1)
+		return
+	}
+
+	// A short message and host are required.
+	msg, _ := obj["short_message"].(string)
+	host, _ := obj["host"].(string)
+	if msg == "" || host == "" {
+		gelfStats("gelfUnparsed", 1)
+		return
+	}
+
+	fields := map[string]interface{}{
+		"message": msg,
+		"host":    host,
+	}
+	if v, ok := obj["version"].(string); ok {
+		fields["gelf_version"] = v
+	}
+	if v, ok := obj["full_message"].(string); ok {
+		fields["full_message"] = v
+	}
+	if v, ok := obj["level"].(json.Number); ok {
+		if n, err := v.Int64(); err == nil {
+			fields["level"] = n
+		}
+	}
+	if v, ok := obj["timestamp"].(json.Number); ok {
+		if f, err := v.Float64(); err == nil {
+			sec, frac := math.Modf(f)
+			t := time.Unix(int64(sec), int64(math.Round(frac*1e6))*1e3)
+			fields["timestamp"] = t.UTC().Format(time.RFC3339Nano)
+		}
+	}
+
+	// Additional fields. The field _id is reserved, and must not be sent.
+	additional := make(map[string]interface{})
+	for k, v := range obj {
+		if !strings.HasPrefix(k, "_") || k == "_id" || len(k) == 1 {
+			continue
+		}
+		additional[k[1:]] = v
+	}
+	flattened := make(map[string]interface{}, len(additional))
+	flatten("", additional, flattened)
+	for k, v := range flattened {
+		if _, ok := fields[k]; !ok {
+			fields[k] = v
+		}
+	}
+
+	gelfStats("gelfParsed", 1)
+	*result = fields
+}
@@ -0,0 +1,70 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// DefaultJSONTimestampKey is the key of the reference timestamp of JSON log
+// messages, unless otherwise configured.
+const DefaultJSONTimestampKey = "timestamp"
+
+// JSON parses log messages which are JSON objects, such as
+// {"timestamp":"2016-01-02T10:00:00Z","level":"error","msg":"disk full"}.
+// Each key of the object becomes a field. Nested objects are flattened, so
+// {"http":{"status":500}} results in the field http.status.
+type JSON struct {
+	// TimestampKey is the key, after flattening, of the reference timestamp,
+	// which must be in RFC3339 format. If empty, DefaultJSONTimestampKey is used.
+	TimestampKey string
+}
+
+var jsonStats = func(key string, delta int64) {}
+
+func (p *JSON) Stats(callback func(key string, delta int64)) {
+	jsonStats = callback
+}
+
+func (p *JSON) Init() {
+	if p.TimestampKey == "" {
+		p.TimestampKey = DefaultJSONTimestampKey
+	}
+}
+
+func (p *JSON) Parse(raw []byte, result *map[string]interface{}) {
+	var obj map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber()
+	if err := d.Decode(&obj); err != nil || obj == nil {
+		jsonStats("jsonUnparsed", 1)
+		return
+	}
+
+	fields := make(map[string]interface{}, len(obj))
+	flatten("", obj, fields)
+	if ts, ok := fields[p.TimestampKey].(string); ok {
+		fields["timestamp"] = ts
+	}
+
+	jsonStats("jsonParsed", 1)
+	*result = fields
+}
+
+// flatten copies the values of obj into fields, with the keys of nested
+// objects joined to the keys of their parents with '.'.
+func flatten(prefix string, obj map[string]interface{}, fields map[string]interface{}) {
+	for k, v := range obj {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			flatten(prefix+k+".", v, fields)
+		case json.Number:
+			if n, err := v.Int64(); err == nil {
+				fields[prefix+k] = n
+			} else if f, err := v.Float64(); err == nil {
+				fields[prefix+k] = f
+			}
+		default:
+			fields[prefix+k] = v
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package parser
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// rfc3164FutureSlack is how far in the future a timestamp, once the year has
+// been inferred, may be before it is assumed to be from the previous year.
+// This allows for senders whose clocks are slightly ahead.
+const rfc3164FutureSlack = 7 * 24 * time.Hour
+
+// RFC3164 parses BSD syslog messages, as described by RFC3164, such as
+// "<34>Oct 11 22:14:15 mymachine su[123]: 'su root' failed". RFC3164
+// timestamps include neither the year nor the time zone, so these are inferred.
+type RFC3164 struct {
+	// Location is the time zone of the timestamps. If nil, the local time
+	// zone is used.
+	Location *time.Location
+
+	// Year is the year of the timestamps. If zero, the year is inferred
+	// such that the timestamp is not in the future.
+	Year int
+
+	// Now returns the current time, used when inferring the year. If nil,
+	// time.Now is used.
+	Now func() time.Time
+
+	matcher *regexp.Regexp
+	tag     *regexp.Regexp
+}
+
+var rfc3164Stats = func(key string, delta int64) {}
+
+func (p *RFC3164) Stats(callback func(key string, delta int64)) {
+	rfc3164Stats = callback
+}
+
+func (p *RFC3164) Init() {
+	leading := `(?s)^`
+	pri := `<([0-9]{1,3})>`
+	ts := `([A-Z][a-z]{2} [ 0-9][0-9] [0-9]{2}:[0-9]{2}:[0-9]{2}|[0-9]{4}-[^ ]+)`
+
+	// Senders which omit the HOSTNAME are detected by the TAG, which is
+	// followed by '[' or ':', immediately following the timestamp.
+	host := `(?:([^ :\[\]]+) )?`
+	msg := `(.*$)`
+	p.matcher = regexp.MustCompile(leading + pri + ts + ` ` + host + msg)
+
+	// The TAG, usually the name of the sending program, is followed by
+	// the PID in brackets, a colon, or both.
+	p.tag = regexp.MustCompile(`(?s)^([\w\-./]{1,48})(?:\[([0-9]{1,10})\])?: ?(.*$)`)
+}
+
+func (p *RFC3164) Parse(raw []byte, result *map[string]interface{}) {
+	m := p.matcher.FindStringSubmatch(string(raw))
+	if m == nil || len(m) != 5 {
+		rfc3164Stats("rfc3164Unparsed", 1)
+		return
+	}
+	pri, _ := strconv.Atoi(m[1])
+	if pri > 191 {
+		rfc3164Stats("rfc3164Unparsed", 1)
+		return
+	}
+	timestamp, err := p.timestamp(m[2])
+	if err != nil {
+		rfc3164Stats("rfc3164Unparsed", 1)
+		return
+	}
+	host, msg := m[3], m[4]
+
+	rfc3164Stats("rfc3164Parsed", 1)
+	*result = map[string]interface{}{
+		"priority":  pri,
+		"timestamp": timestamp.Format(time.RFC3339),
+		"message":   msg,
+	}
+	if host != "" {
+		(*result)["host"] = host
+	}
+	if t := p.tag.FindStringSubmatch(msg); t != nil {
+		(*result)["app"] = t[1]
+		if t[2] != "" {
+			pid, _ := strconv.Atoi(t[2])
+			(*result)["pid"] = pid
+		}
+		(*result)["message"] = t[3]
+	}
+}
+
+// timestamp parses the given RFC3164 timestamp, inferring the year and time
+// zone. Timestamps in RFC3339 format, as sent by some newer senders, are
+// also accepted.
+func (p *RFC3164) timestamp(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(time.Stamp, s)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	loc := p.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	if p.Year != 0 {
+		return time.Date(p.Year, t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, loc), nil
+	}
+
+	now := time.Now
+	if p.Now != nil {
+		now = p.Now
+	}
+	n := now().In(loc)
+	ts := time.Date(n.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, loc)
+	if ts.After(n.Add(rfc3164FutureSlack)) {
+		ts = ts.AddDate(-1, 0, 0)
+	}
+	return ts, nil
+}
@@ -1,12 +1,25 @@
 package parser
 
 import (
-	"regexp"
 	"strconv"
+	"strings"
+
+	"github.com/ekanite/ekanite/rfc5424"
 )
 
+// SDFieldPrefix is the prefix of the fields holding STRUCTURED-DATA
+// parameters. Each parameter is stored in the field
+// sd.<SD-ID>.<PARAM-NAME>, for example sd.exampleSDID@32473.iut.
+const SDFieldPrefix = "sd."
+
 type RFC5424 struct {
-	matcher *regexp.Regexp
+	// JSON, if non-nil, also parses any MSG which is a JSON object, such as
+	// {"level":"error","user_id":1234}. The fields of the object are added to
+	// those of the message, except where they would replace a field parsed
+	// from the syslog header.
+	JSON *JSON
+
+	parser *rfc5424.Parser
 }
 
 var rfc5424Stats = func(key string, delta int64) {}
@@ -16,39 +29,70 @@ func (p *RFC5424) Stats(callback func(key string, delta int64)) {
 }
 
 func (p *RFC5424) Init() {
-	leading := `(?s)`
-	pri := `<([0-9]{1,3})>`
-	ver := `([0-9])`
-	ts := `([^ ]+)`
-	host := `([^ ]+)`
-	app := `([^ ]+)`
-	pid := `(-|[0-9]{1,5})`
-	id := `([\w-]+)`
-	msg := `(.+$)`
-	p.matcher = regexp.MustCompile(leading + pri + ver + `\s` + ts + `\s` + host + `\s` + app + `\s` + pid + `\s` + id + `\s` + msg)
+	p.parser = rfc5424.NewParser()
+	if p.JSON != nil {
+		p.JSON.Init()
+	}
 }
 
 func (p *RFC5424) Parse(raw []byte, result *map[string]interface{}) {
-	m := p.matcher.FindStringSubmatch(string(raw))
-	if m == nil || len(m) != 9 {
+	m, err := p.parser.Parse(raw)
+	if err != nil {
 		rfc5424Stats("rfc5424Unparsed", 1)
 		return
 	}
+
 	rfc5424Stats("rfc5424Parsed", 1)
-	pri, _ := strconv.Atoi(m[1])
-	ver, _ := strconv.Atoi(m[2])
-	var pid int
-	if m[6] != "-" {
-		pid, _ = strconv.Atoi(m[6])
-	}
 	*result = map[string]interface{}{
-		"priority":   pri,
-		"version":    ver,
-		"timestamp":  m[3],
-		"host":       m[4],
-		"app":        m[5],
-		"pid":        pid,
-		"message_id": m[7],
-		"message":    m[8],
+		"priority":  m.Priority,
+		"version":   m.Version,
+		"timestamp": m.Timestamp,
+		"message":   m.Message,
+	}
+
+	// Header fields with the NILVALUE are omitted. The PROCID is also indexed as
+	// a number, if it is one.
+	for k, v := range map[string]string{
+		"host":       m.Hostname,
+		"app":        m.AppName,
+		"procid":     m.ProcID,
+		"message_id": m.MsgID,
+	} {
+		if v != rfc5424.NilValue {
+			(*result)[k] = v
+		}
+	}
+	if pid, err := strconv.Atoi(m.ProcID); err == nil && pid >= 0 {
+		(*result)["pid"] = pid
+	}
+
+	// Parameters repeated within an element are combined into a single field.
+	for _, e := range m.StructuredData {
+		for _, param := range e.Params {
+			k := SDFieldPrefix + e.ID + "." + param.Name
+			if v, ok := (*result)[k].(string); ok {
+				(*result)[k] = v + " " + param.Value
+				continue
+			}
+			(*result)[k] = param.Value
+		}
+	}
+
+	if p.JSON != nil {
+		p.parseJSONMessage(m.Message, *result)
+	}
+}
+
+// parseJSONMessage adds the fields of a MSG which is a JSON object to result.
+func (p *RFC5424) parseJSONMessage(msg string, result map[string]interface{}) {
+	if !strings.HasPrefix(strings.TrimSpace(msg), "{") {
+		return
+	}
+	var fields map[string]interface{}
+	p.JSON.Parse([]byte(msg), &fields)
+	for k, v := range fields {
+		if _, ok := result[k]; !ok {
+			result[k] = v
+		}
 	}
 }
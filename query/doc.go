@@ -1,5 +1,17 @@
 /*
 Package query implements a parser for the Ekanite query language.
 It borrows heavily from the InfluxDB 0.9 release series query parser.
+
+A query is a series of search terms, each of which may be qualified with the
+field to be searched, for example:
+
+	login
+	app:sshd
+	host:web01 AND (GET OR POST)
+	password NOT accepted
+
+Terms without a field search the default field. Terms separated only by
+whitespace are implicitly combined with AND. AND binds more tightly than OR,
+and parentheses may be used to group terms.
 */
 package query
@@ -10,7 +10,8 @@ var eof = rune(0)
 
 // Lexer represents a lexer.
 type Lexer struct {
-	r *bufio.Reader
+	r   *bufio.Reader
+	pos int // Offset, in runes, of the next rune to be read.
 }
 
 // NewLexer returns a new instance of a Lexer.
@@ -25,11 +26,16 @@ func (s *Lexer) read() rune {
 	if err != nil {
 		return eof
 	}
+	s.pos++
 	return ch
 }
 
 // unread puts the previously read rune on the buffer.
-func (s *Lexer) unread() { _ = s.r.UnreadRune() }
+func (s *Lexer) unread() {
+	if err := s.r.UnreadRune(); err == nil {
+		s.pos--
+	}
+}
 
 // Lex returns the next token and associated literal value.
 func (s *Lexer) Lex() (tok Token, lit string) {
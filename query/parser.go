@@ -3,6 +3,8 @@ package query
 import (
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
 )
 
 // Expr represents an expression.
@@ -56,16 +58,32 @@ func (s *Statement) String() string {
 	return b
 }
 
+// ParseError represents an error that occurred during parsing, at a known
+// position in the query.
+type ParseError struct {
+	Message string
+	Pos     int // Offset of the offending token, in runes, from the start of the query.
+}
+
+// Error returns the string representation of the error.
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at char %d", e.Message, e.Pos+1)
+}
+
 // Parser represents a command parser
 type Parser struct {
 	s   *Lexer
 	buf struct {
 		tok Token  // last read token
 		lit string // last read literal
+		pos int    // position of last read token
 		n   int    // buffer size (max=1)
 	}
 
-	defaultField string // Search field if none specified.
+	defaultField string          // Search field if none specified.
+	fields       map[string]bool // If non-nil, the only fields which may be searched.
+	prefixes     []string        // Prefixes of other fields which may be searched.
+	numeric      map[string]bool // Fields whose terms must be numbers.
 }
 
 // NewParser returns a new instance of Parser.
@@ -73,6 +91,45 @@ func NewParser(r io.Reader, defaultField string) *Parser {
 	return &Parser{s: NewLexer(r), defaultField: defaultField}
 }
 
+// SetFields restricts the fields that a query may explicitly search to those
+// given. A field ending in '*', such as "sd.*", allows any field beginning with
+// the preceding prefix. Parsing a query which names any other field results in
+// a ParseError.
+func (p *Parser) SetFields(fields []string) {
+	p.fields = make(map[string]bool, len(fields))
+	p.prefixes = nil
+	for _, f := range fields {
+		if strings.HasSuffix(f, "*") {
+			p.prefixes = append(p.prefixes, strings.TrimSuffix(f, "*"))
+			continue
+		}
+		p.fields[f] = true
+	}
+}
+
+// SetNumericFields sets the fields whose search terms must be numbers. Parsing
+// a query which searches any of these fields for any other term results in a
+// ParseError.
+func (p *Parser) SetNumericFields(fields []string) {
+	p.numeric = make(map[string]bool, len(fields))
+	for _, f := range fields {
+		p.numeric[f] = true
+	}
+}
+
+// validField returns whether the given field may be searched.
+func (p *Parser) validField(f string) bool {
+	if p.fields == nil || p.fields[f] {
+		return true
+	}
+	for _, prefix := range p.prefixes {
+		if strings.HasPrefix(f, prefix) && len(f) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 // lex returns the next token from the underlying lexer.
 // If a token has been unlexed then read that instead.
 func (p *Parser) lex() (tok Token, lit string) {
@@ -83,14 +140,20 @@ func (p *Parser) lex() (tok Token, lit string) {
 	}
 
 	// Otherwise read the next token from the lexer.
+	pos := p.s.pos
 	tok, lit = p.s.Lex()
 
 	// Save it to the buffer in case we unlex later.
-	p.buf.tok, p.buf.lit = tok, lit
+	p.buf.tok, p.buf.lit, p.buf.pos = tok, lit, pos
 
 	return
 }
 
+// errorf returns a ParseError, at the position of the last read token.
+func (p *Parser) errorf(format string, a ...interface{}) *ParseError {
+	return &ParseError{Message: fmt.Sprintf(format, a...), Pos: p.buf.pos}
+}
+
 // unlex puts the previously read token back onto the buffer.
 func (p *Parser) unlex() { p.buf.n = 1 }
 
@@ -113,7 +176,6 @@ func (p *Parser) Parse() (Expr, error) {
 
 	expr, err := p.parseFieldExpr()
 	if err != nil {
-		fmt.Println("return err from first call to parse:", err.Error())
 		return nil, err
 	}
 
@@ -157,7 +219,7 @@ func (p *Parser) parseFieldExpr() (Expr, error) {
 
 		// Expect an RPAREN at the end.
 		if tok, lit := p.lexIgnoreWhitespace(); tok != RPAREN {
-			return nil, fmt.Errorf("found '%s', expected )", tokstr(tok, lit))
+			return nil, p.errorf("found '%s', expected )", tokstr(tok, lit))
 		}
 
 		return &ParenExpr{Expr: expr}, nil
@@ -166,14 +228,23 @@ func (p *Parser) parseFieldExpr() (Expr, error) {
 
 	tok, f1 := p.lexIgnoreWhitespace()
 	if tok != STRING {
-		return nil, fmt.Errorf("found '%s', expected FIELD or SEARCH TERM", tokstr(tok, f1))
+		return nil, p.errorf("found '%s', expected FIELD or SEARCH TERM", tokstr(tok, f1))
 	}
+	pos := p.buf.pos
 
 	tok, _ = p.lexIgnoreWhitespace()
 	if tok == COLON {
+		if !p.validField(f1) {
+			return nil, &ParseError{Message: fmt.Sprintf("unknown field '%s'", f1), Pos: pos}
+		}
 		tok, f2 := p.lexIgnoreWhitespace()
 		if tok != STRING {
-			return nil, fmt.Errorf("found '%s', expected SEARCH TERM", tokstr(tok, f2))
+			return nil, p.errorf("found '%s', expected SEARCH TERM", tokstr(tok, f2))
+		}
+		if p.numeric[f1] {
+			if _, err := strconv.ParseFloat(f2, 64); err != nil {
+				return nil, p.errorf("field '%s' requires a numeric term, got '%s'", f1, f2)
+			}
 		}
 		return &FieldExpr{Field: f1, Term: f2}, nil
 	}
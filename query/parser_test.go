@@ -118,12 +118,12 @@ func TestParser_ParseStatement(t *testing.T) {
 		},
 
 		// Errors
-		{s: `apache.status:`, err: `found 'EOF', expected SEARCH TERM`},
-		{s: `GET AND`, err: `found 'EOF', expected FIELD or SEARCH TERM`},
-		{s: `GET AND NOT`, err: `found 'NOT', expected FIELD or SEARCH TERM`},
-		{s: `:500`, err: `found ':', expected FIELD or SEARCH TERM`},
-		{s: `GET (apache.status:404 OR apache.status:500`, err: `found 'EOF', expected )`},
-		{s: `GET (apache.status:404 OR apache.status:`, err: `found 'EOF', expected SEARCH TERM`},
+		{s: `apache.status:`, err: `found 'EOF', expected SEARCH TERM at char 15`},
+		{s: `GET AND`, err: `found 'EOF', expected FIELD or SEARCH TERM at char 8`},
+		{s: `GET AND NOT`, err: `found 'NOT', expected FIELD or SEARCH TERM at char 9`},
+		{s: `:500`, err: `found ':', expected FIELD or SEARCH TERM at char 1`},
+		{s: `GET (apache.status:404 OR apache.status:500`, err: `found 'EOF', expected ) at char 44`},
+		{s: `GET (apache.status:404 OR apache.status:`, err: `found 'EOF', expected SEARCH TERM at char 41`},
 	}
 
 	for i, tt := range tests {
@@ -144,3 +144,65 @@ func errstring(err error) string {
 	}
 	return ""
 }
+
+// Ensure the parser rejects fields which are not permitted.
+func TestParser_ParseQuery_Fields(t *testing.T) {
+	var tests = []struct {
+		s   string
+		err string
+	}{
+		{s: `sshd`},
+		{s: `host:web01`},
+		{s: `host:web01 AND app:nginx`},
+		{s: `user:root`, err: `unknown field 'user' at char 1`},
+		{s: `sshd AND user:root`, err: `unknown field 'user' at char 10`},
+		{s: `host:web01 (app:nginx OR  user:root)`, err: `unknown field 'user' at char 27`},
+		{s: `sd.origin.ip:10.0.0.1`},
+		{s: `sd.:10.0.0.1`, err: `unknown field 'sd.' at char 1`},
+		{s: `sdorigin:10.0.0.1`, err: `unknown field 'sdorigin' at char 1`},
+	}
+
+	for i, tt := range tests {
+		p := NewParser(strings.NewReader(tt.s), "message")
+		p.SetFields([]string{"message", "host", "app", "sd.*"})
+		_, err := p.Parse()
+		if tt.err != errstring(err) {
+			t.Errorf("%d. %q: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.s, tt.err, err)
+			continue
+		}
+		if err != nil {
+			if _, ok := err.(*ParseError); !ok {
+				t.Errorf("%d. %q: expected *ParseError, got %T", i, tt.s, err)
+			}
+		}
+	}
+}
+
+// Ensure the parser rejects non-numeric terms for numeric fields.
+func TestParser_ParseQuery_NumericFields(t *testing.T) {
+	var tests = []struct {
+		s   string
+		err string
+	}{
+		{s: `pid:1999`},
+		{s: `pid:-1.5`},
+		{s: `host:web01`},
+		{s: `pid:root`, err: `field 'pid' requires a numeric term, got 'root' at char 5`},
+		{s: `host:web01 AND pid:root`, err: `field 'pid' requires a numeric term, got 'root' at char 20`},
+	}
+
+	for i, tt := range tests {
+		p := NewParser(strings.NewReader(tt.s), "message")
+		p.SetNumericFields([]string{"pid"})
+		_, err := p.Parse()
+		if tt.err != errstring(err) {
+			t.Errorf("%d. %q: error mismatch:\n  exp=%s\n  got=%s\n\n", i, tt.s, tt.err, err)
+			continue
+		}
+		if err != nil {
+			if _, ok := err.(*ParseError); !ok {
+				t.Errorf("%d. %q: expected *ParseError, got %T", i, tt.s, err)
+			}
+		}
+	}
+}
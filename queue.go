@@ -0,0 +1,603 @@
+package ekanite
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ekanite/ekanite/input"
+)
+
+const (
+	// QueueDirName is the name of the directory, within the data directory,
+	// holding the write-ahead queue.
+	QueueDirName = "queue"
+
+	// DefaultQueueSegmentSize is the size, in bytes, at which a new queue segment
+	// file is started, unless otherwise configured.
+	DefaultQueueSegmentSize = 64 * 1024 * 1024
+
+	queueCheckpointFileName = "checkpoint.json"
+	queueSegmentExt         = ".wal"
+	queueBufSize            = 1000 // Events waiting to be written to the queue
+	queueReplayBatchSize    = 1000
+	queueCheckpointInterval = time.Second
+)
+
+// Queue is a durable write-ahead queue of events, held in segment files on
+// disk. Events sent to the Queue are written, and acknowledged, before being
+// sent on for indexing. Once events are indexed the Queue is checkpointed, so
+// that events which were queued but not indexed, such as when Ekanite stops,
+// are replayed when the Queue is next opened. An event may be replayed even
+// though it was indexed, if Ekanite stopped before the Queue was checkpointed.
+type Queue struct {
+	path        string
+	SegmentSize int64        // Size at which a new segment file is started.
+	DeadLetter  DeadLetterer // If set, events which fail to be replayed are written to it.
+
+	c chan *input.Event
+
+	mu           sync.Mutex
+	w            *os.File         // Segment being written
+	written      queuePosition    // End of the events written
+	committed    queuePosition    // Start of the first event sent on which was not indexed
+	checkpointed queuePosition    // Committed position last checkpointed
+	notify       chan struct{}    // Signals that events were written
+	inflight     []*queueInflight // Events sent on, but not yet committed, in order
+
+	read     queuePosition // End of the events sent on for indexing
+	readFile *os.File      // Segment being read
+	readBuf  *bufio.Reader
+
+	open          bool
+	stop          chan struct{} // Signals that no further events will be sent
+	writerStopped chan struct{} // Closed once all events sent were written
+	drained       chan struct{} // Closed once all events written were sent on
+	done          chan struct{}
+	wg            sync.WaitGroup
+
+	Logger *log.Logger
+}
+
+// queuePosition is a position in the queue.
+type queuePosition struct {
+	Segment int64 `json:"segment"`
+	Offset  int64 `json:"offset"`
+}
+
+// before returns whether the position is before position o.
+func (p queuePosition) before(o queuePosition) bool {
+	return p.Segment < o.Segment || (p.Segment == o.Segment && p.Offset < o.Offset)
+}
+
+// queueInflight is an event which was sent on for indexing.
+type queueInflight struct {
+	end     queuePosition // Position after the event
+	indexed bool
+}
+
+// queueRecord is an event, as written to the queue.
+type queueRecord struct {
+	Text string `json:"text"`
+	eventMetadata
+}
+
+// NewQueue returns a Queue which will hold its segment files at path.
+func NewQueue(path string) *Queue {
+	return &Queue{
+		path:          path,
+		SegmentSize:   DefaultQueueSegmentSize,
+		c:             make(chan *input.Event, queueBufSize),
+		notify:        make(chan struct{}, 1),
+		stop:          make(chan struct{}),
+		writerStopped: make(chan struct{}),
+		drained:       make(chan struct{}),
+		done:          make(chan struct{}),
+		Logger:        log.New(os.Stderr, "[queue] ", log.LstdFlags),
+	}
+}
+
+// Open opens the queue, positioned at the first event which was not indexed.
+// Any event only partially written, such as when Ekanite crashed, is discarded.
+func (q *Queue) Open() error {
+	if err := os.MkdirAll(q.path, 0755); err != nil {
+		return err
+	}
+	segments, err := q.segments()
+	if err != nil {
+		return err
+	}
+
+	if b, err := ioutil.ReadFile(filepath.Join(q.path, queueCheckpointFileName)); err == nil {
+		if err := json.Unmarshal(b, &q.committed); err != nil {
+			return fmt.Errorf("failed to decode queue checkpoint: %s", err.Error())
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	// Segments before the checkpoint are fully indexed.
+	for len(segments) > 0 && segments[0] < q.committed.Segment {
+		if err := os.Remove(q.segmentPath(segments[0])); err != nil {
+			return err
+		}
+		segments = segments[1:]
+	}
+	if len(segments) == 0 {
+		if q.committed.Segment < 1 {
+			q.committed.Segment = 1
+		}
+		q.committed.Offset = 0
+	} else if segments[0] > q.committed.Segment {
+		q.committed = queuePosition{Segment: segments[0]}
+	}
+
+	last := q.committed.Segment
+	if len(segments) > 0 {
+		last = segments[len(segments)-1]
+	}
+	q.w, err = os.OpenFile(q.segmentPath(last), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	size, err := lastRecordEnd(q.w)
+	if err != nil {
+		return err
+	}
+	if err := q.w.Truncate(size); err != nil {
+		return err
+	}
+	if _, err := q.w.Seek(size, io.SeekStart); err != nil {
+		return err
+	}
+	q.written = queuePosition{Segment: last, Offset: size}
+	if q.written.before(q.committed) {
+		q.committed = q.written
+	}
+	q.read = q.committed
+	q.checkpointed = q.committed
+
+	q.open = true
+	return nil
+}
+
+// Replay indexes all events in the queue which were not indexed, returning the
+// number of events indexed. Events which fail to be indexed are dropped, and
+// written to the dead letter output if set, so that the queue is not held at
+// them. It must be called before Start.
+func (q *Queue) Replay(indexer EventIndexer) (int, error) {
+	n := 0
+	batch := make([]*Event, 0, queueReplayBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := indexer.Index(batch)
+		failed := failedEvents(batch, err)
+		if err != nil {
+			stats.Add("queueEventsReplayFailed", int64(len(failed)))
+			q.Logger.Printf("dropped %d queued event(s) which failed to be replayed: %s", len(failed), err.Error())
+			dropEvents(failed, err, q.DeadLetter)
+		}
+		n += len(batch) - len(failed)
+		stats.Add("queueEventsReplayed", int64(len(batch)-len(failed)))
+		for _, e := range batch {
+			if !containsEvent(failed, e) {
+				e.Ack()
+			}
+		}
+		batch = make([]*Event, 0, queueReplayBatchSize)
+		return nil
+	}
+
+	for q.read.before(q.written) {
+		e, err := q.next()
+		if err != nil {
+			return n, err
+		}
+		if e == nil {
+			continue
+		}
+		if batch = append(batch, &Event{e}); len(batch) == queueReplayBatchSize {
+			if err := flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return n, err
+	}
+	return n, q.checkpoint()
+}
+
+// Start starts writing events sent to the Queue, and sending the events written
+// to the given channel.
+func (q *Queue) Start(c chan<- *input.Event) error {
+	q.wg.Add(3)
+	go q.runWriter()
+	go q.runReader(c)
+	go q.runCheckpointer()
+	return nil
+}
+
+// C returns the channel to which events should be sent.
+func (q *Queue) C() chan<- *input.Event {
+	return q.c
+}
+
+// Stop stops the Queue accepting events, once all events already sent to it are
+// written, and sent on for indexing. If the context is done first, its error is
+// returned. The Queue must still be closed.
+func (q *Queue) Stop(ctx context.Context) error {
+	close(q.stop)
+	select {
+	case <-q.drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close closes the queue, checkpointing it. Unless the Queue was stopped first,
+// events sent to it which were not yet written are discarded, and were not
+// acknowledged.
+func (q *Queue) Close() error {
+	if !q.open {
+		return nil
+	}
+	close(q.done)
+	q.wg.Wait()
+
+	if err := q.checkpoint(); err != nil {
+		return err
+	}
+	q.closeReader()
+	q.open = false
+	return q.w.Close()
+}
+
+// runWriter writes events sent to the Queue, until the Queue is stopped or closed.
+func (q *Queue) runWriter() {
+	defer q.wg.Done()
+	for {
+		select {
+		case <-q.done:
+			return
+		case <-q.stop:
+			// Write the events already waiting, as no more will be sent.
+			for len(q.c) > 0 {
+				q.writeWaiting(<-q.c)
+			}
+			close(q.writerStopped)
+			return
+		case e := <-q.c:
+			q.writeWaiting(e)
+		}
+	}
+}
+
+// writeWaiting writes the given event, along with all events already waiting
+// to be written at once, to reduce syncs, and acknowledges them.
+func (q *Queue) writeWaiting(e *input.Event) {
+	events := []*input.Event{e}
+drain:
+	for len(events) < queueBufSize {
+		select {
+		case e := <-q.c:
+			events = append(events, e)
+		default:
+			break drain
+		}
+	}
+
+	if err := q.write(events); err != nil {
+		stats.Add("queueWriteError", 1)
+		q.Logger.Printf("failed to write %d event(s) to queue: %s", len(events), err.Error())
+		return
+	}
+	for _, e := range events {
+		if e.Ack != nil {
+			e.Ack()
+		}
+	}
+}
+
+// write durably appends the given events to the queue.
+func (q *Queue) write(events []*input.Event) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range events {
+		if err := enc.Encode(queueRecord{
+			Text: e.Text,
+			eventMetadata: eventMetadata{
+				Parsed:        e.Parsed,
+				ReceptionTime: e.ReceptionTime,
+				Sequence:      e.Sequence,
+				SourceIP:      e.SourceIP,
+			},
+		}); err != nil {
+			return err
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, err := q.w.Write(buf.Bytes()); err != nil {
+		// Discard any partial write, so later events are not appended to it.
+		q.w.Truncate(q.written.Offset)
+		q.w.Seek(q.written.Offset, io.SeekStart)
+		return err
+	}
+	if err := q.w.Sync(); err != nil {
+		return err
+	}
+	q.written.Offset += int64(buf.Len())
+	stats.Add("queueEventsWritten", int64(len(events)))
+	stats.Add("queueBytesWritten", int64(buf.Len()))
+
+	if q.written.Offset >= q.SegmentSize {
+		w, err := os.OpenFile(q.segmentPath(q.written.Segment+1), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+		if err != nil {
+			q.Logger.Printf("failed to create queue segment: %s", err.Error())
+		} else {
+			q.w.Close()
+			q.w = w
+			q.written = queuePosition{Segment: q.written.Segment + 1}
+		}
+	}
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// runReader sends written events to the given channel, until the Queue is closed.
+// Once the Queue is stopped, drained is closed when all events written were sent.
+func (q *Queue) runReader(c chan<- *input.Event) {
+	defer q.wg.Done()
+	for {
+		// Once the writer has stopped, the written position no longer changes.
+		stopped := false
+		select {
+		case <-q.writerStopped:
+			stopped = true
+		default:
+		}
+		written := q.writtenPosition()
+
+		if !q.read.before(written) {
+			if stopped {
+				close(q.drained)
+				<-q.done
+				return
+			}
+			select {
+			case <-q.done:
+				return
+			case <-q.writerStopped:
+			case <-q.notify:
+			}
+			continue
+		}
+
+		e, err := q.next()
+		if err != nil {
+			stats.Add("queueReadError", 1)
+			q.Logger.Printf("failed to read queue: %s", err.Error())
+			select {
+			case <-q.done:
+				return
+			case <-time.After(queueCheckpointInterval):
+				continue
+			}
+		}
+		if e == nil {
+			continue
+		}
+		stats.Add("queueEventsRead", 1)
+		select {
+		case <-q.done:
+			return
+		case c <- e:
+		}
+	}
+}
+
+// next reads the event at the read position, which must be before the written
+// position, and advances the read position past it. The event is acknowledged
+// once indexed, committing the position after it once all events before it are
+// also indexed. If there is no event at the read position, as the end of a
+// segment or a corrupt record was reached, nil is returned.
+func (q *Queue) next() (*input.Event, error) {
+	if q.readFile == nil {
+		f, err := os.Open(q.segmentPath(q.read.Segment))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.Seek(q.read.Offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+		q.readFile, q.readBuf = f, bufio.NewReader(f)
+	}
+
+	line, err := q.readBuf.ReadBytes('\n')
+	if err == io.EOF {
+		q.closeReader()
+		// Segments before the one being written are complete.
+		if q.read.Segment < q.writtenPosition().Segment {
+			if len(line) > 0 {
+				stats.Add("queueCorrupt", 1)
+			}
+			q.read = queuePosition{Segment: q.read.Segment + 1}
+		}
+		return nil, nil
+	} else if err != nil {
+		q.closeReader()
+		return nil, err
+	}
+
+	q.read.Offset += int64(len(line))
+
+	var r queueRecord
+	if err := json.Unmarshal(line, &r); err != nil {
+		stats.Add("queueCorrupt", 1)
+		return nil, nil
+	}
+
+	inflight := &queueInflight{end: q.read}
+	q.mu.Lock()
+	q.inflight = append(q.inflight, inflight)
+	q.mu.Unlock()
+	return &input.Event{
+		Text:          r.Text,
+		Parsed:        r.Parsed,
+		ReceptionTime: r.ReceptionTime,
+		Sequence:      r.Sequence,
+		SourceIP:      r.SourceIP,
+		Ack:           func() { q.commit(inflight) },
+	}, nil
+}
+
+// closeReader closes the segment file being read, if any.
+func (q *Queue) closeReader() {
+	if q.readFile != nil {
+		q.readFile.Close()
+		q.readFile, q.readBuf = nil, nil
+	}
+}
+
+// writtenPosition returns the end of the events written.
+func (q *Queue) writtenPosition() queuePosition {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.written
+}
+
+// commit records that the given event was indexed. The committed position
+// advances past it only once all events sent on before it were also indexed,
+// as events are indexed out of order when indexing fails and is retried.
+func (q *Queue) commit(e *queueInflight) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e.indexed = true
+	for len(q.inflight) > 0 && q.inflight[0].indexed {
+		if q.committed.before(q.inflight[0].end) {
+			q.committed = q.inflight[0].end
+		}
+		q.inflight[0] = nil
+		q.inflight = q.inflight[1:]
+	}
+}
+
+// runCheckpointer periodically checkpoints the queue, until the Queue is closed.
+func (q *Queue) runCheckpointer() {
+	defer q.wg.Done()
+	for {
+		select {
+		case <-q.done:
+			return
+		case <-time.After(queueCheckpointInterval):
+			if err := q.checkpoint(); err != nil {
+				stats.Add("queueCheckpointError", 1)
+				q.Logger.Printf("failed to checkpoint queue: %s", err.Error())
+			}
+		}
+	}
+}
+
+// checkpoint records the position of the first event which was not indexed,
+// and deletes segments before it.
+func (q *Queue) checkpoint() error {
+	q.mu.Lock()
+	committed := q.committed
+	q.mu.Unlock()
+	if committed == q.checkpointed {
+		return nil
+	}
+
+	b, err := json.Marshal(committed)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(q.path, queueCheckpointFileName)
+	if err := ioutil.WriteFile(path+".tmp", b, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+
+	for s := q.checkpointed.Segment; s < committed.Segment; s++ {
+		if err := os.Remove(q.segmentPath(s)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		stats.Add("queueSegmentsDeleted", 1)
+	}
+	q.checkpointed = committed
+	return nil
+}
+
+// segments returns the numbers of the segment files of the queue, in order.
+func (q *Queue) segments() ([]int64, error) {
+	fis, err := ioutil.ReadDir(q.path)
+	if err != nil {
+		return nil, err
+	}
+	var segments []int64
+	for _, fi := range fis {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), queueSegmentExt) {
+			continue
+		}
+		n, err := strconv.ParseInt(strings.TrimSuffix(fi.Name(), queueSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, n)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return segments, nil
+}
+
+// segmentPath returns the path of the segment file with the given number.
+func (q *Queue) segmentPath(segment int64) string {
+	return filepath.Join(q.path, fmt.Sprintf("%020d%s", segment, queueSegmentExt))
+}
+
+// lastRecordEnd returns the offset in f after the last complete record.
+func lastRecordEnd(f *os.File) (int64, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 64*1024)
+	for end := fi.Size(); end > 0; {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+		n, err := f.ReadAt(buf[:end-start], start)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			return start + int64(i) + 1, nil
+		}
+		end = start
+	}
+	return 0, nil
+}
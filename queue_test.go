@@ -0,0 +1,419 @@
+package ekanite
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ekanite/ekanite/input"
+)
+
+func TestQueue_WriteReadReplay(t *testing.T) {
+	path := tempPath()
+	defer os.RemoveAll(path)
+
+	q := NewQueue(path)
+	if err := q.Open(); err != nil {
+		t.Fatalf("failed to open queue: %s", err.Error())
+	}
+	c := make(chan *input.Event, 10)
+	if err := q.Start(c); err != nil {
+		t.Fatalf("failed to start queue: %s", err.Error())
+	}
+
+	acked := make(chan bool, 3)
+	for _, line := range []string{"first", "second", "third"} {
+		e := newInputEvent(line, time.Now())
+		e.Parsed = map[string]interface{}{"app": "nginx"}
+		e.Ack = func() { acked <- true }
+		q.C() <- e
+	}
+
+	// Events should be acknowledged once written, then sent on.
+	var events []*input.Event
+	for i := 0; i < 3; i++ {
+		select {
+		case <-acked:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for event to be acknowledged")
+		}
+		select {
+		case e := <-c:
+			events = append(events, e)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for event")
+		}
+	}
+	if events[0].Text != "first" || events[2].Text != "third" || events[1].Parsed["app"] != "nginx" {
+		t.Fatalf("wrong events read from queue, got %v", events)
+	}
+
+	// Only the first event is indexed before the queue is closed.
+	events[0].Ack()
+	if err := q.Close(); err != nil {
+		t.Fatalf("failed to close queue: %s", err.Error())
+	}
+
+	q = NewQueue(path)
+	if err := q.Open(); err != nil {
+		t.Fatalf("failed to reopen queue: %s", err.Error())
+	}
+	indexer := &TestIndexer{}
+	n, err := q.Replay(indexer)
+	if err != nil {
+		t.Fatalf("failed to replay queue: %s", err.Error())
+	}
+	if n != 2 || indexer.EventsRx != 2 {
+		t.Fatalf("wrong number of events replayed, exp 2, got %d", n)
+	}
+	if err := q.Close(); err != nil {
+		t.Fatalf("failed to close queue: %s", err.Error())
+	}
+
+	// Replayed events should not be replayed again.
+	q = NewQueue(path)
+	if err := q.Open(); err != nil {
+		t.Fatalf("failed to reopen queue: %s", err.Error())
+	}
+	defer q.Close()
+	if n, err := q.Replay(&TestIndexer{}); err != nil || n != 0 {
+		t.Fatalf("wrong replay of indexed queue, got %d events, error %v", n, err)
+	}
+}
+
+func TestQueue_Stop(t *testing.T) {
+	path := tempPath()
+	defer os.RemoveAll(path)
+
+	q := NewQueue(path)
+	if err := q.Open(); err != nil {
+		t.Fatalf("failed to open queue: %s", err.Error())
+	}
+	defer q.Close()
+	c := make(chan *input.Event, 10)
+	if err := q.Start(c); err != nil {
+		t.Fatalf("failed to start queue: %s", err.Error())
+	}
+
+	for _, line := range []string{"first", "second", "third"} {
+		q.C() <- newInputEvent(line, time.Now())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := q.Stop(ctx); err != nil {
+		t.Fatalf("failed to stop queue: %s", err.Error())
+	}
+
+	// All events sent before the queue was stopped should have been sent on.
+	if len(c) != 3 {
+		t.Fatalf("wrong number of events sent on, exp 3, got %d", len(c))
+	}
+}
+
+// textFailingIndexer fails to index events with the given text.
+type textFailingIndexer struct {
+	fail    string
+	indexed []string
+}
+
+func (f *textFailingIndexer) Index(b []*Event) error {
+	var ie IndexError
+	for _, e := range b {
+		if e.Text == f.fail {
+			ie.add([]Document{e}, fmt.Errorf("failed to index %s", e.Text))
+			continue
+		}
+		f.indexed = append(f.indexed, e.Text)
+	}
+	return ie.err()
+}
+
+// TestQueue_AbandonedEvent tests that events which were not indexed when the
+// batcher stopped remain in the queue.
+func TestQueue_AbandonedEvent(t *testing.T) {
+	path := tempPath()
+	defer os.RemoveAll(path)
+
+	q := NewQueue(path)
+	if err := q.Open(); err != nil {
+		t.Fatalf("failed to open queue: %s", err.Error())
+	}
+	b := NewBatcher(&textFailingIndexer{fail: "second"}, 3, time.Hour, 10)
+	b.RetryInterval = time.Hour
+	errChan := make(chan error, 1)
+	if err := b.Start(errChan); err != nil {
+		t.Fatalf("failed to start batcher: %s", err.Error())
+	}
+	if err := q.Start(b.C()); err != nil {
+		t.Fatalf("failed to start queue: %s", err.Error())
+	}
+
+	// The event in the middle of the batch fails to be indexed, and is still
+	// waiting to be retried when the batcher is stopped.
+	for i, line := range []string{"first", "second", "third"} {
+		e := newInputEvent(line, time.Now())
+		e.Sequence = int64(i)
+		q.C() <- e
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := q.Stop(ctx); err != nil {
+		t.Fatalf("failed to stop queue: %s", err.Error())
+	}
+	if err := b.Stop(ctx); err != nil {
+		t.Fatalf("failed to stop batcher: %s", err.Error())
+	}
+	if err := <-errChan; err == nil {
+		t.Fatalf("no error for batch with event which failed to be indexed")
+	}
+	if err := q.Close(); err != nil {
+		t.Fatalf("failed to close queue: %s", err.Error())
+	}
+
+	// The failed event, and any after it, should be replayed.
+	q = NewQueue(path)
+	if err := q.Open(); err != nil {
+		t.Fatalf("failed to reopen queue: %s", err.Error())
+	}
+	defer q.Close()
+	indexer := &textFailingIndexer{}
+	if _, err := q.Replay(indexer); err != nil {
+		t.Fatalf("failed to replay queue: %s", err.Error())
+	}
+	if !reflect.DeepEqual(indexer.indexed, []string{"second", "third"}) {
+		t.Fatalf("wrong events replayed, exp [second third], got %v", indexer.indexed)
+	}
+}
+
+// TestQueue_DroppedEvent tests that an event dropped once all retries fail,
+// without a dead letter output, does not stop the queue being checkpointed.
+func TestQueue_DroppedEvent(t *testing.T) {
+	path := tempPath()
+	defer os.RemoveAll(path)
+
+	q := NewQueue(path)
+	q.SegmentSize = 1
+	if err := q.Open(); err != nil {
+		t.Fatalf("failed to open queue: %s", err.Error())
+	}
+	b := NewBatcher(&textFailingIndexer{fail: "second"}, 1, time.Hour, 10)
+	b.MaxRetries = 0
+	errChan := make(chan error, 1)
+	if err := b.Start(errChan); err != nil {
+		t.Fatalf("failed to start batcher: %s", err.Error())
+	}
+	if err := q.Start(b.C()); err != nil {
+		t.Fatalf("failed to start queue: %s", err.Error())
+	}
+
+	for _, line := range []string{"first", "second", "third"} {
+		q.C() <- newInputEvent(line, time.Now())
+		select {
+		case err := <-errChan:
+			if (err != nil) != (line == "second") {
+				t.Fatalf("wrong result indexing %s: %v", line, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s to be indexed", line)
+		}
+	}
+	if err := q.Close(); err != nil {
+		t.Fatalf("failed to close queue: %s", err.Error())
+	}
+
+	// The checkpoint should be past the dropped event, and the segments before
+	// that of the last event deleted.
+	var committed queuePosition
+	buf, err := ioutil.ReadFile(filepath.Join(path, queueCheckpointFileName))
+	if err != nil {
+		t.Fatalf("failed to read checkpoint: %s", err.Error())
+	}
+	if err := json.Unmarshal(buf, &committed); err != nil {
+		t.Fatalf("failed to decode checkpoint: %s", err.Error())
+	}
+	if committed.Segment != 3 {
+		t.Fatalf("wrong checkpoint, exp segment 3, got %v", committed)
+	}
+	segments, err := q.segments()
+	if err != nil {
+		t.Fatalf("failed to list segments: %s", err.Error())
+	}
+	if len(segments) != 2 || segments[0] != 3 {
+		t.Fatalf("wrong segments remaining, exp [3 4], got %v", segments)
+	}
+
+	q = NewQueue(path)
+	if err := q.Open(); err != nil {
+		t.Fatalf("failed to reopen queue: %s", err.Error())
+	}
+	defer q.Close()
+	if n, err := q.Replay(&textFailingIndexer{}); err != nil || n != 0 {
+		t.Fatalf("wrong replay of queue, got %d events, error %v", n, err)
+	}
+}
+
+// TestQueue_ReplayFailed tests that events which fail to be replayed are
+// written to the dead letter output, and not replayed again.
+func TestQueue_ReplayFailed(t *testing.T) {
+	path := tempPath()
+	defer os.RemoveAll(path)
+
+	q := NewQueue(path)
+	if err := q.Open(); err != nil {
+		t.Fatalf("failed to open queue: %s", err.Error())
+	}
+	var events []*input.Event
+	for _, line := range []string{"first", "second", "third"} {
+		events = append(events, newInputEvent(line, time.Now()))
+	}
+	if err := q.write(events); err != nil {
+		t.Fatalf("failed to write events: %s", err.Error())
+	}
+	q.Close()
+
+	q = NewQueue(path)
+	d := &testDeadLetterer{}
+	q.DeadLetter = d
+	if err := q.Open(); err != nil {
+		t.Fatalf("failed to reopen queue: %s", err.Error())
+	}
+	if n, err := q.Replay(&textFailingIndexer{fail: "second"}); err != nil || n != 2 {
+		t.Fatalf("wrong replay of queue, got %d events, error %v", n, err)
+	}
+	if len(d.events) != 1 || d.events[0].Text != "second" {
+		t.Fatalf("wrong events dead lettered, got %d", len(d.events))
+	}
+	q.Close()
+
+	q = NewQueue(path)
+	if err := q.Open(); err != nil {
+		t.Fatalf("failed to reopen queue: %s", err.Error())
+	}
+	defer q.Close()
+	if n, err := q.Replay(&textFailingIndexer{}); err != nil || n != 0 {
+		t.Fatalf("wrong replay of queue, got %d events, error %v", n, err)
+	}
+}
+
+func TestQueue_Segments(t *testing.T) {
+	path := tempPath()
+	defer os.RemoveAll(path)
+
+	q := NewQueue(path)
+	q.SegmentSize = 1
+	if err := q.Open(); err != nil {
+		t.Fatalf("failed to open queue: %s", err.Error())
+	}
+	c := make(chan *input.Event, 10)
+	if err := q.Start(c); err != nil {
+		t.Fatalf("failed to start queue: %s", err.Error())
+	}
+
+	// Each event should be written to its own segment.
+	for _, line := range []string{"first", "second", "third"} {
+		q.C() <- newInputEvent(line, time.Now())
+		select {
+		case e := <-c:
+			if e.Text != line {
+				t.Fatalf("wrong event read from queue, exp %s, got %s", line, e.Text)
+			}
+			e.Ack()
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for event")
+		}
+	}
+	if err := q.Close(); err != nil {
+		t.Fatalf("failed to close queue: %s", err.Error())
+	}
+
+	// Segments before that of the last event indexed should be deleted.
+	segments, err := q.segments()
+	if err != nil {
+		t.Fatalf("failed to list segments: %s", err.Error())
+	}
+	if len(segments) != 2 || segments[0] != 3 {
+		t.Fatalf("wrong segments remaining, exp [3 4], got %v", segments)
+	}
+}
+
+func TestQueue_PartialRecord(t *testing.T) {
+	path := tempPath()
+	defer os.RemoveAll(path)
+
+	q := NewQueue(path)
+	if err := q.Open(); err != nil {
+		t.Fatalf("failed to open queue: %s", err.Error())
+	}
+	if err := q.write([]*input.Event{newInputEvent("first", time.Now())}); err != nil {
+		t.Fatalf("failed to write event: %s", err.Error())
+	}
+	q.Close()
+
+	// Append a record which was only partially written.
+	f, err := os.OpenFile(q.segmentPath(1), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("failed to open segment: %s", err.Error())
+	}
+	f.Write([]byte(`{"text":"sec`))
+	f.Close()
+
+	q = NewQueue(path)
+	if err := q.Open(); err != nil {
+		t.Fatalf("failed to reopen queue: %s", err.Error())
+	}
+	defer q.Close()
+	indexer := &TestIndexer{}
+	if n, err := q.Replay(indexer); err != nil || n != 1 {
+		t.Fatalf("wrong replay of queue, got %d events, error %v", n, err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(path, queueCheckpointFileName))
+	if err != nil {
+		t.Fatalf("failed to read checkpoint: %s", err.Error())
+	}
+	if len(b) == 0 {
+		t.Fatalf("empty checkpoint")
+	}
+}
+
+func TestEngine_ReplayQueue(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+
+	q := NewQueue(filepath.Join(dataDir, QueueDirName))
+	if err := q.Open(); err != nil {
+		t.Fatalf("failed to open queue: %s", err.Error())
+	}
+	var events []*input.Event
+	for i := 1; i <= 2; i++ {
+		ev := newInputEvent(fmt.Sprintf("this is log line %d", i), parseTime("2016-01-01T00:00:00Z"))
+		ev.Sequence = int64(i)
+		events = append(events, ev)
+	}
+	if err := q.write(events); err != nil {
+		t.Fatalf("failed to write events: %s", err.Error())
+	}
+	q.Close()
+
+	e := NewEngine(dataDir)
+	e.Queue = NewQueue(filepath.Join(dataDir, QueueDirName))
+	e.RetentionPeriod = 100 * 365 * 24 * time.Hour
+	if err := e.Open(); err != nil {
+		t.Fatalf("failed to open engine: %s", err.Error())
+	}
+	defer e.Close()
+
+	total, err := e.Total()
+	if err != nil {
+		t.Fatalf("failed to get total: %s", err.Error())
+	}
+	if total != 2 {
+		t.Fatalf("wrong number of events replayed, exp 2, got %d", total)
+	}
+}
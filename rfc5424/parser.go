@@ -0,0 +1,304 @@
+package rfc5424
+
+import (
+	"bytes"
+	"fmt"
+)
+
+const (
+	// NilValue is the value of a header field, or the structured data, which
+	// is not present in a message.
+	NilValue = "-"
+
+	maxPriority    = 191
+	maxHostnameLen = 255
+	maxAppNameLen  = 48
+	maxProcIDLen   = 128
+	maxMsgIDLen    = 32
+	maxSDNameLen   = 32
+)
+
+// bom is the UTF-8 byte order mark, which may precede the MSG.
+var bom = []byte{0xEF, 0xBB, 0xBF}
+
+// Message is a parsed RFC5424 log message. Header fields which are not present
+// in the message are set to NilValue.
+type Message struct {
+	Priority       int
+	Version        int
+	Timestamp      string
+	Hostname       string
+	AppName        string
+	ProcID         string
+	MsgID          string
+	StructuredData []SDElement
+	Message        string
+}
+
+// SDElement is a STRUCTURED-DATA element, such as
+// [exampleSDID@32473 iut="3" eventSource="Application"].
+type SDElement struct {
+	ID     string
+	Params []SDParam
+}
+
+// SDParam is a single parameter of a STRUCTURED-DATA element.
+type SDParam struct {
+	Name  string
+	Value string
+}
+
+// Parser parses an RFC5424-compliant log message.
+type Parser struct{}
+
+// NewParser returns an instance of a Parser.
+func NewParser() *Parser {
+	return &Parser{}
+}
+
+// Parse parses the given log message. An error is returned if the header of
+// the message is not valid. Messages which omit the STRUCTURED-DATA, or whose
+// STRUCTURED-DATA is malformed, are accepted, with everything following the
+// header treated as the MSG. Any byte order mark at the start of the MSG is
+// removed.
+func (p *Parser) Parse(raw []byte) (*Message, error) {
+	s := &scanner{buf: raw}
+	m := &Message{}
+	var err error
+
+	if m.Priority, err = s.priority(); err != nil {
+		return nil, err
+	}
+	if m.Version, err = s.version(); err != nil {
+		return nil, err
+	}
+	header := []struct {
+		name string
+		max  int
+		v    *string
+	}{
+		{"TIMESTAMP", 0, &m.Timestamp},
+		{"HOSTNAME", maxHostnameLen, &m.Hostname},
+		{"APP-NAME", maxAppNameLen, &m.AppName},
+		{"PROCID", maxProcIDLen, &m.ProcID},
+		{"MSGID", maxMsgIDLen, &m.MsgID},
+	}
+	for i, f := range header {
+		if i > 0 && !s.sp() {
+			return nil, fmt.Errorf("expected SP before %s at offset %d", f.name, s.pos)
+		}
+		if *f.v, err = s.field(f.name, f.max); err != nil {
+			return nil, err
+		}
+	}
+
+	// The header is followed by the STRUCTURED-DATA. It is not possible to
+	// distinguish a missing STRUCTURED-DATA from a MSG which happens to
+	// begin with '[', so malformed STRUCTURED-DATA is treated as the MSG.
+	if !s.sp() {
+		return m, nil
+	}
+	msgStart := s.pos
+	if s.nilValue() {
+		s.pos++
+	} else if s.peek() == '[' {
+		if m.StructuredData, err = s.structuredData(); err != nil || !(s.eof() || s.peek() == ' ') {
+			m.StructuredData = nil
+			s.pos = msgStart
+		}
+	}
+	if s.pos != msgStart {
+		s.sp()
+	}
+
+	m.Message = string(bytes.TrimPrefix(s.buf[s.pos:], bom))
+	return m, nil
+}
+
+// scanner tracks the position of the parser within a message.
+type scanner struct {
+	buf []byte
+	pos int
+}
+
+func (s *scanner) eof() bool {
+	return s.pos >= len(s.buf)
+}
+
+// peek returns the next byte, without consuming it, or 0 at the end of the message.
+func (s *scanner) peek() byte {
+	if s.eof() {
+		return 0
+	}
+	return s.buf[s.pos]
+}
+
+// sp consumes a single space, returning whether one was present.
+func (s *scanner) sp() bool {
+	if s.peek() != ' ' {
+		return false
+	}
+	s.pos++
+	return true
+}
+
+// nilValue returns whether the next field is NILVALUE.
+func (s *scanner) nilValue() bool {
+	return s.peek() == '-' && (s.pos+1 == len(s.buf) || s.buf[s.pos+1] == ' ')
+}
+
+// digits consumes up to max digits, returning their value and how many
+// were consumed.
+func (s *scanner) digits(max int) (int, int) {
+	v, n := 0, 0
+	for n < max && isDigit(s.peek()) {
+		v = v*10 + int(s.peek()-'0')
+		s.pos++
+		n++
+	}
+	return v, n
+}
+
+// priority consumes the PRI.
+func (s *scanner) priority() (int, error) {
+	if s.peek() != '<' {
+		return 0, fmt.Errorf("expected '<' at start of PRI")
+	}
+	s.pos++
+	v, n := s.digits(3)
+	if n == 0 || v > maxPriority {
+		return 0, fmt.Errorf("invalid PRI at offset %d", s.pos)
+	}
+	if s.peek() != '>' {
+		return 0, fmt.Errorf("expected '>' at end of PRI at offset %d", s.pos)
+	}
+	s.pos++
+	return v, nil
+}
+
+// version consumes the VERSION, and the following space.
+func (s *scanner) version() (int, error) {
+	v, n := s.digits(3)
+	if n == 0 {
+		return 0, fmt.Errorf("invalid VERSION at offset %d", s.pos)
+	}
+	if !s.sp() {
+		return 0, fmt.Errorf("expected SP after VERSION at offset %d", s.pos)
+	}
+	return v, nil
+}
+
+// field consumes a header field of printable US-ASCII. The field must be at
+// most max bytes long, unless max is 0.
+func (s *scanner) field(name string, max int) (string, error) {
+	start := s.pos
+	for !s.eof() && s.peek() != ' ' {
+		if !isPrintUSASCII(s.peek()) {
+			return "", fmt.Errorf("invalid character in %s at offset %d", name, s.pos)
+		}
+		s.pos++
+	}
+	n := s.pos - start
+	if n == 0 {
+		return "", fmt.Errorf("missing %s at offset %d", name, s.pos)
+	}
+	if max > 0 && n > max {
+		return "", fmt.Errorf("%s exceeds %d characters", name, max)
+	}
+	return string(s.buf[start:s.pos]), nil
+}
+
+// structuredData consumes one or more SD-ELEMENTs.
+func (s *scanner) structuredData() ([]SDElement, error) {
+	var elements []SDElement
+	for s.peek() == '[' {
+		e, err := s.element()
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, e)
+	}
+	return elements, nil
+}
+
+// element consumes an SD-ELEMENT.
+func (s *scanner) element() (SDElement, error) {
+	var e SDElement
+	var err error
+
+	s.pos++ // '['
+	if e.ID, err = s.sdName("SD-ID"); err != nil {
+		return e, err
+	}
+	for {
+		if s.peek() == ']' {
+			s.pos++
+			return e, nil
+		}
+		if !s.sp() {
+			return e, fmt.Errorf("expected SP or ']' in SD-ELEMENT at offset %d", s.pos)
+		}
+
+		var param SDParam
+		if param.Name, err = s.sdName("PARAM-NAME"); err != nil {
+			return e, err
+		}
+		if s.peek() != '=' {
+			return e, fmt.Errorf("expected '=' after PARAM-NAME at offset %d", s.pos)
+		}
+		s.pos++
+		if param.Value, err = s.paramValue(); err != nil {
+			return e, err
+		}
+		e.Params = append(e.Params, param)
+	}
+}
+
+// sdName consumes an SD-NAME, as used for SD-IDs and PARAM-NAMEs.
+func (s *scanner) sdName(name string) (string, error) {
+	start := s.pos
+	for !s.eof() {
+		b := s.peek()
+		if !isPrintUSASCII(b) || b == '=' || b == ']' || b == '"' {
+			break
+		}
+		s.pos++
+	}
+	n := s.pos - start
+	if n == 0 || n > maxSDNameLen {
+		return "", fmt.Errorf("invalid %s at offset %d", name, start)
+	}
+	return string(s.buf[start:s.pos]), nil
+}
+
+// paramValue consumes a quoted PARAM-VALUE, returning it with any escaping removed.
+func (s *scanner) paramValue() (string, error) {
+	if s.peek() != '"' {
+		return "", fmt.Errorf("expected '\"' at start of PARAM-VALUE at offset %d", s.pos)
+	}
+	s.pos++
+
+	var v []byte
+	for !s.eof() {
+		b := s.buf[s.pos]
+		s.pos++
+		switch b {
+		case '"':
+			return string(v), nil
+		case '\\':
+			// Only '"', '\' and ']' are escaped. A backslash preceding any
+			// other character is part of the value.
+			if next := s.peek(); next == '"' || next == '\\' || next == ']' {
+				b = next
+				s.pos++
+			}
+		}
+		v = append(v, b)
+	}
+	return "", fmt.Errorf("unterminated PARAM-VALUE")
+}
+
+// isPrintUSASCII returns whether b is a printable US-ASCII character, other than space.
+func isPrintUSASCII(b byte) bool {
+	return b >= 33 && b <= 126
+}
@@ -0,0 +1,243 @@
+package rfc5424
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_ParserParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		expected *Message
+	}{
+		{
+			name: "no structured data",
+			line: "<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - 'su root' failed for lonvick on /dev/pts/8",
+			expected: &Message{
+				Priority:  34,
+				Version:   1,
+				Timestamp: "2003-10-11T22:14:15.003Z",
+				Hostname:  "mymachine.example.com",
+				AppName:   "su",
+				ProcID:    "-",
+				MsgID:     "ID47",
+				Message:   "'su root' failed for lonvick on /dev/pts/8",
+			},
+		},
+		{
+			name: "BOM",
+			line: "<165>1 2003-08-24T05:14:15.000003-07:00 192.0.2.1 myproc 8710 - - \xEF\xBB\xBF%% It's time to make the do-nuts.",
+			expected: &Message{
+				Priority:  165,
+				Version:   1,
+				Timestamp: "2003-08-24T05:14:15.000003-07:00",
+				Hostname:  "192.0.2.1",
+				AppName:   "myproc",
+				ProcID:    "8710",
+				MsgID:     "-",
+				Message:   "%% It's time to make the do-nuts.",
+			},
+		},
+		{
+			name: "structured data",
+			line: `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="Application" eventID="1011"] An application event log entry...`,
+			expected: &Message{
+				Priority:  165,
+				Version:   1,
+				Timestamp: "2003-10-11T22:14:15.003Z",
+				Hostname:  "mymachine.example.com",
+				AppName:   "evntslog",
+				ProcID:    "-",
+				MsgID:     "ID47",
+				StructuredData: []SDElement{
+					{
+						ID: "exampleSDID@32473",
+						Params: []SDParam{
+							{Name: "iut", Value: "3"},
+							{Name: "eventSource", Value: "Application"},
+							{Name: "eventID", Value: "1011"},
+						},
+					},
+				},
+				Message: "An application event log entry...",
+			},
+		},
+		{
+			name: "structured data only",
+			line: `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="Application" eventID="1011"][examplePriority@32473 class="high"]`,
+			expected: &Message{
+				Priority:  165,
+				Version:   1,
+				Timestamp: "2003-10-11T22:14:15.003Z",
+				Hostname:  "mymachine.example.com",
+				AppName:   "evntslog",
+				ProcID:    "-",
+				MsgID:     "ID47",
+				StructuredData: []SDElement{
+					{
+						ID: "exampleSDID@32473",
+						Params: []SDParam{
+							{Name: "iut", Value: "3"},
+							{Name: "eventSource", Value: "Application"},
+							{Name: "eventID", Value: "1011"},
+						},
+					},
+					{
+						ID:     "examplePriority@32473",
+						Params: []SDParam{{Name: "class", Value: "high"}},
+					},
+				},
+			},
+		},
+		{
+			name: "escaped param values",
+			line: `<14>1 - - - - - [test a="quote \" slash \\ bracket \] other \n" b=""][timeQuality] msg`,
+			expected: &Message{
+				Priority:  14,
+				Version:   1,
+				Timestamp: "-",
+				Hostname:  "-",
+				AppName:   "-",
+				ProcID:    "-",
+				MsgID:     "-",
+				StructuredData: []SDElement{
+					{
+						ID: "test",
+						Params: []SDParam{
+							{Name: "a", Value: `quote " slash \ bracket ] other \n`},
+							{Name: "b", Value: ""},
+						},
+					},
+					{ID: "timeQuality"},
+				},
+				Message: "msg",
+			},
+		},
+		{
+			name: "all nil",
+			line: "<0>1 - - - - - -",
+			expected: &Message{
+				Priority:  0,
+				Version:   1,
+				Timestamp: "-",
+				Hostname:  "-",
+				AppName:   "-",
+				ProcID:    "-",
+				MsgID:     "-",
+			},
+		},
+		{
+			name: "missing structured data",
+			line: "<33>5 1985-04-12T23:20:50.52Z test.com cron 304 - password accepted",
+			expected: &Message{
+				Priority:  33,
+				Version:   5,
+				Timestamp: "1985-04-12T23:20:50.52Z",
+				Hostname:  "test.com",
+				AppName:   "cron",
+				ProcID:    "304",
+				MsgID:     "-",
+				Message:   "password accepted",
+			},
+		},
+		{
+			name: "malformed structured data",
+			line: `<33>5 1985-04-12T23:20:50.52Z test.com apache - - [05/May/2015:21:19:52 +0000] "GET / HTTP/1.1"`,
+			expected: &Message{
+				Priority:  33,
+				Version:   5,
+				Timestamp: "1985-04-12T23:20:50.52Z",
+				Hostname:  "test.com",
+				AppName:   "apache",
+				ProcID:    "-",
+				MsgID:     "-",
+				Message:   `[05/May/2015:21:19:52 +0000] "GET / HTTP/1.1"`,
+			},
+		},
+		{
+			name: "structured data not followed by SP",
+			line: `<33>5 1985-04-12T23:20:50.52Z test.com app - - [a b="c"]d`,
+			expected: &Message{
+				Priority:  33,
+				Version:   5,
+				Timestamp: "1985-04-12T23:20:50.52Z",
+				Hostname:  "test.com",
+				AppName:   "app",
+				ProcID:    "-",
+				MsgID:     "-",
+				Message:   `[a b="c"]d`,
+			},
+		},
+		{
+			name: "multiline message",
+			line: "<1>0 2003-08-24T05:14:15.000003-07:00 test.com cron 65535 - - JVM NPE\nsome_file.java:48",
+			expected: &Message{
+				Priority:  1,
+				Version:   0,
+				Timestamp: "2003-08-24T05:14:15.000003-07:00",
+				Hostname:  "test.com",
+				AppName:   "cron",
+				ProcID:    "65535",
+				MsgID:     "-",
+				Message:   "JVM NPE\nsome_file.java:48",
+			},
+		},
+	}
+
+	p := NewParser()
+	for _, tt := range tests {
+		m, err := p.Parse([]byte(tt.line))
+		if err != nil {
+			t.Errorf("test %s: failed to parse: %s", tt.name, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(m, tt.expected) {
+			t.Errorf("test %s: wrong result\nexp: %+v\ngot: %+v", tt.name, tt.expected, m)
+		}
+	}
+}
+
+func Test_ParserParseInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"sshd is down",
+		"<>1 - - - - - -",
+		"<192>1 - - - - - -",
+		"<1234>1 - - - - - -",
+		"<34> 1 - - - - - -",
+		"<34>1",
+		"<34>1 2003-10-11T22:14:15.003Z host app",
+		"<34>1 2003-10-11T22:14:15.003Z  host app - - -",
+		"<34>1 2003-10-11T22:14:15.003Z host app - 0123456789012345678901234567890123 -",
+		"<34>1 2003-10-11T22:14:15.003Z h\x01st app - - -",
+	}
+
+	p := NewParser()
+	for _, line := range tests {
+		if m, err := p.Parse([]byte(line)); err == nil {
+			t.Errorf("parsing '%q' did not fail, got %+v", line, m)
+		}
+	}
+}
+
+func Test_ReaderReadLine(t *testing.T) {
+	r := NewReader(strings.NewReader("xxyyy\n<11>1 - host app - - sshd is down\n<22>1 - host app - - sshd is up"))
+
+	for _, exp := range []string{
+		"<11>1 - host app - - sshd is down",
+		"<22>1 - host app - - sshd is up",
+	} {
+		line, err := r.ReadLine()
+		if err != nil {
+			t.Fatalf("failed to read line: %s", err.Error())
+		}
+		if line != exp {
+			t.Fatalf("read line not correct, got %s, exp %s", line, exp)
+		}
+	}
+	if _, err := r.ReadLine(); err == nil {
+		t.Fatalf("expected error at end of input")
+	}
+}
@@ -3,8 +3,6 @@ package rfc5424
 
 import (
 	"io"
-	"regexp"
-	"strconv"
 )
 
 // Reader wraps an io.Reader object and returns valid RFC5424 log messages.
@@ -25,58 +23,18 @@ func NewReader(rdr io.Reader) *Reader {
 	return r
 }
 
-// ReadLine returns the next valid RFC5424 log message.
+// ReadLine returns the next valid RFC5424 log message. Any lines which are
+// not valid RFC5424 log messages are skipped.
 func (r *Reader) ReadLine() (string, error) {
-	return "", nil
-}
-
-// Parser parses an RFC5424-compliant log message.
-type Parser struct {
-	matcher *regexp.Regexp
-}
-
-// NewParser returns an instance of a Parser.
-func NewParser() *Parser {
-	p := &Parser{}
-	p.compileMatcher()
-	return p
-}
-
-// compileMatcher compiles the regex for RFC5424 log messages. If it fails
-// to compile, it panics.
-func (p *Parser) compileMatcher() {
-	leading := `(?s)`
-	pri := `<([0-9]{1,3})>`
-	ver := `([0-9])`
-	ts := `([^ ]+)`
-	host := `([^ ]+)`
-	app := `([^ ]+)`
-	pid := `(-|[0-9]{1,5})`
-	id := `([\w-]+)`
-	msg := `(.+$)`
-	p.matcher = regexp.MustCompile(leading + pri + ver + `\s` + ts + `\s` + host + `\s` + app + `\s` + pid + `\s` + id + `\s` + msg)
-}
-
-// parse attempts to parse the given byte slice.
-func (p *Parser) parse(raw []byte, result *map[string]interface{}) {
-	m := p.matcher.FindStringSubmatch(string(raw))
-	if m == nil || len(m) != 9 {
-		return
-	}
-	pri, _ := strconv.Atoi(m[1])
-	ver, _ := strconv.Atoi(m[2])
-	var pid int
-	if m[6] != "-" {
-		pid, _ = strconv.Atoi(m[6])
-	}
-	*result = map[string]interface{}{
-		"priority":   pri,
-		"version":    ver,
-		"timestamp":  m[3],
-		"host":       m[4],
-		"app":        m[5],
-		"pid":        pid,
-		"message_id": m[7],
-		"message":    m[8],
+	for {
+		line, err := r.d.ReadLine()
+		if line != "" {
+			if _, perr := r.p.Parse([]byte(line)); perr == nil {
+				return line, nil
+			}
+		}
+		if err != nil {
+			return "", err
+		}
 	}
 }
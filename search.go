@@ -0,0 +1,343 @@
+package ekanite
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	blevequery "github.com/blevesearch/bleve/search/query"
+	"github.com/ekanite/ekanite/input"
+	"github.com/ekanite/ekanite/parser"
+	"github.com/ekanite/ekanite/query"
+)
+
+// defaultSearchField is the field searched by any query term which does not
+// explicitly name a field.
+const defaultSearchField = "message"
+
+// fieldKind is the manner in which a field is indexed, and therefore how it is searched.
+type fieldKind int
+
+const (
+	textField    fieldKind = iota // Tokenized text
+	keywordField                  // A single, case-insensitive, token
+	numericField                  // A number
+	dynamicField                  // Indexed according to the type of each value
+)
+
+// searchField describes a field in the index.
+type searchField struct {
+	name string    // Name of the field in the index
+	kind fieldKind // How the field is indexed
+}
+
+// searchFields maps the fields of the Ekanite query language to the fields
+// in the index.
+var searchFields = map[string]searchField{
+	"message":     {name: "Message", kind: textField},
+	"source_ip":   {name: "source_ip", kind: keywordField},
+	"host":        {name: "host", kind: keywordField},
+	"app":         {name: "app", kind: keywordField},
+	"message_id":  {name: "message_id", kind: keywordField},
+	"pid":         {name: "pid", kind: numericField},
+	"procid":      {name: "procid", kind: keywordField},
+	"priority":    {name: "priority", kind: numericField},
+	"version":     {name: "version", kind: numericField},
+	"format":      {name: parser.FormatField, kind: keywordField},
+	"parse_error": {name: input.ParseErrorField, kind: keywordField},
+
+	// Watchguard fields.
+	"local_dtg":   {name: "local_dtg", kind: textField},
+	"model_name":  {name: "model_name", kind: keywordField},
+	"serial_no":   {name: "serial_no", kind: keywordField},
+	"component":   {name: "component", kind: keywordField},
+	"msg_id":      {name: "msg_id", kind: keywordField},
+	"disposition": {name: "disposition", kind: keywordField},
+	"src_intf":    {name: "src_intf", kind: keywordField},
+	"dst_intf":    {name: "dst_intf", kind: keywordField},
+}
+
+// searchFieldPrefixes maps the prefixes of fields of the Ekanite query language,
+// whose full names are only known once events are parsed, to how those fields
+// are indexed. Such fields have the same name in the index.
+var searchFieldPrefixes = map[string]fieldKind{
+	parser.SDFieldPrefix: textField, // RFC5424 STRUCTURED-DATA parameters
+}
+
+// lookupSearchField returns the index field for the given field of the Ekanite
+// query language. Any field not otherwise known, such as a field of a JSON log
+// message, has the same name in the index, and is indexed dynamically.
+func lookupSearchField(f string) searchField {
+	if field, ok := searchFields[f]; ok {
+		return field
+	}
+	for prefix, kind := range searchFieldPrefixes {
+		if strings.HasPrefix(f, prefix) && len(f) > len(prefix) {
+			return searchField{name: f, kind: kind}
+		}
+	}
+	return searchField{name: f, kind: dynamicField}
+}
+
+// numericSearchFields returns the names of all fields which must be searched
+// for a number.
+func numericSearchFields() []string {
+	var names []string
+	for f, field := range searchFields {
+		if field.kind == numericField {
+			names = append(names, f)
+		}
+	}
+	return names
+}
+
+// parseExpr parses the given Ekanite query. Any field may be searched, as fields
+// not yet indexed simply match no events. An empty query results in a nil
+// expression.
+func parseExpr(s string) (query.Expr, error) {
+	p := query.NewParser(strings.NewReader(s), defaultSearchField)
+	p.SetNumericFields(numericSearchFields())
+	return p.Parse()
+}
+
+// parseQuery parses the given Ekanite query, and returns the equivalent bleve
+// query. An empty query matches all documents.
+func parseQuery(s string) (blevequery.Query, error) {
+	expr, err := parseExpr(s)
+	if err != nil {
+		return nil, err
+	}
+	if expr == nil {
+		return bleve.NewMatchAllQuery(), nil
+	}
+	return buildQuery(expr)
+}
+
+// buildQuery translates the given query AST into a bleve query.
+func buildQuery(expr query.Expr) (blevequery.Query, error) {
+	switch expr := expr.(type) {
+	case *query.FieldExpr:
+		return buildFieldQuery(expr)
+	case *query.ParenExpr:
+		return buildQuery(expr.Expr)
+	case *query.BinaryExpr:
+		lhs, err := buildQuery(expr.LHS)
+		if err != nil {
+			return nil, err
+		}
+		rhs, err := buildQuery(expr.RHS)
+		if err != nil {
+			return nil, err
+		}
+
+		switch expr.Op {
+		case query.AND:
+			return bleve.NewConjunctionQuery(lhs, rhs), nil
+		case query.OR:
+			return bleve.NewDisjunctionQuery(lhs, rhs), nil
+		case query.NOT:
+			q := bleve.NewBooleanQuery()
+			q.AddMust(lhs)
+			q.AddMustNot(rhs)
+			return q, nil
+		}
+		return nil, fmt.Errorf("unsupported operator %s", expr.Op)
+	}
+	return nil, fmt.Errorf("unsupported expression %T", expr)
+}
+
+// buildFieldQuery returns a bleve query matching the term of the given field
+// expression. Text terms are analyzed in the same way as the field was at index
+// time, and all resulting tokens must match.
+func buildFieldQuery(expr *query.FieldExpr) (blevequery.Query, error) {
+	field := lookupSearchField(expr.Field)
+	if field.kind == dynamicField {
+		return buildDynamicFieldQuery(field.name, expr.Term), nil
+	}
+
+	if field.kind == numericField {
+		v, err := strconv.ParseFloat(expr.Term, 64)
+		if err != nil {
+			return nil, fmt.Errorf("field '%s' requires a numeric term, got '%s'", expr.Field, expr.Term)
+		}
+		inclusive := true
+		q := bleve.NewNumericRangeInclusiveQuery(&v, &v, &inclusive, &inclusive)
+		q.SetField(field.name)
+		return q, nil
+	}
+
+	q := bleve.NewMatchQuery(expr.Term)
+	q.SetField(field.name)
+	q.SetOperator(blevequery.MatchQueryOperatorAnd)
+	return q, nil
+}
+
+// buildDynamicFieldQuery returns a bleve query matching the term in a field
+// without an explicit mapping. Such a field may hold text, numbers or booleans,
+// depending on the event, so the term matches any value it could represent.
+func buildDynamicFieldQuery(field, term string) blevequery.Query {
+	text := bleve.NewMatchQuery(term)
+	text.SetField(field)
+	text.SetOperator(blevequery.MatchQueryOperatorAnd)
+	q := bleve.NewDisjunctionQuery(text)
+
+	if v, err := strconv.ParseFloat(term, 64); err == nil {
+		inclusive := true
+		n := bleve.NewNumericRangeInclusiveQuery(&v, &v, &inclusive, &inclusive)
+		n.SetField(field)
+		q.AddQuery(n)
+	}
+	if v, ok := parseBool(term); ok {
+		b := bleve.NewBoolFieldQuery(v)
+		b.SetField(field)
+		q.AddQuery(b)
+	}
+	return q
+}
+
+// parseBool parses the term true or false, in any case.
+func parseBool(s string) (bool, bool) {
+	switch strings.ToLower(s) {
+	case "true":
+		return true, true
+	case "false":
+		return false, true
+	}
+	return false, false
+}
+
+// Result is a single search result.
+type Result struct {
+	ID            DocID     // ID of the matching event
+	Index         string    // Name of the index containing the event
+	ReferenceTime time.Time // Reference time of the event
+	Event         *Event    // The event, including any parsed fields
+	Source        []byte    // The original received data
+	Err           error     // If set, the search failed, and no further results follow
+}
+
+// newResult returns the Result for the document with the given ID in the given index.
+func newResult(idx *Index, id DocID) (*Result, error) {
+	source, err := idx.Document(id)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := idx.Metadata(id)
+	if err != nil {
+		return nil, err
+	}
+	ev, err := newStoredEvent(source, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{
+		ID:            id,
+		Index:         idx.Name(),
+		ReferenceTime: id.Time(),
+		Event:         ev,
+		Source:        source,
+	}, nil
+}
+
+// Page is a page of search results, ordered by reference time.
+type Page struct {
+	Results   []*Result // Matching events
+	Cursor    DocID     // ID of the last event returned, for retrieving the next page
+	Truncated bool      // Whether more results follow this page
+	Total     uint64    // Total number of matching events, across all pages
+}
+
+// TimeRange is a range of reference times to search. Start is inclusive, and
+// End is exclusive. A zero Start or End leaves that side of the range unbounded.
+type TimeRange struct {
+	Start time.Time
+	End   time.Time
+}
+
+// ParseTimeRange parses a time range, relative to now. The range may be empty,
+// meaning all time, relative such as "last 2h" or "last 7d", or absolute such as
+// "2015-05-05T00:00:00Z to 2015-05-06T00:00:00Z". An absolute range may omit
+// its end time, in which case the range extends indefinitely.
+func ParseTimeRange(s string, now time.Time) (TimeRange, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return TimeRange{}, nil
+	}
+
+	if strings.HasPrefix(s, "last ") {
+		d, err := parseDuration(strings.TrimSpace(strings.TrimPrefix(s, "last ")))
+		if err != nil {
+			return TimeRange{}, fmt.Errorf("invalid time range '%s': %s", s, err.Error())
+		}
+		if d <= 0 {
+			return TimeRange{}, fmt.Errorf("invalid time range '%s': duration must be positive", s)
+		}
+		return TimeRange{Start: now.Add(-d)}, nil
+	}
+
+	var r TimeRange
+	var err error
+	parts := strings.SplitN(s, " to ", 2)
+	if r.Start, err = time.Parse(time.RFC3339, strings.TrimSpace(parts[0])); err != nil {
+		return TimeRange{}, fmt.Errorf("invalid start time '%s'", parts[0])
+	}
+	if len(parts) == 2 {
+		if r.End, err = time.Parse(time.RFC3339, strings.TrimSpace(parts[1])); err != nil {
+			return TimeRange{}, fmt.Errorf("invalid end time '%s'", parts[1])
+		}
+		if !r.End.After(r.Start) {
+			return TimeRange{}, fmt.Errorf("time range end %s is not after start %s", parts[1], parts[0])
+		}
+	}
+	return r, nil
+}
+
+// ParseTime parses a time, relative to now. The time may be an RFC3339 timestamp,
+// or a duration such as "15m" or "7d", meaning that long before now.
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	d, err := parseDuration(s)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("invalid time '%s'", s)
+	}
+	return now.Add(-d), nil
+}
+
+// IsZero returns whether the time range is unbounded.
+func (r TimeRange) IsZero() bool {
+	return r.Start.IsZero() && r.End.IsZero()
+}
+
+// Covers returns whether the time range includes all of the time between start
+// and end.
+func (r TimeRange) Covers(start, end time.Time) bool {
+	return (r.Start.IsZero() || !r.Start.After(start)) && (r.End.IsZero() || !r.End.Before(end))
+}
+
+// query returns a bleve query matching documents with a reference time within
+// the time range.
+func (r TimeRange) query() blevequery.Query {
+	startInclusive, endInclusive := true, false
+	q := bleve.NewDateRangeInclusiveQuery(r.Start, r.End, &startInclusive, &endInclusive)
+	q.SetField("ReferenceTime")
+	return q
+}
+
+// parseDuration parses a duration, as time.ParseDuration does, but also accepts
+// a whole number of days, such as "7d".
+func parseDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration %s", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,73 @@
+package ekanite
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeRange(t *testing.T) {
+	now := parseTime("1982-02-05T04:43:00Z")
+
+	tests := []struct {
+		s     string
+		start time.Time
+		end   time.Time
+		fail  bool
+	}{
+		{s: ""},
+		{s: "last 2h", start: parseTime("1982-02-05T02:43:00Z")},
+		{s: "last 15m", start: parseTime("1982-02-05T04:28:00Z")},
+		{s: " last 7d ", start: parseTime("1982-01-29T04:43:00Z")},
+		{s: "1982-02-05T00:00:00Z", start: parseTime("1982-02-05T00:00:00Z")},
+		{
+			s:     "1982-02-05T00:00:00Z to 1982-02-06T00:00:00Z",
+			start: parseTime("1982-02-05T00:00:00Z"),
+			end:   parseTime("1982-02-06T00:00:00Z"),
+		},
+		{s: "last", fail: true},
+		{s: "last 2x", fail: true},
+		{s: "last -2h", fail: true},
+		{s: "yesterday", fail: true},
+		{s: "1982-02-05T00:00:00Z to tomorrow", fail: true},
+		{s: "1982-02-06T00:00:00Z to 1982-02-05T00:00:00Z", fail: true},
+	}
+
+	for _, tt := range tests {
+		r, err := ParseTimeRange(tt.s, now)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("parsing '%s' did not fail", tt.s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("failed to parse '%s': %s", tt.s, err.Error())
+			continue
+		}
+		if !r.Start.Equal(tt.start) || !r.End.Equal(tt.end) {
+			t.Errorf("wrong time range for '%s', got %s - %s, exp %s - %s", tt.s, r.Start, r.End, tt.start, tt.end)
+		}
+	}
+}
+
+func TestTimeRange_Covers(t *testing.T) {
+	start := parseTime("1982-02-05T04:00:00Z")
+	end := parseTime("1982-02-05T05:00:00Z")
+
+	tests := []struct {
+		r      TimeRange
+		covers bool
+	}{
+		{r: TimeRange{}, covers: true},
+		{r: TimeRange{Start: start}, covers: true},
+		{r: TimeRange{Start: start.Add(time.Minute)}, covers: false},
+		{r: TimeRange{End: end}, covers: true},
+		{r: TimeRange{End: end.Add(-time.Minute)}, covers: false},
+		{r: TimeRange{Start: start.Add(-time.Hour), End: end.Add(time.Hour)}, covers: true},
+	}
+	for n, tt := range tests {
+		if tt.r.Covers(start, end) != tt.covers {
+			t.Errorf("test %d: time range has wrong coverage", n)
+		}
+	}
+}
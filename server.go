@@ -10,7 +10,10 @@ import (
 
 // Searcher is the interface any object that perform searches should implement.
 type Searcher interface {
-	Search(query string) (<-chan string, error)
+	Search(query string) (<-chan *Result, error)
+	SearchRange(query string, r TimeRange) (<-chan *Result, error)
+	SearchPage(query string, r TimeRange, after DocID, limit int) (*Page, error)
+	Tail(query string) (*Tail, error)
 }
 
 // Server serves query client connections.
@@ -78,16 +81,66 @@ func (s *Server) handleConnection(conn net.Conn) {
 			continue
 		}
 
+		if query == "tail" || strings.HasPrefix(query, "tail ") {
+			if !s.tail(conn, reader, strings.TrimSpace(strings.TrimPrefix(query, "tail"))) {
+				return
+			}
+			continue
+		}
+
 		s.Logger.Printf("executing query '%s'", query)
 		c, err := s.Searcher.Search(query)
 		if err != nil {
-			conn.Write([]byte(err.Error()))
+			conn.Write([]byte(err.Error() + "\n"))
 		} else {
-			for s := range c {
-				conn.Write([]byte(s + "\n"))
+			for r := range c {
+				if r.Err != nil {
+					conn.Write([]byte(r.Err.Error() + "\n"))
+					break
+				}
+				conn.Write(append(r.Source, '\n'))
 			}
 		}
 		// Send two newlines to indicate end-of-results.
 		conn.Write([]byte("\n\n"))
 	}
 }
+
+// tail streams events matching the given query to the connection as they are
+// indexed, until the client sends a line. It returns false if the connection
+// should be closed.
+func (s *Server) tail(conn net.Conn, reader *bufio.Reader, query string) bool {
+	t, err := s.Searcher.Tail(query)
+	if err != nil {
+		conn.Write([]byte(err.Error() + "\n\n\n"))
+		return true
+	}
+	defer t.Close()
+	s.Logger.Printf("tailing query '%s'", query)
+
+	// Any input from the client ends the tail.
+	done := make(chan error, 1)
+	go func() {
+		_, err := reader.ReadString('\n')
+		done <- err
+	}()
+
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				return false
+			}
+			// Send two newlines to indicate end-of-results.
+			conn.Write([]byte("\n\n"))
+			return true
+		case r, ok := <-t.C:
+			if !ok {
+				return false
+			}
+			if _, err := conn.Write(append(r.Source, '\n')); err != nil {
+				return false
+			}
+		}
+	}
+}
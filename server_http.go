@@ -1,13 +1,29 @@
 package ekanite
 
 import (
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/ekanite/ekanite/query"
+)
+
+// JSON search API defaults.
+const (
+	DefaultAPISearchLimit = 100
+	MaxAPISearchLimit     = 10000
+
+	apiSearchPath = "/api/v1/search"
+	apiTailPath   = "/api/v1/tail"
+
+	tailKeepAliveInterval = 15 * time.Second
 )
 
 // HTTPServer serves query client connections.
@@ -58,6 +74,11 @@ func (s *HTTPServer) Addr() net.Addr {
 func (s *HTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	dontCache(w, r)
 
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		s.serveAPI(w, r)
+		return
+	}
+
 	if r.Method == "GET" || r.Method == "HEAD" {
 		// HEAD is conveniently supported by net/http without further action
 		err := serveIndex(s, w, r)
@@ -81,28 +102,37 @@ func (s *HTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 
 		userQuery := r.FormValue("query")
+		timeRange, err := ParseTimeRange(r.FormValue("range"), time.Now().UTC())
+		if err != nil {
+			s.Logger.Printf("Error parsing time range: '%s'", err)
+			http.Error(w, "Error parsing time range: "+err.Error(), http.StatusBadRequest)
+			return
+		}
 		s.Logger.Printf("executing query '%s'", userQuery)
 
 		start := time.Now()
-		resultSet, err := s.Searcher.Search(userQuery)
+		resultSet, err := s.Searcher.SearchRange(userQuery, timeRange)
 		dur := time.Since(start)
-		var resultSlice []string
+		var resultSlice []*Result
 
 		if err != nil {
-			s.Logger.Printf("Error executing query: '%s'", err)
-			http.Error(w, "Error executing query: "+err.Error(), http.StatusInternalServerError)
+			s.writeQueryError(w, userQuery, err)
 			return
 		}
 
-		for s := range resultSet {
-			resultSlice = append(resultSlice, s)
+		for r := range resultSet {
+			if r.Err != nil {
+				s.writeQueryError(w, userQuery, r.Err)
+				return
+			}
+			resultSlice = append(resultSlice, r)
 		}
 
 		data := struct {
 			Title         string
 			Headline      string
 			ReturnResults bool
-			LogMessages   []string
+			Results       []*Result
 		}{
 			"Ekanite query interface",
 			fmt.Sprintf(`Ekanite - Listing %d results for "%s" (%s)`, len(resultSlice), userQuery, dur.String()),
@@ -119,18 +149,234 @@ func (s *HTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// apiHit is a single search result, as returned by the JSON API.
+type apiHit struct {
+	ID            DocID                  `json:"id"`
+	Index         string                 `json:"index"`
+	ReferenceTime time.Time              `json:"reference_time"`
+	ReceptionTime time.Time              `json:"reception_time"`
+	SourceIP      string                 `json:"source_ip,omitempty"`
+	Sequence      int64                  `json:"sequence,omitempty"`
+	Fields        map[string]interface{} `json:"fields,omitempty"`
+	Source        string                 `json:"source"`
+}
+
+// apiSearchResponse is the response to a search via the JSON API.
+type apiSearchResponse struct {
+	Query     string   `json:"query"`
+	Total     uint64   `json:"total"`
+	Count     int      `json:"count"`
+	Truncated bool     `json:"truncated"`
+	Cursor    DocID    `json:"cursor,omitempty"`
+	Took      float64  `json:"took_ms"`
+	Hits      []apiHit `json:"hits"`
+}
+
+// apiError is an error, as returned by the JSON API.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// serveAPI serves requests for the JSON API.
+func (s *HTTPServer) serveAPI(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != apiSearchPath && r.URL.Path != apiTailPath {
+		writeAPIError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		writeAPIError(w, http.StatusMethodNotAllowed, "unsupported method")
+		return
+	}
+
+	if r.URL.Path == apiTailPath {
+		s.serveAPITail(w, r)
+		return
+	}
+	s.serveAPISearch(w, r)
+}
+
+// serveAPISearch performs a search, and returns the results as JSON.
+func (s *HTTPServer) serveAPISearch(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	now := time.Now().UTC()
+
+	var timeRange TimeRange
+	var err error
+	if v := params.Get("from"); v != "" {
+		if timeRange.Start, err = ParseTime(v, now); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid 'from': "+err.Error())
+			return
+		}
+	}
+	if v := params.Get("to"); v != "" {
+		if timeRange.End, err = ParseTime(v, now); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid 'to': "+err.Error())
+			return
+		}
+	}
+
+	if !timeRange.Start.IsZero() && !timeRange.End.IsZero() && !timeRange.End.After(timeRange.Start) {
+		writeAPIError(w, http.StatusBadRequest, "invalid 'to': must be later than 'from'")
+		return
+	}
+
+	limit := DefaultAPISearchLimit
+	if v := params.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > MaxAPISearchLimit {
+			writeAPIError(w, http.StatusBadRequest,
+				fmt.Sprintf("invalid 'limit': must be between 1 and %d", MaxAPISearchLimit))
+			return
+		}
+	}
+
+	var cursor DocID
+	if v := params.Get("cursor"); v != "" {
+		if cursor, err = ParseDocID(v); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid 'cursor': "+err.Error())
+			return
+		}
+	}
+
+	userQuery := params.Get("q")
+	s.Logger.Printf("executing API query '%s'", userQuery)
+
+	start := time.Now()
+	page, err := s.Searcher.SearchPage(userQuery, timeRange, cursor, limit)
+	dur := time.Since(start)
+	if err != nil {
+		s.writeSearchError(w, userQuery, err)
+		return
+	}
+
+	resp := apiSearchResponse{
+		Query:     userQuery,
+		Total:     page.Total,
+		Count:     len(page.Results),
+		Truncated: page.Truncated,
+		Cursor:    page.Cursor,
+		Took:      float64(dur) / float64(time.Millisecond),
+		Hits:      make([]apiHit, 0, len(page.Results)),
+	}
+	for _, res := range page.Results {
+		resp.Hits = append(resp.Hits, newAPIHit(res))
+	}
+
+	writeAPIResponse(w, http.StatusOK, resp)
+}
+
+// writeSearchError writes the error returned by the Searcher for the given query.
+// Errors parsing the query are the fault of the client, while any other error
+// is a failure of the server.
+func (s *HTTPServer) writeSearchError(w http.ResponseWriter, userQuery string, err error) {
+	if _, ok := err.(*query.ParseError); ok {
+		writeAPIError(w, http.StatusBadRequest, "invalid query: "+err.Error())
+		return
+	}
+	s.Logger.Printf("Error executing API query '%s': %s", userQuery, err)
+	writeAPIError(w, http.StatusInternalServerError, "search failed")
+}
+
+// writeQueryError writes the error returned by the Searcher for the given query
+// to the query interface, classifying it as writeSearchError does.
+func (s *HTTPServer) writeQueryError(w http.ResponseWriter, userQuery string, err error) {
+	if _, ok := err.(*query.ParseError); ok {
+		http.Error(w, "Error executing query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.Logger.Printf("Error executing query '%s': %s", userQuery, err)
+	http.Error(w, "Error executing query", http.StatusInternalServerError)
+}
+
+// serveAPITail streams events matching a standing query to the client, as they
+// are indexed, using Server-Sent Events. Each event is sent as a single JSON hit.
+func (s *HTTPServer) serveAPITail(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeAPIError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+
+	userQuery := r.URL.Query().Get("q")
+	tail, err := s.Searcher.Tail(userQuery)
+	if err != nil {
+		s.writeSearchError(w, userQuery, err)
+		return
+	}
+	defer tail.Close()
+	s.Logger.Printf("tailing query '%s' for %s", userQuery, r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(tailKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			s.Logger.Printf("tail of query '%s' for %s closed", userQuery, r.RemoteAddr)
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case res, ok := <-tail.C:
+			if !ok {
+				return
+			}
+			b, err := json.Marshal(newAPIHit(res))
+			if err != nil {
+				s.Logger.Printf("Error encoding tail event: %s", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %s\ndata: %s\n\n", res.ID, b); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// newAPIHit returns the JSON API representation of the given search result.
+func newAPIHit(res *Result) apiHit {
+	return apiHit{
+		ID:            res.ID,
+		Index:         res.Index,
+		ReferenceTime: res.ReferenceTime,
+		ReceptionTime: res.Event.ReceptionTime,
+		SourceIP:      res.Event.SourceIP,
+		Sequence:      res.Event.Sequence,
+		Fields:        res.Event.Parsed,
+		Source:        string(res.Source),
+	}
+}
+
+// writeAPIResponse writes the given value as the JSON response.
+func writeAPIResponse(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeAPIError writes the given error message as the JSON response.
+func writeAPIError(w http.ResponseWriter, code int, msg string) {
+	writeAPIResponse(w, code, apiError{Error: msg})
+}
+
 // serveIndex serves the plain index for the GET request and POST failovers
 func serveIndex(s *HTTPServer, w http.ResponseWriter, r *http.Request) error {
 	data := struct {
 		Title         string
 		Headline      string
 		ReturnResults bool
-		LogMessages   []string
+		Results       []*Result
 	}{
 		"Ekanite query interface",
 		"Ekanite query interface",
 		false,
-		[]string{},
+		[]*Result{},
 	}
 
 	return s.template.Execute(w, data)
@@ -182,22 +428,27 @@ hr {
 textarea {
 	margin: 20px 20px 20px 0;
 }
+.meta {
+	color: #7f8c8d;
+}
 </style>
 </head>
 <body>
 	<h2>{{ $.Headline }}</h2>
-	<div id="help">Query language reference: <a href="http://godoc.org/github.com/blevesearch/bleve#NewQueryStringQuery">bleve</a></div>
+	<div id="help">Query language reference: <a href="http://godoc.org/github.com/ekanite/ekanite/query">Ekanite</a></div>
 	<form action="/" method="POST">
     <textarea name="query" cols="100" rows="2"></textarea>
     <br>
+    Time range: <input name="range" type="text" size="50" placeholder="last 2h, or 2015-05-05T00:00:00Z to 2015-05-06T00:00:00Z">
+    <br><br>
     <input name="submit" type="submit" class="button" value="Query">
 	</form>
 
 {{ if $.ReturnResults }}
 	<hr>
 	<ul>
-	{{range $message := $.LogMessages }}
-	<li>{{ $message }}</li>
+	{{range $result := $.Results }}
+	<li><span class="meta" title="index {{ $result.Index }}, received {{ $result.Event.ReceptionTime.Format "2006-01-02T15:04:05.999999Z07:00" }} from {{ $result.Event.SourceIP }}">{{ $result.ReferenceTime.Format "2006-01-02T15:04:05.999999Z07:00" }}</span> {{ printf "%s" $result.Source }}</li>
 	{{ end }}
 	</ul>
 {{ end }}
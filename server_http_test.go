@@ -0,0 +1,199 @@
+package ekanite
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPServer_APISearch(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+	e := NewEngine(dataDir)
+	e.IndexDuration = time.Hour
+
+	line1 := "<134>1 1982-02-05T04:43:00Z web01 nginx 1999 - GET /index.html"
+	line2 := "<134>1 1982-02-05T05:43:00Z web02 nginx 2000 - GET /index.html"
+	line3 := "<134>1 1982-02-05T06:43:00Z web01 nginx 1999 - POST /login"
+	events := []*Event{
+		newParsedEvent(line1, "web01", "nginx", 1999, "10.0.0.1:1234"),
+		newParsedEvent(line2, "web02", "nginx", 2000, "10.0.0.2:1234"),
+		newParsedEvent(line3, "web01", "nginx", 1999, "10.0.0.1:1234"),
+	}
+	if err := e.Index(events); err != nil {
+		t.Fatalf("failed to index events: %s", err.Error())
+	}
+	s := NewHTTPServer("", e)
+
+	search := func(params url.Values) (int, *apiSearchResponse) {
+		req := httptest.NewRequest("GET", apiSearchPath+"?"+params.Encode(), nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			return w.Code, nil
+		}
+		var resp apiSearchResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to decode API response: %s", err.Error())
+		}
+		return w.Code, &resp
+	}
+
+	code, resp := search(url.Values{"q": {"app:nginx"}, "limit": {"2"}})
+	if code != http.StatusOK {
+		t.Fatalf("wrong status code for search, got %d", code)
+	}
+	if resp.Total != 3 || resp.Count != 2 || !resp.Truncated || len(resp.Hits) != 2 {
+		t.Fatalf("wrong first page: %+v", resp)
+	}
+	if resp.Hits[0].Source != line1 || resp.Hits[0].Index != "19820205_0400" ||
+		resp.Hits[0].SourceIP != "10.0.0.1:1234" || resp.Hits[0].Fields["host"] != "web01" {
+		t.Fatalf("wrong first hit: %+v", resp.Hits[0])
+	}
+	if resp.Cursor != resp.Hits[1].ID {
+		t.Fatalf("wrong cursor, got %s, exp %s", resp.Cursor, resp.Hits[1].ID)
+	}
+
+	code, resp = search(url.Values{"q": {"app:nginx"}, "limit": {"2"}, "cursor": {string(resp.Cursor)}})
+	if code != http.StatusOK {
+		t.Fatalf("wrong status code for search, got %d", code)
+	}
+	if resp.Count != 1 || resp.Truncated || resp.Hits[0].Source != line3 {
+		t.Fatalf("wrong second page: %+v", resp)
+	}
+
+	code, resp = search(url.Values{"q": {"GET"}, "from": {"1982-02-05T05:00:00Z"}, "to": {"1982-02-05T06:00:00Z"}})
+	if code != http.StatusOK {
+		t.Fatalf("wrong status code for search, got %d", code)
+	}
+	if resp.Total != 1 || resp.Hits[0].Source != line2 {
+		t.Fatalf("wrong time range results: %+v", resp)
+	}
+
+	for _, params := range []url.Values{
+		{"q": {"pid:root"}},
+		{"q": {"GET AND"}},
+		{"q": {"GET"}, "limit": {"0"}},
+		{"q": {"GET"}, "limit": {"abc"}},
+		{"q": {"GET"}, "from": {"yesterday"}},
+		{"q": {"GET"}, "from": {"1982-02-05T06:00:00Z"}, "to": {"1982-02-05T05:00:00Z"}},
+		{"q": {"GET"}, "from": {"1982-02-05T06:00:00Z"}, "to": {"1982-02-05T06:00:00Z"}},
+		{"q": {"GET"}, "cursor": {"1234"}},
+	} {
+		if code, _ := search(params); code != http.StatusBadRequest {
+			t.Fatalf("wrong status code for %v, got %d, exp %d", params, code, http.StatusBadRequest)
+		}
+	}
+
+	req := httptest.NewRequest("POST", apiSearchPath, nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("wrong status code for POST, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/api/v2/search", nil)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("wrong status code for unknown API path, got %d", w.Code)
+	}
+}
+
+// failingSearcher is a Searcher which fails every search. If streamed is set,
+// searches fail once started, rather than returning the error at once.
+type failingSearcher struct {
+	err      error
+	streamed bool
+}
+
+func (f *failingSearcher) Search(query string) (<-chan *Result, error) {
+	return f.SearchRange(query, TimeRange{})
+}
+
+func (f *failingSearcher) SearchRange(query string, r TimeRange) (<-chan *Result, error) {
+	if !f.streamed {
+		return nil, f.err
+	}
+	c := make(chan *Result, 1)
+	c <- &Result{Err: f.err}
+	close(c)
+	return c, nil
+}
+
+func (f *failingSearcher) SearchPage(query string, r TimeRange, after DocID, limit int) (*Page, error) {
+	return nil, f.err
+}
+
+func (f *failingSearcher) Tail(query string) (*Tail, error) {
+	return nil, f.err
+}
+
+func TestHTTPServer_APIErrors(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+	e := NewEngine(dataDir)
+
+	get := func(s *HTTPServer, path string, params url.Values) int {
+		req := httptest.NewRequest("GET", path+"?"+params.Encode(), nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	tests := []struct {
+		searcher Searcher
+		path     string
+		params   url.Values
+		code     int
+	}{
+		{searcher: e, path: apiSearchPath, params: url.Values{"q": {"pid:root"}}, code: http.StatusBadRequest},
+		{searcher: e, path: apiSearchPath, params: url.Values{"q": {"user:root"}}, code: http.StatusOK},
+		{searcher: e, path: apiTailPath, params: url.Values{"q": {"pid:root"}}, code: http.StatusBadRequest},
+		{searcher: e, path: apiTailPath, params: url.Values{"q": {"GET AND"}}, code: http.StatusBadRequest},
+		{searcher: e, path: apiSearchPath, params: url.Values{"q": {"GET"}, "from": {"1h"}, "to": {"2h"}}, code: http.StatusBadRequest},
+		{searcher: &failingSearcher{err: errors.New("shard unavailable")}, path: apiSearchPath, params: url.Values{"q": {"GET"}}, code: http.StatusInternalServerError},
+		{searcher: &failingSearcher{err: errors.New("shard unavailable")}, path: apiTailPath, params: url.Values{"q": {"GET"}}, code: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if code := get(NewHTTPServer("", tt.searcher), tt.path, tt.params); code != tt.code {
+			t.Fatalf("wrong status code for %s %v, got %d, exp %d", tt.path, tt.params, code, tt.code)
+		}
+	}
+}
+
+func TestHTTPServer_QueryErrors(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+	e := NewEngine(dataDir)
+
+	post := func(s *HTTPServer, params url.Values) int {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(params.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	tests := []struct {
+		searcher Searcher
+		params   url.Values
+		code     int
+	}{
+		{searcher: e, params: url.Values{"query": {"GET AND"}}, code: http.StatusBadRequest},
+		{searcher: e, params: url.Values{"query": {"GET"}, "range": {"invalid"}}, code: http.StatusBadRequest},
+		{searcher: &failingSearcher{err: errors.New("shard unavailable")}, params: url.Values{"query": {"GET"}}, code: http.StatusInternalServerError},
+		{searcher: &failingSearcher{err: errors.New("shard unavailable"), streamed: true}, params: url.Values{"query": {"GET"}}, code: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if code := post(NewHTTPServer("", tt.searcher), tt.params); code != tt.code {
+			t.Fatalf("wrong status code for %v, got %d, exp %d", tt.params, code, tt.code)
+		}
+	}
+}
@@ -36,9 +36,10 @@ type Service struct {
 // NewService returns an initialized Service object.
 func NewService(addr string) *Service {
 	return &Service{
-		addr:   addr,
-		start:  time.Now(),
-		logger: log.New(os.Stderr, "[status] ", log.LstdFlags),
+		addr:      addr,
+		start:     time.Now(),
+		providers: make(map[string]Provider),
+		logger:    log.New(os.Stderr, "[status] ", log.LstdFlags),
 	}
 }
 
@@ -0,0 +1,244 @@
+package ekanite
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/blevesearch/bleve/analysis"
+	"github.com/ekanite/ekanite/query"
+)
+
+// tailBufferSize is the number of matching events buffered for each Tail. Once
+// the buffer is full, further matching events are dropped until the receiver
+// catches up.
+const tailBufferSize = 1000
+
+// Tail is a standing query. Events which match the query are sent on C as they
+// are indexed.
+type Tail struct {
+	C <-chan *Result
+
+	c     chan *Result
+	match matcher
+	tails *tails
+}
+
+// Close stops delivery of events, and closes C.
+func (t *Tail) Close() {
+	t.tails.remove(t)
+}
+
+// tails is the set of registered Tails.
+type tails struct {
+	mu sync.RWMutex
+	m  map[*Tail]struct{}
+}
+
+// add registers a Tail for the given query.
+func (ts *tails) add(q string) (*Tail, error) {
+	expr, err := parseExpr(q)
+	if err != nil {
+		return nil, err
+	}
+	m, err := buildMatcher(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := make(chan *Result, tailBufferSize)
+	t := &Tail{C: c, c: c, match: m, tails: ts}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.m == nil {
+		ts.m = make(map[*Tail]struct{})
+	}
+	ts.m[t] = struct{}{}
+	stats.Add("tailsActive", 1)
+	return t, nil
+}
+
+// remove unregisters the given Tail, and closes its channel.
+func (ts *tails) remove(t *Tail) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if _, ok := ts.m[t]; !ok {
+		return
+	}
+	delete(ts.m, t)
+	close(t.c)
+	stats.Add("tailsActive", -1)
+}
+
+// publish sends each of the given events, indexed in the given index, to every
+// Tail with a matching query. It never blocks.
+func (ts *tails) publish(idx *Index, events []Document) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	if len(ts.m) == 0 {
+		return
+	}
+
+	for _, d := range events {
+		ev, ok := d.(*Event)
+		if !ok {
+			continue
+		}
+		data := ev.Data().(map[string]interface{})
+
+		for t := range ts.m {
+			if !t.match(data) {
+				continue
+			}
+			res := &Result{
+				ID:            ev.ID(),
+				Index:         idx.Name(),
+				ReferenceTime: ev.ReferenceTime(),
+				Event:         ev,
+				Source:        ev.Source(),
+			}
+			select {
+			case t.c <- res:
+				stats.Add("tailEventsTx", 1)
+			default:
+				stats.Add("tailEventsDropped", 1)
+			}
+		}
+	}
+}
+
+// matcher reports whether the indexable data of an event matches a query.
+type matcher func(data map[string]interface{}) bool
+
+// buildMatcher returns a matcher for the given query AST. A nil expression
+// matches all events.
+func buildMatcher(expr query.Expr) (matcher, error) {
+	im, err := buildIndexMapping()
+	if err != nil {
+		return nil, err
+	}
+	analyzers := map[fieldKind]*analysis.Analyzer{
+		textField:    im.AnalyzerNamed("ekanite"),
+		keywordField: im.AnalyzerNamed("ekanite_keyword"),
+	}
+	return buildExprMatcher(expr, analyzers)
+}
+
+// buildExprMatcher returns a matcher for the given query AST, analyzing text
+// using the analyzer for the kind of field being matched.
+func buildExprMatcher(expr query.Expr, analyzers map[fieldKind]*analysis.Analyzer) (matcher, error) {
+	switch expr := expr.(type) {
+	case nil:
+		return func(map[string]interface{}) bool { return true }, nil
+	case *query.FieldExpr:
+		return buildFieldMatcher(expr, analyzers)
+	case *query.ParenExpr:
+		return buildExprMatcher(expr.Expr, analyzers)
+	case *query.BinaryExpr:
+		lhs, err := buildExprMatcher(expr.LHS, analyzers)
+		if err != nil {
+			return nil, err
+		}
+		rhs, err := buildExprMatcher(expr.RHS, analyzers)
+		if err != nil {
+			return nil, err
+		}
+
+		switch expr.Op {
+		case query.AND:
+			return func(d map[string]interface{}) bool { return lhs(d) && rhs(d) }, nil
+		case query.OR:
+			return func(d map[string]interface{}) bool { return lhs(d) || rhs(d) }, nil
+		case query.NOT:
+			return func(d map[string]interface{}) bool { return lhs(d) && !rhs(d) }, nil
+		}
+		return nil, fmt.Errorf("unsupported operator %s", expr.Op)
+	}
+	return nil, fmt.Errorf("unsupported expression %T", expr)
+}
+
+// buildFieldMatcher returns a matcher for the given field expression, which
+// matches in the same manner as the equivalent bleve query built by buildFieldQuery.
+func buildFieldMatcher(expr *query.FieldExpr, analyzers map[fieldKind]*analysis.Analyzer) (matcher, error) {
+	field := lookupSearchField(expr.Field)
+	if field.kind == dynamicField {
+		return buildDynamicFieldMatcher(field.name, expr.Term, analyzers[textField]), nil
+	}
+
+	if field.kind == numericField {
+		v, err := strconv.ParseFloat(expr.Term, 64)
+		if err != nil {
+			return nil, fmt.Errorf("field '%s' requires a numeric term, got '%s'", expr.Field, expr.Term)
+		}
+		return func(d map[string]interface{}) bool {
+			n, ok := toFloat(d[field.name])
+			return ok && n == v
+		}, nil
+	}
+
+	analyzer := analyzers[field.kind]
+	terms := analyze(analyzer, expr.Term)
+	return func(d map[string]interface{}) bool {
+		s, ok := d[field.name].(string)
+		return ok && matchTerms(analyzer, s, terms)
+	}, nil
+}
+
+// buildDynamicFieldMatcher returns a matcher for a field without an explicit
+// mapping, which matches in the same manner as the bleve query built by
+// buildDynamicFieldQuery.
+func buildDynamicFieldMatcher(field, term string, analyzer *analysis.Analyzer) matcher {
+	terms := analyze(analyzer, term)
+	n, err := strconv.ParseFloat(term, 64)
+	numeric := err == nil
+	b, boolean := parseBool(term)
+	return func(d map[string]interface{}) bool {
+		switch v := d[field].(type) {
+		case string:
+			return matchTerms(analyzer, v, terms)
+		case bool:
+			return boolean && v == b
+		default:
+			f, ok := toFloat(v)
+			return ok && numeric && f == n
+		}
+	}
+}
+
+// matchTerms returns whether analyzing s results in all of the given terms.
+func matchTerms(analyzer *analysis.Analyzer, s string, terms []string) bool {
+	tokens := make(map[string]bool)
+	for _, t := range analyze(analyzer, s) {
+		tokens[t] = true
+	}
+	for _, t := range terms {
+		if !tokens[t] {
+			return false
+		}
+	}
+	return len(terms) > 0
+}
+
+// analyze returns the terms resulting from analyzing the given text.
+func analyze(analyzer *analysis.Analyzer, s string) []string {
+	var terms []string
+	for _, t := range analyzer.Analyze([]byte(s)) {
+		terms = append(terms, string(t.Term))
+	}
+	return terms
+}
+
+// toFloat converts a numeric value to a float64, returning false if the value
+// is not numeric.
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
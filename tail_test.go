@@ -0,0 +1,115 @@
+package ekanite
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTail_Matcher(t *testing.T) {
+	line := "<134>1 1982-02-05T04:43:00Z web01 nginx 1999 - GET /wp-login.php HTTP/1.1"
+	data := newParsedEvent(line, "Web01", "nginx", 1999, "10.0.0.1:1234").Data().(map[string]interface{})
+	data["sd.origin.ip"] = "192.168.0.1"
+	data["level"] = "error"
+	data["user_id"] = int64(1234)
+	data["retry"] = true
+
+	tests := []struct {
+		query string
+		match bool
+	}{
+		{query: "", match: true},
+		{query: "login", match: true},
+		{query: "LOGIN", match: true},
+		{query: "wp-login", match: true},
+		{query: "wp-logout", match: false},
+		{query: "log", match: false},
+		{query: "host:web01", match: true},
+		{query: "host:web", match: false},
+		{query: "app:nginx AND pid:1999", match: true},
+		{query: "app:nginx AND pid:2000", match: false},
+		{query: "app:sshd OR priority:134", match: true},
+		{query: "GET NOT POST", match: true},
+		{query: "GET NOT login", match: false},
+		{query: "source_ip:10.0.0.1", match: true},
+		{query: "(POST OR PUT) AND host:web01", match: false},
+		{query: "message_id:abc", match: false},
+		{query: "sd.origin.ip:192.168.0.1", match: true},
+		{query: "sd.origin.software:nginx", match: false},
+		{query: "level:error AND user_id:1234", match: true},
+		{query: "level:ERROR", match: true},
+		{query: "user_id:1235", match: false},
+		{query: "retry:true", match: true},
+		{query: "retry:false", match: false},
+		{query: "user:root", match: false},
+	}
+
+	for _, tt := range tests {
+		expr, err := parseExpr(tt.query)
+		if err != nil {
+			t.Fatalf("failed to parse '%s': %s", tt.query, err.Error())
+		}
+		m, err := buildMatcher(expr)
+		if err != nil {
+			t.Fatalf("failed to build matcher for '%s': %s", tt.query, err.Error())
+		}
+		if m(data) != tt.match {
+			t.Errorf("wrong match result for query '%s', exp %v", tt.query, tt.match)
+		}
+	}
+}
+
+func TestEngine_Tail(t *testing.T) {
+	dataDir := tempPath()
+	defer os.RemoveAll(dataDir)
+	e := NewEngine(dataDir)
+
+	if _, err := e.Tail("pid:root"); err == nil {
+		t.Fatalf("tail of invalid query did not return an error")
+	}
+
+	tail, err := e.Tail("host:web01")
+	if err != nil {
+		t.Fatalf("failed to tail: %s", err.Error())
+	}
+
+	line1 := "<134>1 1982-02-05T04:43:00Z web01 nginx 1999 - GET /index.html"
+	line2 := "<134>1 1982-02-05T04:43:01Z web02 nginx 2000 - GET /index.html"
+	line3 := "<134>1 1982-02-05T04:43:02Z web01 sshd 22 - password accepted"
+	events := []*Event{
+		newParsedEvent(line1, "web01", "nginx", 1999, "10.0.0.1:1234"),
+		newParsedEvent(line2, "web02", "nginx", 2000, "10.0.0.2:1234"),
+		newParsedEvent(line3, "web01", "sshd", 22, "10.0.0.1:1234"),
+	}
+	if err := e.Index(events); err != nil {
+		t.Fatalf("failed to index events: %s", err.Error())
+	}
+
+	got := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		select {
+		case r := <-tail.C:
+			got[string(r.Source)] = true
+			if r.Index != "19820205_0000" || r.ID == "" {
+				t.Fatalf("tailed result has wrong index or ID: %s, %s", r.Index, r.ID)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for tailed events")
+		}
+	}
+	if !got[line1] || !got[line3] {
+		t.Fatalf("wrong events tailed, got %v", got)
+	}
+
+	select {
+	case r := <-tail.C:
+		t.Fatalf("unexpected event tailed: %s", r.Source)
+	default:
+	}
+
+	tail.Close()
+	if _, ok := <-tail.C; ok {
+		t.Fatalf("tail channel not closed")
+	}
+	tail.Close() // Closing twice should be harmless.
+}